@@ -1,26 +1,133 @@
 package main
 
 import (
+	"flag"
 	"fmt"
-	i "group-11/pkg/inputdata"
-	l "group-11/pkg/lexical_analayzer"
+	cg "group-11/pkg/codegen"
+	"group-11/pkg/compiler"
+	"group-11/pkg/diag"
+	"group-11/pkg/interp"
+	s "group-11/pkg/scanner"
+	"group-11/pkg/source"
+	"os"
 	"runtime"
-	"sync"
-
+	"strings"
 )
 
+// Extensions of the files generated for each target.
+var extensions = map[string]string{"wasm": "wasm", "c": "c", "x86": "s", "llvm": "ll", "js": "js", "bytecode": "p0b"}
+
 func main() {
-	var wg sync.WaitGroup
-	inputData := i.NewInputData("../../config/p0test.txt")
+	sequential := flag.Bool("seq", false, "run the stages one after the other instead of concurrently")
+	output := flag.String("o", "", "name of the generated file, result with the extension of the target by default")
+	target := flag.String("target", "wasm", "what to generate code for: "+strings.Join(compiler.Targets, ", ")+"; llvm IR has typed pointers, for LLVM 16 or older")
+	format := flag.String("format", "wasm", "what to generate for WASM: \"wasm\" for a binary module, \"wat\" for WebAssembly text")
+	idents := flag.String("idents", "", "check identifiers: \"nfc\" normalizes them, \"confusable\" rejects look-alikes")
+	utf16Columns := flag.Bool("utf16", false, "count columns in UTF-16 code units")
+	diagFormat := flag.String("diag", "caret", "how to print diagnostics: \"caret\" shows the source, \"gcc\" one line each, \"json\" for tools")
+	flag.Parse()
+	// "p0 run file.p0" interprets the program instead of compiling it.
+	run := flag.NArg() > 0 && flag.Arg(0) == "run"
+	if run {
+		flag.CommandLine.Parse(flag.Args()[1:])
+	}
+	fileName := "../../config/p0test.txt"
+	if flag.NArg() > 0 {
+		fileName = flag.Arg(0)
+	}
+
+	// A file name of "-" reads the program from standard input.
+	fs := source.NewFileSet()
+	var file *source.File
+	var err error
+	if fileName == "-" {
+		file, err = fs.AddStdin()
+	} else {
+		file, err = fs.AddFile(fileName)
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+
+	opts := s.Options{UTF16Columns: *utf16Columns}
+	switch *idents {
+	case "":
+	case "nfc":
+		opts.Idents = s.IdentNFC
+	case "confusable":
+		opts.Idents = s.IdentRejectConfusable
+	default:
+		fmt.Fprintln(os.Stderr, "unknown -idents setting "+*idents)
+		os.Exit(2)
+	}
+	if *format != "wasm" && *format != "wat" {
+		fmt.Fprintln(os.Stderr, "unknown -format setting "+*format)
+		os.Exit(2)
+	}
+	if *output == "" {
+		*output = "result." + extensions[*target]
+		if *target == "wasm" {
+			*output = "result." + *format
+		}
+	}
+	if *diagFormat != "caret" && *diagFormat != "gcc" && *diagFormat != "json" {
+		fmt.Fprintln(os.Stderr, "unknown -diag setting "+*diagFormat)
+		os.Exit(2)
+	}
+
+	c := compiler.New(file, opts)
+	if err := c.SetTarget(*target); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+	if run {
+		prog := c.Analyze()
+		writeDiagnostics(*diagFormat, fs, c.Diagnostics)
+		if prog == nil {
+			fmt.Fprintln(os.Stderr, "not run: "+file.Name+" has errors")
+			os.Exit(1)
+		}
+		if err := interp.Run(prog, os.Stdin, os.Stdout); err != nil {
+			fmt.Fprintln(os.Stderr, file.Name+":"+err.Error())
+			os.Exit(1)
+		}
+		return
+	}
 	runtime.GOMAXPROCS(runtime.NumCPU())
-	// Add items to the wait group, one for each goroutine.
-	wg.Add(3)
-	go l.EatWhiteSpace(inputData, &wg)
-	go l.EatComments(inputData, &wg)
-	go l.ParseInput(inputData, &wg)
-	// Wait for the waitgroup counter to reach zero before continuing.
-	// The waitgroup counter is decremented each time a thread finishes
-	// executing its procedure.
-	wg.Wait()
-	fmt.Println("Done all tasks")
-}
\ No newline at end of file
+	var code string
+	var stats compiler.Stats
+	if *sequential {
+		code, stats = c.Sequential()
+	} else {
+		// Source chunks -> lexer -> parser, connected by bounded channels.
+		code, stats = c.Pipeline()
+	}
+	writeDiagnostics(*diagFormat, fs, c.Diagnostics)
+	if c.Failed() {
+		fmt.Fprintln(os.Stderr, "no code generated: "+file.Name+" has errors")
+		os.Exit(1)
+	}
+	if *target == "wasm" && *format == "wasm" {
+		err = cg.WriteWasmBinary(*output, code)
+	} else {
+		err = cg.WriteFile(*output, code)
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	fmt.Println("Done all tasks: " + stats.String())
+}
+
+// Prints diagnostics to standard error in the format selected with -diag.
+func writeDiagnostics(format string, fs *source.FileSet, list diag.List) {
+	switch format {
+	case "caret":
+		diag.WriteCaret(os.Stderr, fs, list)
+	case "gcc":
+		diag.WriteText(os.Stderr, fs, list)
+	case "json":
+		diag.WriteJSON(os.Stderr, fs, list)
+	}
+}
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"group-11/pkg/bytecode"
+	"os"
+)
+
+// Runs a P0 bytecode file, as generated with -target=bytecode, reading
+// standard input and writing standard output.
+func main() {
+	disassemble := flag.Bool("d", false, "print the instructions instead of running them")
+	trace := flag.Bool("trace", false, "print every instruction to standard error before running it")
+	flag.Parse()
+	if flag.NArg() != 1 {
+		fmt.Fprintln(os.Stderr, "usage: p0vm [-d] [-trace] file.p0b")
+		os.Exit(2)
+	}
+	data, err := os.ReadFile(flag.Arg(0))
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	p, err := bytecode.Decode(data)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, flag.Arg(0)+": "+err.Error())
+		os.Exit(1)
+	}
+	if *disassemble {
+		fmt.Print(p.Disassemble())
+		return
+	}
+	host := bytecode.NewIOHost(os.Stdin, os.Stdout)
+	vm := bytecode.NewVM(p, host)
+	if *trace {
+		vm.Trace = func(pc int, in bytecode.Instr, stack []int32) {
+			fmt.Fprintf(os.Stderr, "%6d  %-16s %v\n", pc, in, stack)
+		}
+	}
+	err = vm.Run()
+	host.Flush()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, flag.Arg(0)+": "+err.Error())
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,46 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"group-11/pkg/wasm"
+	"os"
+	"strings"
+)
+
+// Runs a WebAssembly module generated for P0, in the binary format or as
+// WebAssembly text, reading standard input and writing standard output.
+func main() {
+	steps := flag.Int("steps", 0, "stop after this many instructions, 0 for no limit")
+	flag.Parse()
+	if flag.NArg() != 1 {
+		fmt.Fprintln(os.Stderr, "usage: p0wasm [-steps n] file.wasm|file.wat")
+		os.Exit(2)
+	}
+	data, err := os.ReadFile(flag.Arg(0))
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	var m *wasm.Module
+	if strings.HasPrefix(string(data), "\x00asm") {
+		m, err = wasm.Decode(data)
+	} else {
+		m, err = wasm.Parse(string(data))
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, flag.Arg(0)+": "+err.Error())
+		os.Exit(1)
+	}
+	imports, flush := wasm.P0lib(os.Stdin, os.Stdout)
+	inst, err := wasm.Instantiate(m, imports)
+	if err == nil {
+		inst.MaxSteps = *steps
+		err = inst.Start()
+	}
+	flush()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, flag.Arg(0)+": "+err.Error())
+		os.Exit(1)
+	}
+}
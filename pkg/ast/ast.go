@@ -0,0 +1,289 @@
+package ast
+
+import (
+	"group-11/pkg/source"
+	st "group-11/pkg/symtable"
+)
+
+// Any node of the syntax tree.
+type Node interface {
+	Span() source.Span
+}
+
+// Where a node was found in the source; embedded in every node.
+type Loc struct {
+	Src source.Span
+}
+
+// Returns the source text range covered by the node.
+func (l *Loc) Span() source.Span {
+	return l.Src
+}
+
+// Returns a Loc covering the given span.
+func At(span source.Span) Loc {
+	return Loc{Src: span}
+}
+
+// Returns a Loc from the start of one node to the end of another.
+func Between(from Node, to Node) Loc {
+	span := from.Span()
+	span.End = to.Span().End
+	return Loc{Src: span}
+}
+
+// What the parser found out about an expression while checking it.
+type ExprInfo struct {
+	Type  *st.SymTableEntry // Type of the value: Tp for integers and booleans, Ctp and ArrOrRec for arrays and records.
+	Const bool              // Whether the value is known at compile time.
+	Val   int               // The value, if Const.
+}
+
+// Returns the checked type and value of the expression.
+func (x *ExprInfo) Info() *ExprInfo {
+	return x
+}
+
+// Expressions: designators, numbers and operators applied to expressions.
+type Expr interface {
+	Node
+	Info() *ExprInfo
+	exprNode()
+}
+
+// Statements.
+type Stmt interface {
+	Node
+	stmtNode()
+}
+
+// Declarations of constants, types, variables and procedures.
+type Decl interface {
+	Node
+	declNode()
+}
+
+// Type denoters: type names, arrays, records and enumerations.
+type TypeExpr interface {
+	Node
+	typeNode()
+}
+
+// A complete P0 program.
+type Program struct {
+	Loc
+	Name  *Ident
+	Decls []Decl
+	Body  *CompoundStmt
+}
+
+// An identifier, either where it is declared or where it is used.
+type Ident struct {
+	Loc
+	ExprInfo
+	Name string
+	Obj  *st.SymTableEntry // Entry the identifier denotes, nil if it is undeclared.
+}
+
+// A number literal.
+type Number struct {
+	Loc
+	ExprInfo
+	Lexeme string
+}
+
+// A unary +, - or not applied to an operand.
+type UnaryExpr struct {
+	Loc
+	ExprInfo
+	Op int // Operator token kind.
+	X  Expr
+}
+
+// An arithmetic, boolean or relational operator applied to two operands.
+type BinaryExpr struct {
+	Loc
+	ExprInfo
+	Op int // Operator token kind.
+	X  Expr
+	Y  Expr
+}
+
+// An expression in parentheses.
+type ParenExpr struct {
+	Loc
+	ExprInfo
+	X Expr
+}
+
+// A record field selection x.f.
+type SelectorExpr struct {
+	Loc
+	ExprInfo
+	X     Expr
+	Field *Ident // Obj is the field entry of the record type.
+}
+
+// An array element x[i].
+type IndexExpr struct {
+	Loc
+	ExprInfo
+	X     Expr
+	Index Expr
+}
+
+// A call of a function in an expression.
+type CallExpr struct {
+	Loc
+	ExprInfo
+	Proc *Ident
+	Args []Expr
+}
+
+// An expression that could not be parsed.
+type BadExpr struct {
+	Loc
+	ExprInfo
+}
+
+// An assignment x := y.
+type AssignStmt struct {
+	Loc
+	Lhs Expr
+	Rhs Expr
+}
+
+// A call of a procedure or standard procedure.
+type CallStmt struct {
+	Loc
+	Proc *Ident
+	Args []Expr
+}
+
+// An if statement; Else is nil if there is no else part.
+type IfStmt struct {
+	Loc
+	Cond Expr
+	Then Stmt
+	Else Stmt
+}
+
+// A while loop.
+type WhileStmt struct {
+	Loc
+	Cond Expr
+	Body Stmt
+}
+
+// Statements between begin and end.
+type CompoundStmt struct {
+	Loc
+	Stmts []Stmt
+}
+
+// A statement that could not be parsed.
+type BadStmt struct {
+	Loc
+}
+
+// A constant declaration.
+type ConstDecl struct {
+	Loc
+	Name  *Ident
+	Value Expr
+}
+
+// A type declaration.
+type TypeDecl struct {
+	Loc
+	Name *Ident
+	Type TypeExpr
+}
+
+// Variables of one type, declared in a var section or as record fields.
+type VarDecl struct {
+	Loc
+	Names []*Ident
+	Type  TypeExpr
+}
+
+// A procedure with its formal parameters, local declarations and body. A
+// function also has a result type and the expression after return at the end
+// of its body.
+type ProcDecl struct {
+	Loc
+	Name   *Ident
+	Params []*Param
+	Result TypeExpr // nil for procedures.
+	Decls  []Decl
+	Body   *CompoundStmt
+	Return Expr // nil for procedures.
+}
+
+// Formal parameters of one type, passed by reference if Ref is set.
+type Param struct {
+	Loc
+	Ref   bool
+	Names []*Ident
+	Type  TypeExpr
+}
+
+// A type given by name.
+type NamedType struct {
+	Loc
+	Name *Ident
+}
+
+// An array type with constant bounds, or indexed by an enumeration type.
+type ArrayType struct {
+	Loc
+	Lower Expr
+	Upper Expr
+	Index TypeExpr // The enumeration type instead of the bounds, nil if bounds are given.
+	Elem  TypeExpr
+}
+
+// A record type.
+type RecordType struct {
+	Loc
+	Fields []*VarDecl
+}
+
+// An enumeration type, which declares its values as constants.
+type EnumType struct {
+	Loc
+	Values []*Ident
+}
+
+// A type that could not be parsed.
+type BadType struct {
+	Loc
+}
+
+func (*Ident) exprNode()        {}
+func (*Number) exprNode()       {}
+func (*UnaryExpr) exprNode()    {}
+func (*BinaryExpr) exprNode()   {}
+func (*ParenExpr) exprNode()    {}
+func (*SelectorExpr) exprNode() {}
+func (*IndexExpr) exprNode()    {}
+func (*CallExpr) exprNode()     {}
+func (*BadExpr) exprNode()      {}
+
+func (*AssignStmt) stmtNode()   {}
+func (*CallStmt) stmtNode()     {}
+func (*IfStmt) stmtNode()       {}
+func (*WhileStmt) stmtNode()    {}
+func (*CompoundStmt) stmtNode() {}
+func (*BadStmt) stmtNode()      {}
+
+func (*ConstDecl) declNode() {}
+func (*TypeDecl) declNode()  {}
+func (*VarDecl) declNode()   {}
+func (*ProcDecl) declNode()  {}
+
+func (*NamedType) typeNode()  {}
+func (*ArrayType) typeNode()  {}
+func (*RecordType) typeNode() {}
+func (*EnumType) typeNode()   {}
+func (*BadType) typeNode()    {}
@@ -0,0 +1,104 @@
+package ast
+
+// Calls f for node and, if f returns true, for each of its children in source
+// order. Nil children are skipped.
+func Inspect(node Node, f func(Node) bool) {
+	if node == nil || !f(node) {
+		return
+	}
+	for _, c := range children(node) {
+		Inspect(c, f)
+	}
+}
+
+// Returns the non-nil children of a node in source order.
+func children(node Node) []Node {
+	var cs []Node
+	add := func(ns ...Node) {
+		for _, n := range ns {
+			if n != nil {
+				cs = append(cs, n)
+			}
+		}
+	}
+	switch n := node.(type) {
+	case *Program:
+		add(n.Name)
+		for _, d := range n.Decls {
+			add(d)
+		}
+		add(n.Body)
+	case *UnaryExpr:
+		add(n.X)
+	case *BinaryExpr:
+		add(n.X, n.Y)
+	case *ParenExpr:
+		add(n.X)
+	case *SelectorExpr:
+		add(n.X, n.Field)
+	case *IndexExpr:
+		add(n.X, n.Index)
+	case *AssignStmt:
+		add(n.Lhs, n.Rhs)
+	case *CallStmt:
+		add(n.Proc)
+		for _, a := range n.Args {
+			add(a)
+		}
+	case *CallExpr:
+		add(n.Proc)
+		for _, a := range n.Args {
+			add(a)
+		}
+	case *IfStmt:
+		add(n.Cond, n.Then, n.Else)
+	case *WhileStmt:
+		add(n.Cond, n.Body)
+	case *CompoundStmt:
+		for _, s := range n.Stmts {
+			add(s)
+		}
+	case *ConstDecl:
+		add(n.Name, n.Value)
+	case *TypeDecl:
+		add(n.Name, n.Type)
+	case *VarDecl:
+		for _, id := range n.Names {
+			add(id)
+		}
+		add(n.Type)
+	case *ProcDecl:
+		add(n.Name)
+		for _, p := range n.Params {
+			add(p)
+		}
+		if n.Result != nil {
+			add(n.Result)
+		}
+		for _, d := range n.Decls {
+			add(d)
+		}
+		add(n.Body)
+		if n.Return != nil {
+			add(n.Return)
+		}
+	case *Param:
+		for _, id := range n.Names {
+			add(id)
+		}
+		add(n.Type)
+	case *NamedType:
+		add(n.Name)
+	case *ArrayType:
+		add(n.Lower, n.Upper, n.Index, n.Elem)
+	case *RecordType:
+		for _, f := range n.Fields {
+			add(f)
+		}
+	case *EnumType:
+		for _, id := range n.Values {
+			add(id)
+		}
+	}
+	return cs
+}
@@ -0,0 +1,359 @@
+// P0 bytecode: the instructions of a stack machine, the file format compiled
+// programs are stored in, and a virtual machine that runs them.
+//
+// Memory is a sequence of 32-bit words: the global variables first, then a
+// frame for every procedure being executed. Word 0 of a frame is its static
+// link, the frame of the procedure it is declared in; the parameters follow,
+// then the local variables. Values are computed on a separate operand stack.
+//
+// A file is little endian:
+//
+//	header          "P0BC", version (2 bytes), words of global variables (4), index of the main procedure (4)
+//	constant pool   count (4), then every constant (4)
+//	procedures      count (4), then every procedure: name length (2), name, entry, parameters, frame words, level (4 each)
+//	code            count (4), then every instruction: opcode (1), operands (4 each)
+//	line table      count (4), then pairs of the first instruction of a source line and the line (4 each)
+package bytecode
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Opcodes of the instructions.
+type Op byte
+
+const (
+	CONST Op = iota + 1 // CONST i: pushes constant i of the pool.
+	GADDR               // GADDR a: pushes the address of global word a.
+	LADDR               // LADDR d a: pushes the address of word a of the frame d static links up.
+	LOAD                // Replaces an address with the word there.
+	STORE               // Pops an address and a value and stores the value there.
+	MOVE                // MOVE n: pops a source and a destination address and copies n words.
+	ADD                 // Arithmetic on the two topmost values, wrapping around.
+	SUB
+	MUL
+	DIV // Traps on division by zero and overflow.
+	MOD // Traps on division by zero.
+	NEG
+	NOT
+	EQ // Relations push 1 if true, otherwise 0.
+	NE
+	LT
+	GT
+	LE
+	GE
+	DUP  // Pushes the topmost value again.
+	POP  // Pops a value.
+	SWAP // Swaps the two topmost values.
+	JMP  // JMP t: continues at instruction t.
+	JZ   // JZ t: pops a value and continues at instruction t if it is 0.
+	JNZ  // JNZ t: pops a value and continues at instruction t if it is not 0.
+	LINK // LINK d: pushes the frame d static links up, the static link of a call.
+	CALL // CALL p: calls procedure p with its parameters and static link on the stack.
+	RET  // Returns from a procedure; a function leaves its result on the stack.
+	READ // Pushes the next integer of the input, 0 at its end.
+	WRITE
+	WRITELN
+	HALT
+	BOUND // BOUND n: traps unless the topmost value, an array index, is at least 0 and less than n.
+	RANGE // RANGE n: traps unless the topmost value, the ordinal number of a value of an enumeration, is at least 0 and less than n.
+)
+
+// Names and numbers of operands of the instructions.
+var opNames = map[Op]string{CONST: "const", GADDR: "gaddr", LADDR: "laddr", LOAD: "load", STORE: "store", MOVE: "move",
+	ADD: "add", SUB: "sub", MUL: "mul", DIV: "div", MOD: "mod", NEG: "neg", NOT: "not",
+	EQ: "eq", NE: "ne", LT: "lt", GT: "gt", LE: "le", GE: "ge", DUP: "dup", POP: "pop", SWAP: "swap",
+	JMP: "jmp", JZ: "jz", JNZ: "jnz", LINK: "link", CALL: "call", RET: "ret",
+	READ: "read", WRITE: "write", WRITELN: "writeln", HALT: "halt", BOUND: "bound", RANGE: "range"}
+var arity = map[Op]int{CONST: 1, GADDR: 1, LADDR: 2, MOVE: 1, JMP: 1, JZ: 1, JNZ: 1, LINK: 1, CALL: 1, BOUND: 1, RANGE: 1}
+
+// Number of values the instructions need on the operand stack, apart from the
+// parameters and static link CALL takes.
+var needs = map[Op]int{LOAD: 1, STORE: 2, MOVE: 2, ADD: 2, SUB: 2, MUL: 2, DIV: 2, MOD: 2, NEG: 1, NOT: 1,
+	EQ: 2, NE: 2, LT: 2, GT: 2, LE: 2, GE: 2, DUP: 1, POP: 1, SWAP: 2, JZ: 1, JNZ: 1, WRITE: 1, BOUND: 1, RANGE: 1}
+
+// Returns the name of an opcode.
+func (op Op) String() string {
+	if n, ok := opNames[op]; ok {
+		return n
+	}
+	return fmt.Sprintf("op%d", byte(op))
+}
+
+// An instruction with its operands.
+type Instr struct {
+	Op   Op
+	A, B int32
+}
+
+// Returns the instruction in assembler notation, such as "laddr 1 3".
+func (in Instr) String() string {
+	switch arity[in.Op] {
+	case 1:
+		return fmt.Sprintf("%s %d", in.Op, in.A)
+	case 2:
+		return fmt.Sprintf("%s %d %d", in.Op, in.A, in.B)
+	}
+	return in.Op.String()
+}
+
+// An entry of the procedure table.
+type Proc struct {
+	Name   string
+	Entry  int // Index of the first instruction.
+	Params int // Words of parameters.
+	Frame  int // Words of the frame: static link, parameters and local variables.
+	Level  int // Scope level of the body, 0 for the main program.
+}
+
+// The first instruction generated for a source line.
+type Line struct {
+	PC   int
+	Line int
+}
+
+// A compiled program.
+type Program struct {
+	Globals   int // Words of global variables.
+	Main      int // Index of the main program in Procs.
+	Constants []int32
+	Procs     []Proc
+	Code      []Instr
+	Lines     []Line // Sorted by PC.
+}
+
+// Returns the source line of the instruction at pc, 0 if unknown.
+func (p *Program) LineOf(pc int) int {
+	i := sort.Search(len(p.Lines), func(i int) bool { return p.Lines[i].PC > pc })
+	if i == 0 {
+		return 0
+	}
+	return p.Lines[i-1].Line
+}
+
+// Returns the program in assembler notation, one instruction per line, with
+// the procedures and source lines as comments.
+func (p *Program) Disassemble() string {
+	b := strings.Builder{}
+	fmt.Fprintf(&b, "; globals %d, constants %v\n", p.Globals, p.Constants)
+	line := 0
+	for pc, in := range p.Code {
+		for _, pr := range p.Procs {
+			if pr.Entry == pc {
+				fmt.Fprintf(&b, "%s: ; params %d, frame %d, level %d\n", pr.Name, pr.Params, pr.Frame, pr.Level)
+			}
+		}
+		if l := p.LineOf(pc); l != line {
+			line = l
+			fmt.Fprintf(&b, "%6d  %-16s ; line %d\n", pc, in, l)
+		} else {
+			fmt.Fprintf(&b, "%6d  %s\n", pc, in)
+		}
+	}
+	return b.String()
+}
+
+const (
+	magic   = "P0BC"
+	version = 1
+)
+
+// Returns the program in the file format.
+func Encode(p *Program) []byte {
+	b := []byte(magic)
+	u16 := func(x int) { b = binary.LittleEndian.AppendUint16(b, uint16(x)) }
+	u32 := func(x int) { b = binary.LittleEndian.AppendUint32(b, uint32(x)) }
+	u16(version)
+	u32(p.Globals)
+	u32(p.Main)
+	u32(len(p.Constants))
+	for _, c := range p.Constants {
+		u32(int(c))
+	}
+	u32(len(p.Procs))
+	for _, pr := range p.Procs {
+		u16(len(pr.Name))
+		b = append(b, pr.Name...)
+		u32(pr.Entry)
+		u32(pr.Params)
+		u32(pr.Frame)
+		u32(pr.Level)
+	}
+	u32(len(p.Code))
+	for _, in := range p.Code {
+		b = append(b, byte(in.Op))
+		if arity[in.Op] > 0 {
+			u32(int(in.A))
+		}
+		if arity[in.Op] > 1 {
+			u32(int(in.B))
+		}
+	}
+	u32(len(p.Lines))
+	for _, l := range p.Lines {
+		u32(l.PC)
+		u32(l.Line)
+	}
+	return b
+}
+
+// Reads a program in the file format and checks that its instructions refer
+// to existing constants, procedures and instructions.
+func Decode(data []byte) (*Program, error) {
+	d := decoder{data: data}
+	if len(data) < len(magic) || string(data[:len(magic)]) != magic {
+		return nil, errors.New("not a P0 bytecode file")
+	}
+	d.pos = len(magic)
+	if v := d.u16(); v != version {
+		return nil, fmt.Errorf("bytecode version %d, expected %d", v, version)
+	}
+	p := &Program{Globals: d.u32(), Main: d.u32()}
+	for n := d.count(4); n > 0; n-- {
+		p.Constants = append(p.Constants, int32(d.u32()))
+	}
+	for n := d.count(18); n > 0; n-- {
+		pr := Proc{Name: d.bytes(d.u16())}
+		pr.Entry, pr.Params, pr.Frame, pr.Level = d.u32(), d.u32(), d.u32(), d.u32()
+		p.Procs = append(p.Procs, pr)
+	}
+	for n := d.count(1); n > 0; n-- {
+		in := Instr{Op: Op(d.byte())}
+		if _, ok := opNames[in.Op]; !ok && d.err == nil {
+			d.err = fmt.Errorf("unknown opcode %d at instruction %d", in.Op, len(p.Code))
+		}
+		if arity[in.Op] > 0 {
+			in.A = int32(d.u32())
+		}
+		if arity[in.Op] > 1 {
+			in.B = int32(d.u32())
+		}
+		p.Code = append(p.Code, in)
+	}
+	for n := d.count(8); n > 0; n-- {
+		p.Lines = append(p.Lines, Line{PC: d.u32(), Line: d.u32()})
+	}
+	if d.err != nil {
+		return nil, d.err
+	}
+	if d.pos != len(data) {
+		return nil, errors.New("unexpected data at the end of the file")
+	}
+	return p, check(p)
+}
+
+// Checks the references of a decoded program. The instructions of a procedure
+// run from its entry to the entry of the next one; LADDR and LINK in them
+// follow no more static links than the level of the procedure allows.
+func check(p *Program) error {
+	if p.Globals < 0 || p.Globals > MaxWords {
+		return fmt.Errorf("%d words of global variables, at most %d allowed", p.Globals, MaxWords)
+	}
+	if p.Main < 0 || p.Main >= len(p.Procs) {
+		return fmt.Errorf("main procedure %d does not exist", p.Main)
+	}
+	for _, pr := range p.Procs {
+		if pr.Entry < 0 || pr.Entry >= len(p.Code) || pr.Frame < 1+pr.Params && pr.Level > 0 {
+			return fmt.Errorf("procedure %s is invalid", pr.Name)
+		}
+	}
+	procs := append([]Proc{}, p.Procs...)
+	sort.Slice(procs, func(i, j int) bool { return procs[i].Entry < procs[j].Entry })
+	levels := make([]int, len(p.Code))
+	for i, pr := range procs {
+		end := len(p.Code)
+		if i+1 < len(procs) {
+			end = procs[i+1].Entry
+		}
+		for pc := pr.Entry; pc < end; pc++ {
+			levels[pc] = pr.Level
+		}
+	}
+	for pc, in := range p.Code {
+		bad := false
+		switch in.Op {
+		case CONST:
+			bad = in.A < 0 || int(in.A) >= len(p.Constants)
+		case GADDR:
+			bad = in.A < 0 || int(in.A) >= p.Globals
+		case CALL:
+			// The main program has no frame and cannot be called.
+			bad = in.A < 0 || int(in.A) >= len(p.Procs) || p.Procs[in.A].Frame < 1+p.Procs[in.A].Params
+		case JMP, JZ, JNZ:
+			bad = in.A < 0 || int(in.A) >= len(p.Code)
+		case LADDR:
+			bad = in.A < 0 || int(in.A) >= levels[pc]
+		case LINK:
+			bad = in.A < 0 || int(in.A) > levels[pc]
+		case MOVE:
+			bad = in.A < 0
+		case BOUND, RANGE:
+			bad = in.A < 1
+		}
+		if bad {
+			return fmt.Errorf("invalid operand in %s at instruction %d", in, pc)
+		}
+	}
+	return nil
+}
+
+// Reads the file format, remembering the first error.
+type decoder struct {
+	data []byte
+	pos  int
+	err  error
+}
+
+func (d *decoder) need(n int) bool {
+	if d.err == nil && d.pos+n > len(d.data) {
+		d.err = errors.New("unexpected end of the file")
+	}
+	return d.err == nil
+}
+
+func (d *decoder) byte() byte {
+	if !d.need(1) {
+		return 0
+	}
+	d.pos += 1
+	return d.data[d.pos-1]
+}
+
+func (d *decoder) u16() int {
+	if !d.need(2) {
+		return 0
+	}
+	d.pos += 2
+	return int(binary.LittleEndian.Uint16(d.data[d.pos-2:]))
+}
+
+func (d *decoder) u32() int {
+	if !d.need(4) {
+		return 0
+	}
+	d.pos += 4
+	return int(int32(binary.LittleEndian.Uint32(d.data[d.pos-4:])))
+}
+
+func (d *decoder) bytes(n int) string {
+	if !d.need(n) {
+		return ""
+	}
+	d.pos += n
+	return string(d.data[d.pos-n : d.pos])
+}
+
+// Reads a count of items of at least size bytes each, which must fit in the
+// rest of the file.
+func (d *decoder) count(size int) int {
+	n := d.u32()
+	if n < 0 || d.err == nil && n > (len(d.data)-d.pos)/size {
+		d.err = errors.New("count larger than the file")
+		return 0
+	}
+	return n
+}
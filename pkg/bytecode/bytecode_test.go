@@ -0,0 +1,75 @@
+package bytecode
+
+import (
+	"io"
+	"strings"
+	"testing"
+)
+
+// Decode rejects static link depths beyond the level of the procedure, which
+// the VM would otherwise follow for a very long time.
+func TestDecodeLinkDepth(t *testing.T) {
+	tests := []struct {
+		in Instr
+		ok bool
+	}{
+		{Instr{Op: LADDR, A: 1, B: 1}, true},
+		{Instr{Op: LADDR, A: 2, B: 1}, false},
+		{Instr{Op: LADDR, A: 1426063360, B: 2}, false},
+		{Instr{Op: LINK, A: 2}, true},
+		{Instr{Op: LINK, A: 3}, false},
+	}
+	for _, test := range tests {
+		p := &Program{
+			Procs: []Proc{
+				{Name: "inner", Entry: 0, Params: 1, Frame: 2, Level: 2},
+				{Name: "main", Entry: 2},
+			},
+			Main: 1,
+			Code: []Instr{test.in, {Op: RET}, {Op: HALT}},
+		}
+		_, err := Decode(Encode(p))
+		if (err == nil) != test.ok {
+			t.Errorf("%s: error %v", test.in, err)
+		}
+	}
+}
+
+// Decode rejects headers with more global variables than fit in memory.
+func TestDecodeGlobals(t *testing.T) {
+	for _, globals := range []int{-1, MaxWords + 1, 1 << 30} {
+		p := &Program{Globals: globals, Procs: []Proc{{Name: "main"}}, Code: []Instr{{Op: HALT}}}
+		if _, err := Decode(Encode(p)); err == nil {
+			t.Errorf("%d globals accepted", globals)
+		}
+	}
+	p := &Program{Globals: MaxWords, Procs: []Proc{{Name: "main"}}, Code: []Instr{{Op: HALT}}}
+	if _, err := Decode(Encode(p)); err != nil {
+		t.Errorf("%d globals: %v", MaxWords, err)
+	}
+}
+
+// Malformed programs stop with a RuntimeError instead of a Go panic.
+func TestRunErrors(t *testing.T) {
+	tests := []struct {
+		globals int
+		code    []Instr
+		msg     string
+	}{
+		{-1, []Instr{{Op: HALT}}, "words of global variables"},
+		{1, []Instr{{Op: CONST}, {Op: LOAD}, {Op: HALT}}, "address 5 out of memory"},
+		{1, []Instr{{Op: CONST}, {Op: GADDR}, {Op: STORE}, {Op: GADDR}, {Op: CONST}, {Op: STORE}, {Op: HALT}}, "address 5 out of memory"},
+		{1, []Instr{{Op: GADDR}, {Op: GADDR}, {Op: MOVE, A: 2}, {Op: HALT}}, "out of memory"},
+		{1, []Instr{{Op: ADD}, {Op: HALT}}, "operand stack underflow in add"},
+		{1, []Instr{{Op: CONST}, {Op: BOUND, A: 5}, {Op: HALT}}, "index out of bounds"},
+		{1, []Instr{{Op: CONST}, {Op: RANGE, A: 5}, {Op: HALT}}, "value out of range"},
+		{1, []Instr{{Op: RET}}, "return from the main program"},
+	}
+	for _, test := range tests {
+		p := &Program{Globals: test.globals, Constants: []int32{5}, Procs: []Proc{{Name: "main"}}, Code: test.code}
+		err := NewVM(p, NewIOHost(strings.NewReader(""), io.Discard)).Run()
+		if err == nil || !strings.Contains(err.Error(), test.msg) {
+			t.Errorf("%v: error %v, want %q", test.code, err, test.msg)
+		}
+	}
+}
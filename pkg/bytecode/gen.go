@@ -0,0 +1,507 @@
+package bytecode
+
+import (
+	"group-11/pkg/diag"
+	k "group-11/pkg/keywords"
+	"group-11/pkg/source"
+	st "group-11/pkg/symtable"
+)
+
+// Bytecode generator: a codegen.Target whose GenProgExit returns the program in
+// the file format. Items are symbol table entries as for WASM: a "var" is in
+// memory at Adr of the frame of level Lev, a "ref" holds the address of its
+// value, and Lev -1 means that the value or the address is on the stack.
+// Sizes and addresses are in words.
+type Emitter struct {
+	Program *Program
+	Curlev  int         // Current scope level.
+	At      source.Span // Construct being generated, for error messages.
+	consts  map[int32]int
+	procs   []map[string]int // Procedure table index of every procedure, per scope level.
+	frames  []*procFrame
+	loops   []int
+	errors  diag.Handler
+}
+
+// A procedure whose code is being generated.
+type procFrame struct {
+	proc   int
+	copies [][3]int // Record and array value parameters: slot, offset of the copy, words.
+}
+
+// Creates an Emitter that reports errors to errh.
+func NewEmitter(errh diag.Handler) *Emitter {
+	return &Emitter{Program: &Program{}, consts: map[int32]int{}, procs: []map[string]int{{}}, errors: errh}
+}
+
+// Reports an error at the construct being generated.
+func (e *Emitter) mark(msg string) {
+	e.errors(diag.NewError("E400", e.At, msg))
+}
+
+// Sets the construct being generated, for error messages.
+func (e *Emitter) SetAt(span source.Span) {
+	e.At = span
+}
+
+// Appends an instruction and returns its index, noting the source line.
+func (e *Emitter) emit(op Op, operands ...int) int {
+	in := Instr{Op: op}
+	if len(operands) > 0 {
+		in.A = int32(operands[0])
+	}
+	if len(operands) > 1 {
+		in.B = int32(operands[1])
+	}
+	p := e.Program
+	if n := len(p.Lines); e.At.Start.Line > 0 && (n == 0 || p.Lines[n-1].Line != e.At.Start.Line) {
+		p.Lines = append(p.Lines, Line{PC: len(p.Code), Line: e.At.Start.Line})
+	}
+	p.Code = append(p.Code, in)
+	return len(p.Code) - 1
+}
+
+// Sets the target of the jump at pc to the next instruction.
+func (e *Emitter) patch(pc int) {
+	e.Program.Code[pc].A = int32(len(e.Program.Code))
+}
+
+// Generates pushing a constant from the pool.
+func (e *Emitter) constant(v int) {
+	i, ok := e.consts[int32(v)]
+	if !ok {
+		i = len(e.Program.Constants)
+		e.Program.Constants = append(e.Program.Constants, int32(v))
+		e.consts[int32(v)] = i
+	}
+	e.emit(CONST, i)
+}
+
+// Generates pushing the address of a variable in memory.
+func (e *Emitter) address(x *st.SymTableEntry) {
+	if x.Lev == 0 {
+		e.emit(GADDR, x.Adr)
+	} else {
+		e.emit(LADDR, e.Curlev-x.Lev, x.Adr)
+	}
+}
+
+// Reports whether an item is on the stack.
+func onStack(x *st.SymTableEntry) bool {
+	return x.Lev == -1 && x.EntryType != "const"
+}
+
+// Generates pushing the value of an item; a value on the stack stays there.
+func (e *Emitter) load(x *st.SymTableEntry) {
+	if x.EntryType == "const" {
+		e.constant(x.Val)
+	} else if x.EntryType == "var" && x.Lev == -1 {
+		//pass
+	} else if x.EntryType == "var" {
+		e.address(x)
+		e.emit(LOAD)
+	} else if x.EntryType == "ref" && x.Lev == -1 {
+		e.emit(LOAD)
+	} else if x.EntryType == "ref" {
+		e.address(x)
+		e.emit(LOAD)
+		e.emit(LOAD)
+	} else {
+		e.mark("bytecode: cannot load")
+	}
+}
+
+// Generates pushing the address of a variable; an address on the stack stays
+// there.
+func (e *Emitter) pushAddress(x *st.SymTableEntry) {
+	if x.EntryType == "var" && x.Lev >= 0 {
+		e.address(x)
+	} else if x.EntryType == "ref" && x.Lev >= 0 {
+		e.address(x)
+		e.emit(LOAD)
+	} else if x.EntryType != "ref" {
+		e.mark("bytecode: not a variable")
+	}
+}
+
+// Generates the value of y on top of the value of x, or of its address if
+// value is false. Either may be on the stack already, x below y.
+func (e *Emitter) operands(x *st.SymTableEntry, y *st.SymTableEntry, value bool) {
+	push := func() {
+		if value {
+			e.load(x)
+		} else {
+			e.pushAddress(x)
+		}
+	}
+	if !onStack(y) {
+		push()
+		e.load(y)
+	} else if !onStack(x) {
+		e.load(y)
+		push()
+		e.emit(SWAP)
+	} else {
+		e.load(y)
+		if value && x.EntryType == "ref" {
+			e.emit(SWAP)
+			e.emit(LOAD)
+			e.emit(SWAP)
+		}
+	}
+}
+
+// Returns an item for a value on the stack.
+func pushed(tp st.PrimitiveType) *st.SymTableEntry {
+	y := st.Var(tp)
+	y.Lev = -1
+	return y
+}
+
+// Generates the start of programs.
+func (e *Emitter) GenProgStart() {
+	//pass
+}
+
+// Specifies the Size of bool typed entries, in words.
+func (e *Emitter) GenBool(entry *st.SymTableEntry) *st.SymTableEntry {
+	entry.Size = 1
+	return entry
+}
+
+// Specifies the Size of int typed entries, in words.
+func (e *Emitter) GenInt(entry *st.SymTableEntry) *st.SymTableEntry {
+	entry.Size = 1
+	return entry
+}
+
+// Generates records, calculating the offsets of the fields and the size.
+func (e *Emitter) GenRec(entry *st.SymTableEntry) *st.SymTableEntry {
+	s := 0
+	for _, f := range entry.Ctp.Fields {
+		f.Offset = s
+		s = s + f.Size
+	}
+	entry.Size = s
+	return entry
+}
+
+// Generates arrays, calculating the size.
+func (e *Emitter) GenArray(entry *st.SymTableEntry) *st.SymTableEntry {
+	entry.Size = entry.Ctp.Length * entry.Ctp.Size
+	return entry
+}
+
+// Allocates the global variables.
+func (e *Emitter) GenGlobalVars(scope []*st.SymTableEntry, start int) {
+	for _, x := range scope[start:] {
+		if x.EntryType == "var" {
+			x.Adr = e.Program.Globals
+			e.Program.Globals += x.Size
+		}
+	}
+}
+
+// Allocates the local variables of a procedure in its frame.
+func (e *Emitter) GenLocalVars(scope []*st.SymTableEntry, start int) {
+	pr := &e.Program.Procs[e.frames[len(e.frames)-1].proc]
+	for _, x := range scope[start:] {
+		if x.EntryType == "var" {
+			x.Adr = pr.Frame
+			pr.Frame += x.Size
+		}
+	}
+}
+
+// Generates a variable.
+func (e *Emitter) GenVar(entry *st.SymTableEntry) *st.SymTableEntry {
+	y := st.Typed(entry.EntryType, entry)
+	y.Name = entry.Name
+	y.Lev = entry.Lev
+	y.Adr = entry.Adr
+	return y
+}
+
+// Constants are simply constants so they do not need any extra work.
+func (e *Emitter) GenConst(entry *st.SymTableEntry) *st.SymTableEntry {
+	return entry
+}
+
+// Generates operations with unary operators. For and and or, the first
+// operand decides whether the second one is evaluated; the jump over it is
+// kept in Val.
+func (e *Emitter) GenUnaryOp(op int, x *st.SymTableEntry) *st.SymTableEntry {
+	e.load(x)
+	if op == k.MINUS {
+		e.emit(NEG)
+	} else if op == k.NOT {
+		e.emit(NOT)
+	} else if op == k.AND || op == k.OR {
+		e.emit(DUP)
+		y := pushed(st.Bool)
+		if op == k.AND {
+			y.Val = e.emit(JZ, 0)
+		} else {
+			y.Val = e.emit(JNZ, 0)
+		}
+		e.emit(POP)
+		return y
+	} else {
+		e.mark("bytecode: unary operator?")
+	}
+	return pushed(x.Tp)
+}
+
+// Instructions for the binary operators and the relations.
+var operators = map[int]Op{k.PLUS: ADD, k.MINUS: SUB, k.TIMES: MUL, k.DIV: DIV, k.MOD: MOD,
+	k.EQ: EQ, k.NE: NE, k.LT: LT, k.GT: GT, k.LE: LE, k.GE: GE}
+
+// Generates operations with binary operators.
+func (e *Emitter) GenBinaryOp(op int, x *st.SymTableEntry, y *st.SymTableEntry) *st.SymTableEntry {
+	if op == k.AND || op == k.OR {
+		e.load(y)
+		e.patch(x.Val)
+		return pushed(st.Bool)
+	}
+	e.operands(x, y, true)
+	if o, ok := operators[op]; ok {
+		e.emit(o)
+	} else {
+		e.mark("bytecode: binary operator?")
+	}
+	return pushed(st.Int)
+}
+
+// Generates relations between two entries, such as x > 5.
+func (e *Emitter) GenRelation(op int, x *st.SymTableEntry, y *st.SymTableEntry) *st.SymTableEntry {
+	e.operands(x, y, true)
+	e.emit(operators[op])
+	return pushed(st.Bool)
+}
+
+// Returns an item typed like the field or element t for the same place as x.
+func retype(x *st.SymTableEntry, t *st.SymTableEntry) *st.SymTableEntry {
+	x.Tp = t.Tp
+	x.Ctp = t.Ctp
+	x.ArrOrRec = t.ArrOrRec
+	return x
+}
+
+// Generates selectors for records.
+func (e *Emitter) GenSelect(x *st.SymTableEntry, field *st.SymTableEntry) *st.SymTableEntry {
+	if x.EntryType == "var" {
+		x.Adr += field.Offset
+	} else {
+		e.pushAddress(x)
+		if field.Offset != 0 {
+			e.constant(field.Offset)
+			e.emit(ADD)
+		}
+		x.Lev = -1
+	}
+	return retype(x, field)
+}
+
+// Generates indexes for arrays.
+func (e *Emitter) GenIndex(x *st.SymTableEntry, y *st.SymTableEntry) *st.SymTableEntry {
+	elem := x.Ctp.Elem
+	if y.EntryType == "const" && x.EntryType == "var" {
+		x.Adr += (y.Val - x.Ctp.Lower) * x.Ctp.Size
+		return retype(x, elem)
+	}
+	e.operands(x, y, false)
+	if x.Ctp.Lower != 0 {
+		e.constant(x.Ctp.Lower)
+		e.emit(SUB)
+	}
+	e.emit(BOUND, x.Ctp.Length)
+	if x.Ctp.Size != 1 {
+		e.constant(x.Ctp.Size)
+		e.emit(MUL)
+	}
+	e.emit(ADD)
+	x.EntryType = "ref"
+	x.Lev = -1
+	return retype(x, elem)
+}
+
+// Generates the check that succ and pred stay in an enumeration.
+func (e *Emitter) GenRange(x *st.SymTableEntry, n int) *st.SymTableEntry {
+	e.load(x)
+	e.emit(RANGE, n)
+	return pushed(st.Int)
+}
+
+// Stores the value on the stack into the variable an item stands for. An
+// address on the stack is below the value.
+func (e *Emitter) store(x *st.SymTableEntry) {
+	if x.EntryType == "ref" && x.Lev == -1 {
+		e.emit(SWAP)
+	} else {
+		e.pushAddress(x)
+	}
+	e.emit(STORE)
+}
+
+// Generates assignment to variables.
+func (e *Emitter) GenAssign(x *st.SymTableEntry, y *st.SymTableEntry) {
+	e.load(y)
+	e.store(x)
+}
+
+// Generates the entry to the program, the main procedure.
+func (e *Emitter) GenProgEntry(ident string) {
+	e.Program.Main = len(e.Program.Procs)
+	e.Program.Procs = append(e.Program.Procs, Proc{Name: ident, Entry: len(e.Program.Code)})
+}
+
+// Generates the exit of the program and returns the program in the file
+// format.
+func (e *Emitter) GenProgExit(x *st.SymTableEntry) string {
+	e.emit(HALT)
+	return string(Encode(e.Program))
+}
+
+// Generates the start of a procedure: its frame has the static link in word
+// 0 and the parameters after it. Records and arrays passed by value are
+// passed by address and copied into the frame on entry.
+func (e *Emitter) GenProcStart(ident string, listOfParams []*st.SymTableEntry, result *st.SymTableEntry) {
+	f := &procFrame{proc: len(e.Program.Procs)}
+	e.procs[e.Curlev][ident] = f.proc
+	e.Curlev += 1
+	e.procs = append(e.procs, map[string]int{})
+	e.frames = append(e.frames, f)
+	pr := Proc{Name: ident, Params: len(listOfParams), Frame: 1 + len(listOfParams), Level: e.Curlev}
+	for i, fp := range listOfParams {
+		fp.Adr = 1 + i
+		if fp.EntryType == "var" && (fp.ArrOrRec == "array" || fp.ArrOrRec == "record") {
+			f.copies = append(f.copies, [3]int{fp.Adr, pr.Frame, fp.Size})
+			fp.Adr = pr.Frame
+			pr.Frame += fp.Size
+		}
+	}
+	e.Program.Procs = append(e.Program.Procs, pr)
+}
+
+// Generates the entry of a procedure, which copies record and array value
+// parameters.
+func (e *Emitter) GenProcEntry() {
+	f := e.frames[len(e.frames)-1]
+	e.Program.Procs[f.proc].Entry = len(e.Program.Code)
+	for _, c := range f.copies {
+		e.emit(LADDR, 0, c[1])
+		e.emit(LADDR, 0, c[0])
+		e.emit(LOAD)
+		e.emit(MOVE, c[2])
+	}
+}
+
+// Generates the value a function returns, which RET leaves on the stack.
+func (e *Emitter) GenReturn(x *st.SymTableEntry) {
+	e.load(x)
+}
+
+// Generates the exit of a procedure.
+func (e *Emitter) GenProcExit(x *st.SymTableEntry) {
+	e.emit(RET)
+	e.frames = e.frames[:len(e.frames)-1]
+	e.procs = e.procs[:len(e.procs)-1]
+	e.Curlev -= 1
+}
+
+// Generates the actual parameters: the address of var, record and array
+// parameters, the value of any other.
+func (e *Emitter) GenActualPara(ap *st.SymTableEntry, fp *st.SymTableEntry) *st.SymTableEntry {
+	if fp.EntryType == "ref" || fp.ArrOrRec == "array" || fp.ArrOrRec == "record" {
+		e.pushAddress(ap)
+	} else {
+		e.load(ap)
+	}
+	return ap
+}
+
+// Generates procedure calls. The static link is the frame of the scope the
+// procedure is declared in, none for the main program. The result of a
+// function is on the stack.
+func (e *Emitter) GenCall(entry *st.SymTableEntry) *st.SymTableEntry {
+	if entry.Lev == 0 {
+		e.constant(0)
+	} else {
+		e.emit(LINK, e.Curlev-entry.Lev)
+	}
+	e.emit(CALL, e.procs[entry.Lev][entry.Name])
+	if entry.Result != nil {
+		return pushed(entry.Result.Tp)
+	}
+	return entry
+}
+
+// Generates read().
+func (e *Emitter) GenRead(x *st.SymTableEntry) {
+	e.emit(READ)
+	e.store(x)
+}
+
+// Generates write().
+func (e *Emitter) GenWrite(x *st.SymTableEntry) {
+	e.load(x)
+	e.emit(WRITE)
+}
+
+// Generates writeln().
+func (e *Emitter) GenWriteln() {
+	e.emit(WRITELN)
+}
+
+// Dummy function for generating sequences.
+func (e *Emitter) GenSeq(x *st.SymTableEntry, y *st.SymTableEntry) {
+	//pass
+}
+
+// Generates then. The jump over the then part is kept in Val.
+func (e *Emitter) GenThen(x *st.SymTableEntry) *st.SymTableEntry {
+	e.load(x)
+	y := st.Var(st.Bool)
+	y.Val = e.emit(JZ, 0)
+	return y
+}
+
+// Generates if/then.
+func (e *Emitter) GenIfThen(x *st.SymTableEntry, y *st.SymTableEntry) *st.SymTableEntry {
+	e.patch(x.Val)
+	return x
+}
+
+// Generates else. The jump over the else part is kept in Val.
+func (e *Emitter) GenElse(x *st.SymTableEntry, y *st.SymTableEntry) *st.SymTableEntry {
+	z := st.Var(st.None)
+	z.Val = e.emit(JMP, 0)
+	e.patch(x.Val)
+	return z
+}
+
+// Generates if/else
+func (e *Emitter) GenIfElse(x *st.SymTableEntry, y *st.SymTableEntry, z *st.SymTableEntry) *st.SymTableEntry {
+	e.patch(y.Val)
+	return x
+}
+
+// Generates while.
+func (e *Emitter) GenWhile() {
+	e.loops = append(e.loops, len(e.Program.Code))
+}
+
+// Generates do. The jump out of the loop is kept in Val.
+func (e *Emitter) GenDo(x *st.SymTableEntry) *st.SymTableEntry {
+	e.load(x)
+	y := st.Var(st.Bool)
+	y.Val = e.emit(JZ, 0)
+	return y
+}
+
+// Generates while/do.
+func (e *Emitter) GenWhileDo(x *st.SymTableEntry, y *st.SymTableEntry) {
+	e.emit(JMP, e.loops[len(e.loops)-1])
+	e.loops = e.loops[:len(e.loops)-1]
+	e.patch(x.Val)
+}
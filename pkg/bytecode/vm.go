@@ -0,0 +1,299 @@
+package bytecode
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+)
+
+// What programs read from and write to.
+type Host interface {
+	Read() int32 // Returns the next integer of the input, 0 at its end.
+	Write(x int32)
+	Writeln()
+}
+
+// A Host that reads whitespace separated integers from a Reader and writes
+// like the P0lib imports of WASM: " %d" for write, a newline for writeln.
+// Output is buffered; it is flushed before every read and by Flush.
+type IOHost struct {
+	in  *bufio.Reader
+	out *bufio.Writer
+}
+
+// Creates an IOHost reading from r and writing to w.
+func NewIOHost(r io.Reader, w io.Writer) *IOHost {
+	return &IOHost{in: bufio.NewReader(r), out: bufio.NewWriter(w)}
+}
+
+// Reads the next integer, 0 at the end of the input or on anything else.
+func (h *IOHost) Read() int32 {
+	h.out.Flush()
+	var x int32
+	if _, err := fmt.Fscan(h.in, &x); err != nil {
+		return 0
+	}
+	return x
+}
+
+// Writes x after a space.
+func (h *IOHost) Write(x int32) {
+	fmt.Fprintf(h.out, " %d", x)
+}
+
+// Writes a newline.
+func (h *IOHost) Writeln() {
+	h.out.WriteString("\n")
+}
+
+// Writes the buffered output.
+func (h *IOHost) Flush() error {
+	return h.out.Flush()
+}
+
+// An error that stopped a program, with the source line it happened at.
+type RuntimeError struct {
+	Line int
+	Msg  string
+}
+
+func (e *RuntimeError) Error() string {
+	return fmt.Sprintf("line %d: %s", e.Line, e.Msg)
+}
+
+// Runs programs. Trace, if set, is called before every instruction.
+type VM struct {
+	Program  *Program
+	Host     Host
+	MaxWords int // Most words of memory for global variables and frames.
+	Steps    int // Instructions executed.
+	Trace    func(pc int, in Instr, stack []int32)
+}
+
+// Most words of memory a VM has by default, and of global variables a program
+// can have.
+const MaxWords = 1 << 22
+
+// Creates a VM for a program with a host.
+func NewVM(p *Program, h Host) *VM {
+	return &VM{Program: p, Host: h, MaxWords: MaxWords}
+}
+
+// A procedure call being executed.
+type frame struct {
+	ret int // Instruction to return to.
+	fp  int // Frame of the caller.
+}
+
+// Runs the program until it halts or fails. The program is checked like a
+// decoded one first; addresses and the operand stack are checked as it runs.
+func (vm *VM) Run() error {
+	p := vm.Program
+	if err := check(p); err != nil {
+		return err
+	}
+	if p.Globals > vm.MaxWords {
+		return &RuntimeError{Msg: "global variables do not fit in memory"}
+	}
+	mem := make([]int32, p.Globals, p.Globals+1024)
+	stack := []int32{}
+	calls := []frame{}
+	fp, pc := len(mem), p.Procs[p.Main].Entry
+	fail := func(msg string) error {
+		return &RuntimeError{Line: p.LineOf(pc), Msg: msg}
+	}
+	pop := func() int32 {
+		x := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		return x
+	}
+	// Reports whether the n words from address a are in memory.
+	valid := func(a int32, n int32) bool {
+		return a >= 0 && int(a)+int(n) <= len(mem)
+	}
+	// Follows d static links from the current frame.
+	up := func(d int32) (int, bool) {
+		a := fp
+		for ; d > 0; d-- {
+			if !valid(int32(a), 1) {
+				return 0, false
+			}
+			a = int(mem[a])
+		}
+		return a, true
+	}
+	for {
+		if pc < 0 || pc >= len(p.Code) {
+			return fail("no instruction at " + fmt.Sprint(pc))
+		}
+		in := p.Code[pc]
+		if vm.Trace != nil {
+			vm.Trace(pc, in, stack)
+		}
+		if len(stack) < needs[in.Op] {
+			return fail("operand stack underflow in " + in.String())
+		} else if len(stack) >= vm.MaxWords {
+			return fail("operand stack overflow")
+		}
+		vm.Steps += 1
+		pc += 1
+		switch in.Op {
+		case CONST:
+			stack = append(stack, p.Constants[in.A])
+		case GADDR:
+			stack = append(stack, in.A)
+		case LADDR, LINK:
+			a, ok := up(in.A)
+			if !ok {
+				pc -= 1
+				return fail("invalid static link")
+			}
+			if in.Op == LADDR {
+				a += int(in.B)
+			}
+			stack = append(stack, int32(a))
+		case LOAD:
+			a := stack[len(stack)-1]
+			if !valid(a, 1) {
+				pc -= 1
+				return fail(fmt.Sprintf("address %d out of memory", a))
+			}
+			stack[len(stack)-1] = mem[a]
+		case STORE:
+			a := pop()
+			if !valid(a, 1) {
+				pc -= 1
+				return fail(fmt.Sprintf("address %d out of memory", a))
+			}
+			mem[a] = pop()
+		case MOVE:
+			src := pop()
+			dst := pop()
+			if !valid(src, in.A) || !valid(dst, in.A) {
+				pc -= 1
+				return fail(fmt.Sprintf("move of %d words from %d to %d out of memory", in.A, src, dst))
+			}
+			copy(mem[dst:dst+in.A], mem[src:src+in.A])
+		case BOUND:
+			if x := stack[len(stack)-1]; x < 0 || x >= in.A {
+				pc -= 1
+				return fail("index out of bounds")
+			}
+		case RANGE:
+			if x := stack[len(stack)-1]; x < 0 || x >= in.A {
+				pc -= 1
+				return fail("value out of range")
+			}
+		case ADD, SUB, MUL, DIV, MOD, EQ, NE, LT, GT, LE, GE:
+			y := pop()
+			x := pop()
+			z, msg := arithmetic(in.Op, x, y)
+			if msg != "" {
+				pc -= 1
+				return fail(msg)
+			}
+			stack = append(stack, z)
+		case NEG:
+			stack[len(stack)-1] = -stack[len(stack)-1]
+		case NOT:
+			stack[len(stack)-1] = 1 - stack[len(stack)-1]
+		case DUP:
+			stack = append(stack, stack[len(stack)-1])
+		case POP:
+			pop()
+		case SWAP:
+			n := len(stack)
+			stack[n-1], stack[n-2] = stack[n-2], stack[n-1]
+		case JMP:
+			pc = int(in.A)
+		case JZ:
+			if pop() == 0 {
+				pc = int(in.A)
+			}
+		case JNZ:
+			if pop() != 0 {
+				pc = int(in.A)
+			}
+		case CALL:
+			pr := p.Procs[in.A]
+			top := len(mem)
+			if len(stack) < 1+pr.Params {
+				pc -= 1
+				return fail("operand stack underflow in " + in.String())
+			} else if top+pr.Frame > vm.MaxWords {
+				pc -= 1
+				return fail("stack overflow")
+			}
+			mem = append(mem, make([]int32, pr.Frame)...)
+			mem[top] = pop()
+			for i := pr.Params; i > 0; i-- {
+				mem[top+i] = pop()
+			}
+			calls = append(calls, frame{ret: pc, fp: fp})
+			fp, pc = top, pr.Entry
+		case RET:
+			if len(calls) == 0 {
+				pc -= 1
+				return fail("return from the main program")
+			}
+			c := calls[len(calls)-1]
+			calls = calls[:len(calls)-1]
+			mem = mem[:fp]
+			fp, pc = c.fp, c.ret
+		case READ:
+			stack = append(stack, vm.Host.Read())
+		case WRITE:
+			vm.Host.Write(pop())
+		case WRITELN:
+			vm.Host.Writeln()
+		case HALT:
+			return nil
+		default:
+			pc -= 1
+			return fail("unknown instruction " + in.String())
+		}
+	}
+}
+
+// Returns the result of a binary operator, or why it traps.
+func arithmetic(op Op, x, y int32) (int32, string) {
+	switch op {
+	case ADD:
+		return x + y, ""
+	case SUB:
+		return x - y, ""
+	case MUL:
+		return x * y, ""
+	case DIV, MOD:
+		if y == 0 {
+			return 0, "integer divide by zero"
+		} else if y == -1 && op == MOD {
+			return 0, ""
+		} else if y == -1 && x == math.MinInt32 {
+			return 0, "integer overflow"
+		} else if op == DIV {
+			return x / y, ""
+		}
+		return x % y, ""
+	}
+	r := false
+	switch op {
+	case EQ:
+		r = x == y
+	case NE:
+		r = x != y
+	case LT:
+		r = x < y
+	case GT:
+		r = x > y
+	case LE:
+		r = x <= y
+	case GE:
+		r = x >= y
+	}
+	if r {
+		return 1, ""
+	}
+	return 0, ""
+}
@@ -0,0 +1,535 @@
+// C code generator: translates P0 programs into C99 that any C compiler can
+// build, with one function per procedure.
+package cgen
+
+import (
+	"fmt"
+	"group-11/pkg/diag"
+	k "group-11/pkg/keywords"
+	"group-11/pkg/source"
+	st "group-11/pkg/symtable"
+	"math"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// Runtime of compiled programs, put at the start of every generated file. It
+// provides read, write and writeln like the P0lib imports of WASM programs,
+// and integer arithmetic that wraps around and traps on division by zero the
+// way WASM does.
+const Runtime = `#include <inttypes.h>
+#include <stdint.h>
+#include <stdio.h>
+#include <stdlib.h>
+#include <string.h>
+
+static inline int32_t p0_read(void) {
+    int32_t x;
+    if (scanf("%" SCNd32, &x) != 1) {
+        return 0;
+    }
+    return x;
+}
+
+static inline void p0_write(int32_t x) {
+    printf(" %" PRId32, x);
+}
+
+static inline void p0_writeln(void) {
+    printf("\n");
+}
+
+static inline void p0_trap(const char *msg) {
+    fflush(stdout);
+    fprintf(stderr, "%s\n", msg);
+    exit(1);
+}
+
+static inline int32_t p0_add(int32_t x, int32_t y) {
+    return (int32_t)((uint32_t)x + (uint32_t)y);
+}
+
+static inline int32_t p0_sub(int32_t x, int32_t y) {
+    return (int32_t)((uint32_t)x - (uint32_t)y);
+}
+
+static inline int32_t p0_mul(int32_t x, int32_t y) {
+    return (int32_t)((uint32_t)x * (uint32_t)y);
+}
+
+static inline int32_t p0_neg(int32_t x) {
+    return (int32_t)(0u - (uint32_t)x);
+}
+
+static inline int32_t p0_div(int32_t x, int32_t y) {
+    if (y == 0) {
+        p0_trap("integer divide by zero");
+    }
+    if (x == INT32_MIN && y == -1) {
+        p0_trap("integer overflow");
+    }
+    return x / y;
+}
+
+static inline int32_t p0_mod(int32_t x, int32_t y) {
+    if (y == 0) {
+        p0_trap("integer divide by zero");
+    }
+    if (y == -1) {
+        return 0;
+    }
+    return x % y;
+}
+
+static inline int32_t p0_range(int32_t x, int32_t n) {
+    if (x < 0 || x >= n) {
+        p0_trap("value out of range");
+    }
+    return x;
+}
+`
+
+// Output of the C code generator and the state it keeps while generating.
+// Items are symbol table entries whose Name is the C expression for their
+// value.
+type Emitter struct {
+	Lines  []string                   // Generated C, one line each.
+	Curlev int                        // Current scope level.
+	At     source.Span                // Construct being generated, for error messages.
+	types  map[*st.ComplexType]string // C name of every record and array type.
+	args   []string                   // Actual parameters of the calls being generated.
+	indent int
+	failed bool // Whether an error was reported; nothing is generated after it.
+	errors diag.Handler
+}
+
+// Creates an Emitter that reports errors to errh.
+func NewEmitter(errh diag.Handler) *Emitter {
+	return &Emitter{Lines: []string{}, types: map[*st.ComplexType]string{}, errors: errh}
+}
+
+// Reports an error at the construct being generated. Only the first error is
+// reported, as what follows an unsupported construct, such as the variables of
+// a nested procedure, would be reported again.
+func (e *Emitter) mark(msg string) {
+	if !e.failed {
+		e.failed = true
+		e.errors(diag.NewError("E400", e.At, msg))
+	}
+}
+
+// Sets the construct being generated, for error messages.
+func (e *Emitter) SetAt(span source.Span) {
+	e.At = span
+}
+
+// Appends a line at the current indentation.
+func (e *Emitter) emit(line string) {
+	if e.failed {
+		return
+	}
+	e.Lines = append(e.Lines, strings.Repeat("    ", e.indent)+line)
+}
+
+// Returns the C name of a P0 identifier. Every name gets a trailing underscore,
+// so that it cannot clash with C keywords, the runtime or the names of
+// anonymous types, and characters C does not allow are spelled out.
+func Mangle(name string) string {
+	b := strings.Builder{}
+	for _, r := range name {
+		if r < utf8.RuneSelf && (r == '_' || '0' <= r && r <= '9' || 'a' <= r && r <= 'z' || 'A' <= r && r <= 'Z') {
+			b.WriteRune(r)
+		} else if r <= 0xFFFF {
+			fmt.Fprintf(&b, "_u%04x", r)
+		} else {
+			fmt.Fprintf(&b, "_U%08x", r)
+		}
+	}
+	b.WriteString("_")
+	return b.String()
+}
+
+// Returns the C type of a variable or type entry.
+func (e *Emitter) ctype(entry *st.SymTableEntry) string {
+	if entry.ArrOrRec == "array" || entry.ArrOrRec == "record" {
+		return e.types[entry.Ctp]
+	}
+	return "int32_t"
+}
+
+// Returns the name for a new record or array type: the P0 name of a declared
+// type, otherwise a numbered one.
+func (e *Emitter) typeName(tp *st.SymTableEntry) string {
+	if tp.EntryType == "type" {
+		return Mangle(tp.Name)
+	}
+	return "T" + strconv.Itoa(len(e.types)+1)
+}
+
+// Removes parentheses around a whole expression.
+func bare(x string) string {
+	if !strings.HasPrefix(x, "(") || !strings.HasSuffix(x, ")") {
+		return x
+	}
+	depth := 0
+	for i, c := range x {
+		if c == '(' {
+			depth += 1
+		} else if c == ')' {
+			depth -= 1
+			if depth == 0 && i < len(x)-1 {
+				return x
+			}
+		}
+	}
+	return x[1 : len(x)-1]
+}
+
+// Returns an item of the same kind and type as x for the C expression code.
+func item(x *st.SymTableEntry, code string) *st.SymTableEntry {
+	y := st.Typed(x.EntryType, x)
+	y.Name = code
+	y.Val = x.Val
+	return y
+}
+
+// Generates the start of programs: the runtime.
+func (e *Emitter) GenProgStart() {
+	e.Lines = append(e.Lines, strings.Split(strings.TrimRight(Runtime, "\n"), "\n")...)
+}
+
+// Specifies the Size of bool typed entries.
+func (e *Emitter) GenBool(entry *st.SymTableEntry) *st.SymTableEntry {
+	entry.Size = 4
+	return entry
+}
+
+// Specifies the Size of int typed entries.
+func (e *Emitter) GenInt(entry *st.SymTableEntry) *st.SymTableEntry {
+	entry.Size = 4
+	return entry
+}
+
+// Generates a struct for a record type the first time it is seen.
+func (e *Emitter) GenRec(entry *st.SymTableEntry) *st.SymTableEntry {
+	if _, ok := e.types[entry.Ctp]; !ok {
+		name := e.typeName(entry)
+		e.emit("")
+		e.emit("typedef struct {")
+		for _, f := range entry.Ctp.Fields {
+			e.emit("    " + e.ctype(f) + " " + Mangle(f.Name) + ";")
+		}
+		e.emit("} " + name + ";")
+		e.types[entry.Ctp] = name
+	}
+	return entry
+}
+
+// Generates an array type the first time it is seen.
+func (e *Emitter) GenArray(entry *st.SymTableEntry) *st.SymTableEntry {
+	if _, ok := e.types[entry.Ctp]; !ok {
+		name := e.typeName(entry)
+		if entry.Ctp.Length < 1 {
+			e.mark("C: empty array")
+		}
+		e.emit("")
+		e.emit("typedef " + e.ctype(entry.Ctp.Elem) + " " + name + "[" + strconv.Itoa(entry.Ctp.Length) + "];")
+		e.types[entry.Ctp] = name
+	}
+	return entry
+}
+
+// Generates the global variables.
+func (e *Emitter) GenGlobalVars(scope []*st.SymTableEntry, start int) {
+	e.emit("")
+	for _, x := range scope[start:] {
+		if x.EntryType == "var" {
+			e.emit("static " + e.ctype(x) + " " + Mangle(x.Name) + ";")
+		}
+	}
+}
+
+// Generates the local variables of a procedure, initialized to zero like the
+// global ones.
+func (e *Emitter) GenLocalVars(scope []*st.SymTableEntry, start int) {
+	for _, x := range scope[start:] {
+		if x.EntryType == "var" {
+			init := "0"
+			if x.ArrOrRec == "array" || x.ArrOrRec == "record" {
+				init = "{0}"
+			}
+			e.emit(e.ctype(x) + " " + Mangle(x.Name) + " = " + init + ";")
+		}
+	}
+}
+
+// Generates a variable; var parameters are dereferenced.
+func (e *Emitter) GenVar(entry *st.SymTableEntry) *st.SymTableEntry {
+	if 0 < entry.Lev && entry.Lev < e.Curlev {
+		e.mark("C: Level")
+	}
+	if entry.EntryType == "ref" {
+		return item(entry, "(*"+Mangle(entry.Name)+")")
+	}
+	return item(entry, Mangle(entry.Name))
+}
+
+// Generates a constant.
+func (e *Emitter) GenConst(entry *st.SymTableEntry) *st.SymTableEntry {
+	if entry.Val == math.MinInt32 {
+		return item(entry, "INT32_MIN")
+	} else if entry.Val < 0 {
+		return item(entry, "("+strconv.Itoa(entry.Val)+")")
+	}
+	return item(entry, strconv.Itoa(entry.Val))
+}
+
+// Generates operations with unary operators. For and and or, the first
+// operand is passed here before the second one is generated; C evaluates
+// && and || in the same short-circuit way.
+func (e *Emitter) GenUnaryOp(op int, x *st.SymTableEntry) *st.SymTableEntry {
+	y := st.Var(x.Tp)
+	if op == k.MINUS {
+		y.Name = "p0_neg(" + bare(x.Name) + ")"
+	} else if op == k.NOT {
+		y.Name = "!" + x.Name
+	} else if op == k.AND || op == k.OR {
+		y.Name = x.Name
+	} else {
+		e.mark("C: unary operator?")
+	}
+	return y
+}
+
+// Runtime functions for the arithmetic operators.
+var arithmetic = map[int]string{k.PLUS: "p0_add", k.MINUS: "p0_sub", k.TIMES: "p0_mul", k.DIV: "p0_div", k.MOD: "p0_mod"}
+
+// C operators for and, or and the relations.
+var operators = map[int]string{k.AND: "&&", k.OR: "||", k.EQ: "==", k.NE: "!=", k.LT: "<", k.GT: ">", k.LE: "<=", k.GE: ">="}
+
+// Generates operations with binary operators.
+func (e *Emitter) GenBinaryOp(op int, x *st.SymTableEntry, y *st.SymTableEntry) *st.SymTableEntry {
+	z := st.Var(x.Tp)
+	if f, ok := arithmetic[op]; ok {
+		z.Name = f + "(" + bare(x.Name) + ", " + bare(y.Name) + ")"
+	} else if c, ok := operators[op]; ok {
+		z.Name = "(" + x.Name + " " + c + " " + y.Name + ")"
+	} else {
+		e.mark("C: binary operator?")
+	}
+	return z
+}
+
+// Generates the check that succ and pred stay in an enumeration.
+func (e *Emitter) GenRange(x *st.SymTableEntry, n int) *st.SymTableEntry {
+	z := st.Var(st.Int)
+	z.Name = "p0_range(" + bare(x.Name) + ", " + strconv.Itoa(n) + ")"
+	return z
+}
+
+// Generates relations between two entries, such as x > 5.
+func (e *Emitter) GenRelation(op int, x *st.SymTableEntry, y *st.SymTableEntry) *st.SymTableEntry {
+	z := st.Var(st.Bool)
+	z.Name = "(" + x.Name + " " + operators[op] + " " + y.Name + ")"
+	return z
+}
+
+// Generates selectors for records.
+func (e *Emitter) GenSelect(x *st.SymTableEntry, field *st.SymTableEntry) *st.SymTableEntry {
+	y := item(field, x.Name+"."+Mangle(field.Name))
+	y.EntryType = "var"
+	return y
+}
+
+// Generates indexes for arrays. C arrays start at 0.
+func (e *Emitter) GenIndex(x *st.SymTableEntry, y *st.SymTableEntry) *st.SymTableEntry {
+	index := bare(y.Name)
+	if x.Ctp.Lower != 0 {
+		if y.EntryType == "const" {
+			index = strconv.Itoa(y.Val - x.Ctp.Lower)
+		} else {
+			index = index + " - " + strconv.Itoa(x.Ctp.Lower)
+		}
+	}
+	z := item(x.Ctp.Elem, x.Name+"["+index+"]")
+	z.EntryType = "var"
+	return z
+}
+
+// Generates assignment to variables.
+func (e *Emitter) GenAssign(x *st.SymTableEntry, y *st.SymTableEntry) {
+	e.emit(x.Name + " = " + bare(y.Name) + ";")
+}
+
+// Generates the entry to the program.
+func (e *Emitter) GenProgEntry(ident string) {
+	e.emit("")
+	e.emit("int main(void) {")
+	e.indent += 1
+}
+
+// Generates the exit of the program and returns the generated C.
+func (e *Emitter) GenProgExit(x *st.SymTableEntry) string {
+	e.emit("return 0;")
+	e.indent -= 1
+	e.emit("}")
+	return strings.Join(e.Lines, "\n") + "\n"
+}
+
+// Generates the C type of a formal parameter: a pointer for var parameters.
+func (e *Emitter) paramType(fp *st.SymTableEntry) string {
+	if fp.EntryType == "ref" {
+		return e.ctype(fp) + " *"
+	}
+	return e.ctype(fp) + " "
+}
+
+// Generates function signatures. Arrays cannot be passed by value in C, so an
+// array value parameter is passed as a pointer and copied on entry. Functions
+// return an int32_t.
+func (e *Emitter) GenProcStart(ident string, listOfParams []*st.SymTableEntry, result *st.SymTableEntry) {
+	if e.Curlev > 0 {
+		e.mark("C: no nested procedures")
+	}
+	e.Curlev += 1
+	params := []string{}
+	for _, fp := range listOfParams {
+		if fp.EntryType == "var" && fp.ArrOrRec == "array" {
+			params = append(params, "const "+e.ctype(fp)+" "+Mangle(fp.Name)+"in")
+		} else {
+			params = append(params, e.paramType(fp)+Mangle(fp.Name))
+		}
+	}
+	if len(params) == 0 {
+		params = append(params, "void")
+	}
+	ret := "void"
+	if result != nil {
+		ret = e.ctype(result)
+	}
+	e.emit("")
+	e.emit("static " + ret + " " + Mangle(ident) + "(" + strings.Join(params, ", ") + ") {")
+	e.indent += 1
+	for _, fp := range listOfParams {
+		if fp.EntryType == "var" && fp.ArrOrRec == "array" {
+			name := Mangle(fp.Name)
+			e.emit(e.ctype(fp) + " " + name + ";")
+			e.emit("memcpy(" + name + ", " + name + "in, sizeof " + name + ");")
+		}
+	}
+}
+
+// Dummy function for generating procedure entries.
+func (e *Emitter) GenProcEntry() {
+	//pass
+}
+
+// Generates the return statement of a function.
+func (e *Emitter) GenReturn(x *st.SymTableEntry) {
+	e.emit("return " + bare(x.Name) + ";")
+}
+
+// Generates procedure exits, which is simply a closing brace.
+func (e *Emitter) GenProcExit(x *st.SymTableEntry) {
+	e.Curlev -= 1
+	e.indent -= 1
+	e.emit("}")
+}
+
+// Generates the actual parameters: the address of a var parameter, the value
+// of any other.
+func (e *Emitter) GenActualPara(ap *st.SymTableEntry, fp *st.SymTableEntry) *st.SymTableEntry {
+	if fp.EntryType == "ref" && ap.Name == "(*"+bare(ap.Name)[1:]+")" {
+		// A var parameter passed on: its address is the pointer itself.
+		e.args = append(e.args, bare(ap.Name)[1:])
+	} else if fp.EntryType == "ref" {
+		e.args = append(e.args, "&"+ap.Name)
+	} else {
+		e.args = append(e.args, bare(ap.Name))
+	}
+	return ap
+}
+
+// Generates function calls with the actual parameters generated last. A call
+// of a function is an expression and is returned as an item.
+func (e *Emitter) GenCall(entry *st.SymTableEntry) *st.SymTableEntry {
+	n := len(e.args) - len(entry.Par)
+	call := Mangle(entry.Name) + "(" + strings.Join(e.args[n:], ", ") + ")"
+	e.args = e.args[:n]
+	if entry.Result != nil {
+		y := st.Var(entry.Result.Tp)
+		y.Name = call
+		return y
+	}
+	e.emit(call + ";")
+	return entry
+}
+
+// Generates call to the runtime read().
+func (e *Emitter) GenRead(x *st.SymTableEntry) {
+	e.emit(x.Name + " = p0_read();")
+}
+
+// Generates call to the runtime write().
+func (e *Emitter) GenWrite(x *st.SymTableEntry) {
+	e.emit("p0_write(" + bare(x.Name) + ");")
+}
+
+// Generates call to the runtime writeln().
+func (e *Emitter) GenWriteln() {
+	e.emit("p0_writeln();")
+}
+
+// Dummy function for generating sequences.
+func (e *Emitter) GenSeq(x *st.SymTableEntry, y *st.SymTableEntry) {
+	//pass
+}
+
+// Generates then.
+func (e *Emitter) GenThen(x *st.SymTableEntry) *st.SymTableEntry {
+	e.emit("if (" + bare(x.Name) + ") {")
+	e.indent += 1
+	return x
+}
+
+// Generates if/then.
+func (e *Emitter) GenIfThen(x *st.SymTableEntry, y *st.SymTableEntry) *st.SymTableEntry {
+	e.indent -= 1
+	e.emit("}")
+	return x
+}
+
+// Generates else.
+func (e *Emitter) GenElse(x *st.SymTableEntry, y *st.SymTableEntry) *st.SymTableEntry {
+	e.indent -= 1
+	e.emit("} else {")
+	e.indent += 1
+	return y
+}
+
+// Generates if/else
+func (e *Emitter) GenIfElse(x *st.SymTableEntry, y *st.SymTableEntry, z *st.SymTableEntry) *st.SymTableEntry {
+	e.indent -= 1
+	e.emit("}")
+	return x
+}
+
+// Generates while. The condition is generated as an expression, so nothing
+// needs to come before it.
+func (e *Emitter) GenWhile() {
+	//pass
+}
+
+// Generates do.
+func (e *Emitter) GenDo(x *st.SymTableEntry) *st.SymTableEntry {
+	e.emit("while (" + bare(x.Name) + ") {")
+	e.indent += 1
+	return x
+}
+
+// Generates while/do.
+func (e *Emitter) GenWhileDo(x *st.SymTableEntry, y *st.SymTableEntry) {
+	e.indent -= 1
+	e.emit("}")
+}
@@ -2,51 +2,144 @@ package codegen
 
 import (
 	"fmt"
-	i "group-11/pkg/inputdata"
+	"group-11/pkg/diag"
 	k "group-11/pkg/keywords"
-	s "group-11/pkg/scanner"
+	"group-11/pkg/source"
 	st "group-11/pkg/symtable"
+	"group-11/pkg/wasm"
 	"io/ioutil"
-	"log"
 	"math"
 	"strconv"
+	"strings"
 )
 
-// Takes the asm string and converts it into a WASM code file with
-// the provided fileName.
-func WriteWasmFile(fileName string, code string) {
+// Writes generated code to the file with the provided fileName: WAT, or the
+// C, JavaScript, LLVM IR, assembler or bytecode of the other targets.
+func WriteFile(fileName string, code string) error {
 	generatedCode := []byte(code)
 	err := ioutil.WriteFile(fileName, generatedCode, 0644)
 
 	if err != nil {
-		log.Fatal(err)
-	} else {
-		fmt.Println(fileName + " was created.")
+		return err
+	}
+	fmt.Println(fileName + " was created.")
+	return nil
+}
+
+// Assembles the WAT code into a binary WASM module and writes it to the file
+// with the provided fileName, so that engines can load it without wat2wasm.
+func WriteWasmBinary(fileName string, code string) error {
+	module, err := wasm.Assemble(code)
+	if err != nil {
+		return fmt.Errorf("%s: %v", fileName, err)
+	}
+	if err := ioutil.WriteFile(fileName, module, 0644); err != nil {
+		return err
+	}
+	fmt.Println(fileName + " was created.")
+	return nil
+}
+
+// Output of the WASM code generator and the state it keeps while generating.
+// Each compilation has its own Emitter.
+type Emitter struct {
+	Asm     []string            // The string that will ultimately become the WASM file.
+	Curlev  int                 // Current scope level of the code generator.
+	Memsize int                 // Size of the required memory allocation.
+	At      source.Span         // Construct being generated, for error messages.
+	Spans   []source.Span       // Construct each entry of Asm was generated for.
+	labels  []map[string]string // Name of the function of every procedure, per scope level.
+	used    map[string]bool     // Function names given out so far.
+	funcs   []*wasmFunc         // Procedures being generated, innermost last.
+	stack   bool                // Whether any procedure has a frame in memory.
+	errors  diag.Handler
+}
+
+// Bytes of memory after the global variables for the frames of procedures.
+const stackSize = 16 * 65536
+
+// A procedure being generated. Its code is collected separately, as the
+// functions of procedures nested in it are generated before its body.
+//
+// A procedure has a frame in memory if it has nested procedures, variables
+// that they use, or local arrays and records. Word 0 of the frame is the
+// static link, the frame of the procedure it is declared in; the variables
+// follow. $_fp points to the frame, and $_sp to the free memory above the
+// frames. Every call allocates a frame of its own.
+type wasmFunc struct {
+	asm      []string
+	spans    []source.Span
+	size     int  // Bytes of the frame.
+	nested   bool // Whether it is declared in a procedure, so it gets the static link as $_sl.
+	children bool // Whether procedures are declared in it.
+	params   []*st.SymTableEntry
+	locals   int // Offset of the local variables in the frame, which are set to zero on entry.
+}
+
+// Creates an Emitter that reports errors to errh.
+func NewEmitter(errh diag.Handler) *Emitter {
+	return &Emitter{Asm: []string{}, labels: []map[string]string{{}}, used: map[string]bool{}, errors: errh}
+}
+
+// Sets the construct being generated, for error messages.
+func (e *Emitter) SetAt(span source.Span) {
+	e.At = span
+}
+
+// Appends lines of code generated for the construct being generated.
+func (e *Emitter) emit(lines ...string) {
+	for _, line := range lines {
+		if n := len(e.funcs); n > 0 {
+			e.funcs[n-1].asm = append(e.funcs[n-1].asm, line)
+			e.funcs[n-1].spans = append(e.funcs[n-1].spans, e.At)
+		} else {
+			e.Asm = append(e.Asm, line)
+			e.Spans = append(e.Spans, e.At)
+		}
 	}
 }
 
+// Returns the span of the construct that the given line of the generated code
+// was generated for. Line 1 is the empty line the code starts with.
+func (e *Emitter) SpanOf(line int) source.Span {
+	n := 2
+	for i, asm := range e.Asm {
+		n += strings.Count(asm, "\n") + 1
+		if line < n && i < len(e.Spans) {
+			return e.Spans[i]
+		}
+	}
+	return e.At
+}
+
+// Reports an error at the construct being generated.
+func (e *Emitter) mark(msg string) {
+	e.errors(diag.NewError("E400", e.At, msg))
+}
+
 // Generates the start of programs.
-func GenProgStart(inputData *i.InputData) {
-	inputData.Asm = append(inputData.Asm, "(module",
+func (e *Emitter) GenProgStart() {
+	e.emit("(module",
 		"(import \"P0lib\" \"write\" (func $write (param i32)))",
 		"(import \"P0lib\" \"writeln\" (func $writeln))",
 		"(import \"P0lib\" \"read\" (func $read (result i32)))")
 }
 
-// Specifies the Size of bool typed entries.
-func GenBool(entry *st.SymTableEntry) *st.SymTableEntry {
-	entry.Size = 1
+// Specifies the Size of bool typed entries. Booleans are loaded and stored as
+// i32, so they take as much memory as integers.
+func (e *Emitter) GenBool(entry *st.SymTableEntry) *st.SymTableEntry {
+	entry.Size = 4
 	return entry
 }
 
 // Specifies the Size of int typed entries.
-func GenInt(entry *st.SymTableEntry) *st.SymTableEntry {
+func (e *Emitter) GenInt(entry *st.SymTableEntry) *st.SymTableEntry {
 	entry.Size = 4
 	return entry
 }
 
 // Generates records, calculating some of the attribute values.
-func GenRec(entry *st.SymTableEntry) *st.SymTableEntry {
+func (e *Emitter) GenRec(entry *st.SymTableEntry) *st.SymTableEntry {
 	s := 0
 	for _, f := range entry.Ctp.Fields {
 		f.Offset = s
@@ -57,24 +150,31 @@ func GenRec(entry *st.SymTableEntry) *st.SymTableEntry {
 }
 
 // Generates records, calculating its Size.
-func GenArray(entry *st.SymTableEntry) *st.SymTableEntry {
+func (e *Emitter) GenArray(entry *st.SymTableEntry) *st.SymTableEntry {
 	entry.Size = entry.Ctp.Length * entry.Ctp.Size
 	return entry
 }
 
+// Reports whether a variable holds one i32: an integer, a boolean or a value
+// of an enumeration.
+func scalar(x *st.SymTableEntry) bool {
+	return x.Tp == st.Int || x.Tp == st.Bool || x.Tp == st.Enum
+}
+
 // Generates all of the global.
-func GenGlobalVars(scope []st.SymTableEntry, start int, inputData *i.InputData) {
+func (e *Emitter) GenGlobalVars(scope []*st.SymTableEntry, start int) {
 	i := start
 	for i < len(scope) {
 		if scope[i].EntryType == "var" {
-			if scope[i].Tp == st.Int || scope[i].Tp == st.Bool {
-				inputData.Asm = append(inputData.Asm, "(global $"+scope[i].Name+" (mut i32) i32.const 0)")
-			} else if scope[i].EntryType == "array" || scope[i].EntryType == "record" {
+			if scalar(scope[i]) && !scope[i].Addressed {
+				e.emit("(global $" + scope[i].Name + " (mut i32) i32.const 0)")
+			} else if scalar(scope[i]) || scope[i].ArrOrRec == "array" || scope[i].ArrOrRec == "record" {
+				// Variables passed by reference need an address.
 				scope[i].Lev = -2
-				scope[i].Adr = inputData.Memsize
-				inputData.Memsize = inputData.Memsize + scope[i].Size
+				scope[i].Adr = e.Memsize
+				e.Memsize = e.Memsize + scope[i].Size
 			} else {
-				s.PrintError(inputData, "WASM: type?")
+				e.mark("WASM: type?")
 			}
 		}
 		i += 1
@@ -82,59 +182,121 @@ func GenGlobalVars(scope []st.SymTableEntry, start int, inputData *i.InputData)
 }
 
 // Generates all of the local vars.
-func GenLocalVars(scope []st.SymTableEntry, start int, inputData *i.InputData) st.PrimitiveType {
+func (e *Emitter) GenLocalVars(scope []*st.SymTableEntry, start int) {
 	i := start
 	for i < len(scope) {
 		if scope[i].EntryType == "var" {
-			if scope[i].Tp == st.Int || scope[i].Tp == st.Bool {
-				inputData.Asm = append(inputData.Asm, "(local $"+scope[i].Name+" i32)")
-			} else if scope[i].EntryType == "array" || scope[i].EntryType == "record" {
-				s.PrintError(inputData, "WASM: no local arrays, records")
+			if scalar(scope[i]) && !scope[i].Addressed {
+				e.emit("(local $" + scope[i].Name + " i32)")
+			} else if scalar(scope[i]) || scope[i].ArrOrRec == "array" || scope[i].ArrOrRec == "record" {
+				// Arrays and records are addressed through the frame like
+				// the variables nested procedures use.
+				scope[i].Addressed = true
+				f := e.funcs[len(e.funcs)-1]
+				if f.locals == 0 {
+					f.locals = e.inFrame(scope[i])
+				} else {
+					e.inFrame(scope[i])
+				}
 			} else {
-				s.PrintError(inputData, "WASM: type?")
+				e.mark("WASM: type?")
 			}
 		}
 		i += 1
 	}
+}
 
-	return st.None
+// Gives a variable of the procedure being generated a place in its frame and
+// returns its offset.
+func (e *Emitter) inFrame(entry *st.SymTableEntry) int {
+	f := e.funcs[len(e.funcs)-1]
+	if f.size == 0 {
+		f.size = 4
+	}
+	entry.Adr = f.size
+	f.size += (entry.Size + 3) / 4 * 4
+	return entry.Adr
+}
+
+// Pushes the frame of the procedure at scope level lev, following the static
+// links from the frame of the current procedure.
+func (e *Emitter) frameOf(lev int) {
+	if lev == e.Curlev {
+		e.emit("local.get $_fp")
+		return
+	}
+	e.emit("local.get $_sl")
+	for l := e.Curlev - 1; l > lev; l-- {
+		e.emit("i32.load")
+	}
 }
 
 // Loads a sym table entry onto the stack.
-func loadItem(entry *st.SymTableEntry, inputData *i.InputData) {
+func (e *Emitter) loadItem(entry *st.SymTableEntry) {
 	if entry.EntryType == "var" {
-		if entry.Lev == 0 {
-			inputData.Asm = append(inputData.Asm, "global.get $"+entry.Name)
-		} else if entry.Lev == inputData.Curlev {
-			inputData.Asm = append(inputData.Asm, "local.get $"+entry.Name)
+		if entry.Lev == -3 {
+			e.emit("local.get $" + entry.Name)
+		} else if entry.Lev == 0 {
+			e.emit("global.get $" + entry.Name)
+		} else if entry.Lev == e.Curlev {
+			e.emit("local.get $" + entry.Name)
 		} else if entry.Lev == -2 {
-			inputData.Asm = append(inputData.Asm, "i32.const"+strconv.Itoa(entry.Adr))
-			inputData.Asm = append(inputData.Asm, "i32.load")
+			e.emit("i32.const " + strconv.Itoa(entry.Adr))
+			e.emit("i32.load")
 		} else if entry.Lev != -1 {
-			s.PrintError(inputData, "WASM: var Level")
+			e.mark("WASM: var Level")
 		}
 	} else if entry.EntryType == "ref" {
 		if entry.Lev == -1 {
-			inputData.Asm = append(inputData.Asm, "i32.load")
-		} else if entry.Lev == inputData.Curlev {
-			inputData.Asm = append(inputData.Asm, "local.get $"+entry.Name)
-			inputData.Asm = append(inputData.Asm, "i32.load")
+			e.emit("i32.load")
+		} else if entry.Lev == e.Curlev {
+			e.emit("local.get $" + entry.Name)
+			e.emit("i32.load")
 		} else {
-			s.PrintError(inputData, "WASM: ref Level")
+			e.mark("WASM: ref Level")
 		}
 	} else if entry.EntryType == "const" {
-		inputData.Asm = append(inputData.Asm, "i32.const "+strconv.Itoa(entry.Val))
+		e.emit("i32.const " + strconv.Itoa(entry.Val))
+	}
+}
+
+// Reports whether the code for an item has left something on the stack, a
+// value or an address.
+func onStack(x *st.SymTableEntry) bool {
+	return x.Lev == -1 && x.EntryType != "const"
+}
+
+// Loads x and then y onto the stack. If y is already on the stack and x is
+// not a value on the stack yet, y is set aside in $_swap while x is loaded.
+func (e *Emitter) loadPair(x *st.SymTableEntry, y *st.SymTableEntry) {
+	if onStack(y) && !(x.EntryType == "var" && x.Lev == -1) {
+		e.loadItem(y)
+		e.emit("local.set $_swap")
+		e.loadItem(x)
+		e.emit("local.get $_swap")
+	} else {
+		e.loadItem(x)
+		e.loadItem(y)
 	}
 }
 
 // Generates a var using the provided symbol table entry.
-func GenVar(entry *st.SymTableEntry, inputData *i.InputData) *st.SymTableEntry {
+func (e *Emitter) GenVar(entry *st.SymTableEntry) *st.SymTableEntry {
 	y := &st.SymTableEntry{}
 
-	if 0 < entry.Lev && entry.Lev < inputData.Curlev {
-		s.PrintError(inputData, "WASM: Level")
-	}
-	if entry.EntryType == "ref" {
+	if entry.Addressed && entry.Lev > 0 {
+		// The address of the variable, or the address a var parameter
+		// holds, is pushed.
+		e.frameOf(entry.Lev)
+		e.emit("i32.const "+strconv.Itoa(entry.Adr), "i32.add")
+		if entry.EntryType == "ref" {
+			e.emit("i32.load")
+		}
+		y = st.Ref(entry.Tp)
+		y.Lev = -1
+	} else if 0 < entry.Lev && entry.Lev < e.Curlev {
+		e.mark("WASM: Level")
+	} else if entry.EntryType == "ref" {
 		y = st.Ref(entry.Tp)
 		y.Lev = entry.Lev
 		y.Name = entry.Name
@@ -156,92 +318,109 @@ func GenVar(entry *st.SymTableEntry, inputData *i.InputData) *st.SymTableEntry {
 }
 
 // Constants are simply constants so they do not need any extra work.
-func GenConst(entry *st.SymTableEntry) *st.SymTableEntry {
+func (e *Emitter) GenConst(entry *st.SymTableEntry) *st.SymTableEntry {
 	return entry
 }
 
 // Generates code for operations with unary operators.
-func GenUnaryOp(op int, entry *st.SymTableEntry, inputData *i.InputData) *st.SymTableEntry {
-	loadItem(entry, inputData)
+func (e *Emitter) GenUnaryOp(op int, entry *st.SymTableEntry) *st.SymTableEntry {
+	e.loadItem(entry)
 	if op == k.MINUS {
-		inputData.Asm = append(inputData.Asm, "i32.const -1")
-		inputData.Asm = append(inputData.Asm, "i32.mul")
+		e.emit("i32.const -1")
+		e.emit("i32.mul")
 		entry.EntryType = "var"
 		entry.Tp = st.Int
 		entry.Lev = -1
 	} else if op == k.NOT {
-		inputData.Asm = append(inputData.Asm, "i32.eqz")
+		e.emit("i32.eqz")
+		entry.EntryType = "var"
 		entry.Tp = st.Bool
 		entry.Lev = -1
 	} else if op == k.AND {
-		inputData.Asm = append(inputData.Asm, "if (result i32)")
+		e.emit("if (result i32)")
+		entry.EntryType = "var"
 		entry.Tp = st.Bool
 		entry.Lev = -1
 	} else if op == k.OR {
-		inputData.Asm = append(inputData.Asm, "if (result i32)")
-		inputData.Asm = append(inputData.Asm, "i32.const 1")
-		inputData.Asm = append(inputData.Asm, "else")
+		e.emit("if (result i32)")
+		e.emit("i32.const 1")
+		e.emit("else")
+		entry.EntryType = "var"
 		entry.Tp = st.Bool
 		entry.Lev = -1
 	} else {
-		s.PrintError(inputData, "WASM: unary operator?")
+		e.mark("WASM: unary operator?")
 	}
 
 	return entry
 }
 
 // Generates code for operations with binary operators.
-func GenBinaryOp(op int, x *st.SymTableEntry, y *st.SymTableEntry, inputData *i.InputData) *st.SymTableEntry {
+func (e *Emitter) GenBinaryOp(op int, x *st.SymTableEntry, y *st.SymTableEntry) *st.SymTableEntry {
 	if op == k.PLUS || op == k.MINUS || op == k.TIMES || op == k.DIV || op == k.MOD {
-		loadItem(x, inputData)
-		loadItem(y, inputData)
+		e.loadPair(x, y)
 		if op == k.PLUS {
-			inputData.Asm = append(inputData.Asm, "i32.add")
+			e.emit("i32.add")
 		} else if op == k.MINUS {
-			inputData.Asm = append(inputData.Asm, "i32.sub")
+			e.emit("i32.sub")
 		} else if op == k.TIMES {
-			inputData.Asm = append(inputData.Asm, "i32.mul")
+			e.emit("i32.mul")
 		} else if op == k.DIV {
-			inputData.Asm = append(inputData.Asm, "i32.div_s")
+			e.emit("i32.div_s")
 		} else if op == k.MOD {
-			inputData.Asm = append(inputData.Asm, "i32.rem_s")
+			e.emit("i32.rem_s")
 		} else {
-			s.PrintError(inputData, "WASM: binary operator?")
+			e.mark("WASM: binary operator?")
 		}
 		x = st.Var(st.Int)
 		x.Lev = -1
 	} else if op == k.AND {
-		loadItem(y, inputData)
-		inputData.Asm = append(inputData.Asm, "else")
-		inputData.Asm = append(inputData.Asm, "i32.const 0")
-		inputData.Asm = append(inputData.Asm, "end")
+		e.loadItem(y)
+		e.emit("else")
+		e.emit("i32.const 0")
+		e.emit("end")
 		x = st.Var(st.Bool)
 		x.Lev = -1
 	} else if op == k.OR {
-		loadItem(y, inputData)
-		inputData.Asm = append(inputData.Asm, "end")
+		e.loadItem(y)
+		e.emit("end")
 		x = st.Var(st.Bool)
 		x.Lev = -1
 	}
 	return x
 }
 
+// Generates the check that succ and pred stay in an enumeration. Compared
+// unsigned, negative values are out of range too.
+func (e *Emitter) GenRange(x *st.SymTableEntry, n int) *st.SymTableEntry {
+	e.loadItem(x)
+	e.emit("local.tee $_swap")
+	e.emit("i32.const " + strconv.Itoa(n))
+	e.emit("i32.ge_u")
+	e.emit("if")
+	e.emit("unreachable")
+	e.emit("end")
+	e.emit("local.get $_swap")
+	x = st.Var(st.Int)
+	x.Lev = -1
+	return x
+}
+
 // Generates relations between two entries, such as x > 5.
-func GenRelation(op int, x *st.SymTableEntry, y *st.SymTableEntry, inputData *i.InputData) *st.SymTableEntry {
-	loadItem(x, inputData)
-	loadItem(y, inputData)
+func (e *Emitter) GenRelation(op int, x *st.SymTableEntry, y *st.SymTableEntry) *st.SymTableEntry {
+	e.loadPair(x, y)
 	if op == k.EQ {
-		inputData.Asm = append(inputData.Asm, "i32.eq")
+		e.emit("i32.eq")
 	} else if op == k.NE {
-		inputData.Asm = append(inputData.Asm, "i32.ne")
+		e.emit("i32.ne")
 	} else if op == k.LT {
-		inputData.Asm = append(inputData.Asm, "i32.lt_s")
+		e.emit("i32.lt_s")
 	} else if op == k.GT {
-		inputData.Asm = append(inputData.Asm, "i32.gt_s")
+		e.emit("i32.gt_s")
 	} else if op == k.LE {
-		inputData.Asm = append(inputData.Asm, "i32.le_s")
+		e.emit("i32.le_s")
 	} else if op == k.GE {
-		inputData.Asm = append(inputData.Asm, "i32.ge_s")
+		e.emit("i32.ge_s")
 	}
 
 	x = st.Var(st.Bool)
@@ -250,213 +429,372 @@ func GenRelation(op int, x *st.SymTableEntry, y *st.SymTableEntry, inputData *i.
 }
 
 // Generates selectors for records.
-func GenSelect(entry *st.SymTableEntry, field *st.SymTableEntry, inputData *i.InputData) *st.SymTableEntry {
+func (e *Emitter) GenSelect(entry *st.SymTableEntry, field *st.SymTableEntry) *st.SymTableEntry {
 	if entry.EntryType == "var" {
 		entry.Adr += field.Offset
 	} else if entry.EntryType == "ref" {
 		if entry.Lev > 0 {
-			inputData.Asm = append(inputData.Asm, "local.get $"+entry.Name)
+			e.emit("local.get $" + entry.Name)
 		}
-		inputData.Asm = append(inputData.Asm, "i32.const "+strconv.Itoa(field.Offset))
-		inputData.Asm = append(inputData.Asm, "i32.add")
+		e.emit("i32.const " + strconv.Itoa(field.Offset))
+		e.emit("i32.add")
 		entry.Lev = -1
 	}
 	entry.Tp = field.Tp
+	entry.Ctp = field.Ctp
+	entry.ArrOrRec = field.ArrOrRec
 	return entry
 }
 
 // Generates indexes for arrays.
-func GenIndex(x *st.SymTableEntry, y *st.SymTableEntry, inputData *i.InputData) *st.SymTableEntry {
+func (e *Emitter) GenIndex(x *st.SymTableEntry, y *st.SymTableEntry) *st.SymTableEntry {
+	elem := x.Ctp.Elem
 	if x.EntryType == "var" {
 		if y.EntryType == "const" {
 			x.Adr += (y.Val - x.Ctp.Lower) * x.Ctp.Size
-			x.Tp = x.Ctp.Base
 		} else {
-			loadItem(y, inputData)
+			e.loadItem(y)
 			if x.Ctp.Lower != 0 {
-				inputData.Asm = append(inputData.Asm, "i32.const "+strconv.Itoa(x.Ctp.Lower))
-				inputData.Asm = append(inputData.Asm, "i32.sub")
+				e.emit("i32.const " + strconv.Itoa(x.Ctp.Lower))
+				e.emit("i32.sub")
 			}
-			inputData.Asm = append(inputData.Asm, "i32.const "+strconv.Itoa(x.Ctp.Size))
-			inputData.Asm = append(inputData.Asm, "i32.mul")
-			inputData.Asm = append(inputData.Asm, "i32.const "+strconv.Itoa(x.Adr))
-			inputData.Asm = append(inputData.Asm, "i32.add")
+			e.emit("i32.const " + strconv.Itoa(x.Ctp.Size))
+			e.emit("i32.mul")
+			e.emit("i32.const " + strconv.Itoa(x.Adr))
+			e.emit("i32.add")
 			x.EntryType = "ref"
-			x.Tp = x.Ctp.Base
-		}
-	} else {
-		if x.Lev == inputData.Curlev {
-			loadItem(x, inputData)
 			x.Lev = -1
 		}
-		if x.EntryType == "const" {
-			inputData.Asm = append(inputData.Asm, "i32.const "+strconv.Itoa((y.Val-x.Ctp.Lower)*x.Ctp.Size))
-			inputData.Asm = append(inputData.Asm, "i32.add")
+	} else {
+		// The offset of the element is added to the address the var
+		// parameter holds, or the address on the stack.
+		if y.EntryType == "const" {
+			e.emit("i32.const " + strconv.Itoa((y.Val-x.Ctp.Lower)*x.Ctp.Size))
 		} else {
-			loadItem(y, inputData)
-			inputData.Asm = append(inputData.Asm, "i32.const "+strconv.Itoa(x.Ctp.Lower))
-			inputData.Asm = append(inputData.Asm, "i32.sub")
-			inputData.Asm = append(inputData.Asm, "i32.const "+strconv.Itoa(x.Ctp.Size))
-			inputData.Asm = append(inputData.Asm, "i32.mul")
-			inputData.Asm = append(inputData.Asm, "i32.add")
+			e.loadItem(y)
+			e.emit("i32.const " + strconv.Itoa(x.Ctp.Lower))
+			e.emit("i32.sub")
+			e.emit("i32.const " + strconv.Itoa(x.Ctp.Size))
+			e.emit("i32.mul")
+		}
+		if x.Lev == e.Curlev {
+			e.emit("local.get $" + x.Name)
 		}
+		e.emit("i32.add")
+		x.Lev = -1
 	}
-
+	x.Tp = elem.Tp
+	x.Ctp = elem.Ctp
+	x.ArrOrRec = elem.ArrOrRec
 	return x
 }
 
 // Generates assignment to variables.
-func GenAssign(x *st.SymTableEntry, y *st.SymTableEntry, inputData *i.InputData) {
+func (e *Emitter) GenAssign(x *st.SymTableEntry, y *st.SymTableEntry) {
+	if onStack(y) && (x.EntryType == "var" && x.Lev == -2 || x.EntryType == "ref" && x.Lev == e.Curlev) {
+		// The value is below where the address has to go; it is set aside in
+		// $_swap, which loadItem loads for Lev -3.
+		e.loadItem(y)
+		e.emit("local.set $_swap")
+		y = st.Var(y.Tp)
+		y.Name = "_swap"
+		y.Lev = -3
+	}
 	if x.EntryType == "var" {
 		if x.Lev == -2 {
-			inputData.Asm = append(inputData.Asm, "i32.const " + strconv.Itoa(x.Adr))
+			e.emit("i32.const " + strconv.Itoa(x.Adr))
 		}
-		loadItem(y, inputData)
+		e.loadItem(y)
 		if x.Lev == 0 {
-			inputData.Asm = append(inputData.Asm, "global.set $" + x.Name)
-		} else if x.Lev == inputData.Curlev {
-			inputData.Asm = append(inputData.Asm, "local.set $ " + x.Name)
+			e.emit("global.set $" + x.Name)
+		} else if x.Lev == e.Curlev {
+			e.emit("local.set $" + x.Name)
 		} else if x.Lev == -2 {
-			inputData.Asm = append(inputData.Asm, "i32.store")
+			e.emit("i32.store")
 		} else {
-			s.PrintError(inputData, "WASM: Level")
+			e.mark("WASM: Level")
 		}
 	} else if x.EntryType == "ref" {
-		if x.Lev == inputData.Curlev {
-			inputData.Asm = append(inputData.Asm, "local.get $" + x.Name)
+		if x.Lev == e.Curlev {
+			e.emit("local.get $" + x.Name)
 		}
-		loadItem(y, inputData)
-		inputData.Asm = append(inputData.Asm, "i32.store")
+		e.loadItem(y)
+		e.emit("i32.store")
 	}
 }
 
 // Generates the entry to the program.
-func GenProgEntry(ident string, inputData *i.InputData) {
-	inputData.Asm = append(inputData.Asm, "(func $program")
+func (e *Emitter) GenProgEntry(ident string) {
+	if e.stack {
+		e.emit("(global $_sp (mut i32) i32.const " + strconv.Itoa(e.Memsize) + ")")
+	}
+	e.emit("(func $program")
+	e.emit("(local $_swap i32)")
 }
 
 // Generates the exit to the program.
-func GenProgExit(x *st.SymTableEntry, inputData *i.InputData) string {
-	closingString := ")\n(memory " + strconv.Itoa(inputData.Memsize/int(math.Exp2(16))+1) + ")\n(start $program)\n)"
-	inputData.Asm = append(inputData.Asm, closingString)
+func (e *Emitter) GenProgExit(x *st.SymTableEntry) string {
+	if e.stack {
+		e.Memsize += stackSize
+	}
+	closingString := ")\n(memory " + strconv.Itoa(e.Memsize/int(math.Exp2(16))+1) + ")\n(start $program)\n)"
+	e.emit(closingString)
 	outputCode := ""
-	for _, asm := range inputData.Asm {
+	for _, asm := range e.Asm {
 		outputCode += "\n" + asm
 	}
 
 	return outputCode
 }
 
-// Generates function signatures.
-func GenProcStart(ident string, listOfParams []st.SymTableEntry, inputData *i.InputData) {
-	if inputData.Curlev > 0 {
-		s.PrintError(inputData, "WASM: no nested procedures")
+// Returns a function name for a procedure that no other procedure has, as
+// nested procedures in different places can have the same name.
+func (e *Emitter) label(ident string) string {
+	name := ident
+	for i := 2; e.used[name]; i++ {
+		name = ident + "." + strconv.Itoa(i)
 	}
-	inputData.Curlev += 1
+	e.used[name] = true
+	return name
+}
+
+// Generates function signatures. Procedures declared in procedures get the
+// static link as their last parameter; functions return an i32.
+func (e *Emitter) GenProcStart(ident string, listOfParams []*st.SymTableEntry, result *st.SymTableEntry) {
+	if e.Curlev > 0 {
+		e.funcs[len(e.funcs)-1].children = true
+	}
+	name := e.label(ident)
+	e.labels[e.Curlev][ident] = name
+	e.Curlev += 1
+	e.labels = append(e.labels, map[string]string{})
+	f := &wasmFunc{nested: e.Curlev > 1, params: listOfParams}
+	e.funcs = append(e.funcs, f)
 	params := ""
 
 	for _, param := range listOfParams {
 		params += "(param $" + param.Name + " i32)"
+		if param.EntryType == "var" && param.ArrOrRec != "" {
+			// Arrays and records passed by value are copied to the frame.
+			param.Addressed = true
+		}
+		if param.Addressed {
+			e.inFrame(param)
+		}
+	}
+	if f.nested {
+		params += "(param $_sl i32)"
+	}
+	if result != nil {
+		params += "(result i32)"
 	}
 
-	inputData.Asm = append(inputData.Asm, "(func $"+ident+params)
+	e.emit("(func $" + name + params)
 }
 
-// Dummy function for generating procedure entries.
-func GenProcEntry(inputData *i.InputData) {
-	//pass
+// Generates procedure entries: the scratch local of loadPair and, if the
+// procedure has a frame, the code that allocates it and stores the static
+// link and the parameters kept there.
+func (e *Emitter) GenProcEntry() {
+	f := e.funcs[len(e.funcs)-1]
+	e.emit("(local $_swap i32)")
+	if f.children && f.size == 0 {
+		f.size = 4
+	}
+	if f.size == 0 {
+		return
+	}
+	e.stack = true
+	e.emit("(local $_fp i32)",
+		"global.get $_sp",
+		"local.tee $_fp",
+		"i32.const "+strconv.Itoa(f.size),
+		"i32.add",
+		"global.set $_sp")
+	if f.nested {
+		e.emit("local.get $_fp", "local.get $_sl", "i32.store")
+	}
+	for _, param := range f.params {
+		if param.Addressed && param.EntryType == "var" && param.ArrOrRec != "" {
+			// $_swap runs over the bytes of the array or record.
+			e.emit("i32.const 0",
+				"local.set $_swap",
+				"loop",
+				"local.get $_fp",
+				"local.get $_swap",
+				"i32.add",
+				"local.get $"+param.Name,
+				"local.get $_swap",
+				"i32.add",
+				"i32.load",
+				"i32.store offset="+strconv.Itoa(param.Adr),
+				"local.get $_swap",
+				"i32.const 4",
+				"i32.add",
+				"local.tee $_swap",
+				"i32.const "+strconv.Itoa(param.Size),
+				"i32.lt_u",
+				"br_if 0",
+				"end")
+		} else if param.Addressed {
+			e.emit("local.get $_fp", "local.get $"+param.Name, "i32.store offset="+strconv.Itoa(param.Adr))
+		}
+	}
+	if f.locals > 0 {
+		// $_swap runs over the words of the local variables.
+		e.emit("local.get $_fp",
+			"i32.const "+strconv.Itoa(f.locals),
+			"i32.add",
+			"local.set $_swap",
+			"loop",
+			"local.get $_swap",
+			"i32.const 0",
+			"i32.store",
+			"local.get $_swap",
+			"i32.const 4",
+			"i32.add",
+			"local.tee $_swap",
+			"local.get $_fp",
+			"i32.const "+strconv.Itoa(f.size),
+			"i32.add",
+			"i32.lt_u",
+			"br_if 0",
+			"end")
+	}
 }
 
-// Generates procedure exits, which is simply a closing parenthesis.
-func GenProcExit(x *st.SymTableEntry, inputData *i.InputData) {
-	inputData.Curlev -= 1
-	inputData.Asm = append(inputData.Asm, ")")
+// Generates the value a function returns, which stays on the stack while the
+// frame is freed.
+func (e *Emitter) GenReturn(x *st.SymTableEntry) {
+	e.loadItem(x)
+}
+
+// Generates procedure exits: the frame, if any, is freed and the function
+// closed. Its code then goes after the functions generated so far.
+func (e *Emitter) GenProcExit(x *st.SymTableEntry) {
+	f := e.funcs[len(e.funcs)-1]
+	if f.size > 0 {
+		e.emit("local.get $_fp", "global.set $_sp")
+	}
+	e.emit(")")
+	e.funcs = e.funcs[:len(e.funcs)-1]
+	e.Asm = append(e.Asm, f.asm...)
+	e.Spans = append(e.Spans, f.spans...)
+	e.labels = e.labels[:len(e.labels)-1]
+	e.Curlev -= 1
 }
 
 // Generates the actual parameters using the provided formal parameters.
-func GenActualPara(ap *st.SymTableEntry, fp *st.SymTableEntry, inputData *i.InputData) *st.SymTableEntry {
-	if fp.EntryType == "ref" {
+// Variables passed by reference and arrays and records are passed by address.
+func (e *Emitter) GenActualPara(ap *st.SymTableEntry, fp *st.SymTableEntry) *st.SymTableEntry {
+	if fp.EntryType == "ref" || fp.ArrOrRec != "" {
 		if ap.Lev == -2 {
-			inputData.Asm = append(inputData.Asm, "i32.const "+strconv.Itoa(ap.Adr))
+			e.emit("i32.const " + strconv.Itoa(ap.Adr))
+		} else if ap.EntryType == "ref" && ap.Lev == e.Curlev {
+			// A var parameter passed on.
+			e.emit("local.get $" + ap.Name)
+		} else if !(ap.EntryType == "ref" && ap.Lev == -1) {
+			e.mark("WASM: not addressable")
 		}
 	} else if ap.EntryType == "var" || ap.EntryType == "ref" || ap.EntryType == "const" {
-		loadItem(ap, inputData)
+		e.loadItem(ap)
 	} else {
-		s.PrintError(inputData, "unsupported parameter type")
+		e.mark("unsupported parameter type")
 	}
 
 	return ap
 }
 
 // Generates function calls.
-func GenCall(entry *st.SymTableEntry, inputData *i.InputData) *st.SymTableEntry {
-	inputData.Asm = append(inputData.Asm, "call $" + entry.Name)
+func (e *Emitter) GenCall(entry *st.SymTableEntry) *st.SymTableEntry {
+	if entry.Lev > 0 {
+		e.frameOf(entry.Lev)
+	}
+	e.emit("call $" + e.labels[entry.Lev][entry.Name])
+	if entry.Result != nil {
+		// The result is on the stack.
+		y := st.Var(entry.Result.Tp)
+		y.Lev = -1
+		return y
+	}
 	return entry
 }
 
 // Generates call to the WASM stdproc read().
-func GenRead(x *st.SymTableEntry, inputData *i.InputData) {
-	inputData.Asm = append(inputData.Asm, "call $read")
-	y := st.Var(st.Int)
-	y.Lev = -1
+func (e *Emitter) GenRead(x *st.SymTableEntry) {
+	if x.EntryType == "var" && x.Lev == -2 {
+		e.emit("i32.const " + strconv.Itoa(x.Adr))
+	} else if x.EntryType == "ref" && x.Lev == e.Curlev {
+		e.emit("local.get $" + x.Name)
+	}
+	e.emit("call $read")
+	if x.EntryType == "ref" || x.Lev == -2 {
+		e.emit("i32.store")
+	} else if x.Lev == 0 {
+		e.emit("global.set $" + x.Name)
+	} else if x.Lev == e.Curlev {
+		e.emit("local.set $" + x.Name)
+	} else {
+		e.mark("WASM: Level")
+	}
 }
 
 // Generates call to the WASM stdproc write().
-func GenWrite(x *st.SymTableEntry, inputData *i.InputData) {
-	loadItem(x, inputData)
-	inputData.Asm = append(inputData.Asm, "call $write")
+func (e *Emitter) GenWrite(x *st.SymTableEntry) {
+	e.loadItem(x)
+	e.emit("call $write")
 }
 
 // Generates call to the WASM stdproc writeln().
-func GenWriteln(inputData *i.InputData) {
-	inputData.Asm = append(inputData.Asm, "call $writeln")
+func (e *Emitter) GenWriteln() {
+	e.emit("call $writeln")
 }
 
 // Dummy function for generating sequences.
-func GenSeq(x *st.SymTableEntry, y *st.SymTableEntry, inputData *i.InputData) {
+func (e *Emitter) GenSeq(x *st.SymTableEntry, y *st.SymTableEntry) {
 	//pass
 }
 
 // Generates then.
-func GenThen(x *st.SymTableEntry, inputData *i.InputData) *st.SymTableEntry {
-	loadItem(x, inputData)
-	inputData.Asm = append(inputData.Asm, "if")
+func (e *Emitter) GenThen(x *st.SymTableEntry) *st.SymTableEntry {
+	e.loadItem(x)
+	e.emit("if")
 	return x
 }
 
 // Generates if/then.
-func GenIfThen(x *st.SymTableEntry, y *st.SymTableEntry, inputData *i.InputData) *st.SymTableEntry {
-	inputData.Asm = append(inputData.Asm, "end")
+func (e *Emitter) GenIfThen(x *st.SymTableEntry, y *st.SymTableEntry) *st.SymTableEntry {
+	e.emit("end")
 	return x
 }
 
 // Generates else.
-func GenElse(x *st.SymTableEntry, y *st.SymTableEntry, inputData *i.InputData) *st.SymTableEntry {
-	inputData.Asm = append(inputData.Asm, "else")
+func (e *Emitter) GenElse(x *st.SymTableEntry, y *st.SymTableEntry) *st.SymTableEntry {
+	e.emit("else")
 	return y
 }
 
 // Generates if/else
-func GenIfElse(x *st.SymTableEntry, y *st.SymTableEntry, z *st.SymTableEntry, inputData *i.InputData) *st.SymTableEntry {
-	inputData.Asm = append(inputData.Asm, "end")
+func (e *Emitter) GenIfElse(x *st.SymTableEntry, y *st.SymTableEntry, z *st.SymTableEntry) *st.SymTableEntry {
+	e.emit("end")
 	return x
 }
 
 // Generates while.
-func GenWhile(inputData *i.InputData) {
-	inputData.Asm = append(inputData.Asm, "loop")
+func (e *Emitter) GenWhile() {
+	e.emit("loop")
 }
 
 // Generates do.
-func GenDo(x *st.SymTableEntry, inputData *i.InputData) *st.SymTableEntry {
-	loadItem(x, inputData)
-	inputData.Asm = append(inputData.Asm, "if")
+func (e *Emitter) GenDo(x *st.SymTableEntry) *st.SymTableEntry {
+	e.loadItem(x)
+	e.emit("if")
 	return x
 }
 
 // Generates while/do.
-func GenWhileDo(x *st.SymTableEntry, y *st.SymTableEntry, inputData *i.InputData) {
-	inputData.Asm = append(inputData.Asm, "br 1")
-	inputData.Asm = append(inputData.Asm, "end")
-	inputData.Asm = append(inputData.Asm, "end")
+func (e *Emitter) GenWhileDo(x *st.SymTableEntry, y *st.SymTableEntry) {
+	e.emit("br 1")
+	e.emit("end")
+	e.emit("end")
 }
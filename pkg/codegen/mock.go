@@ -0,0 +1,261 @@
+package codegen
+
+import (
+	k "group-11/pkg/keywords"
+	s "group-11/pkg/scanner"
+	"group-11/pkg/source"
+	st "group-11/pkg/symtable"
+	"strconv"
+	"strings"
+)
+
+// A target that generates no code but records the hooks it is called with, for
+// testing the parser and the walker without comparing WAT. Items are named
+// after the expressions they stand for, such as "(x + 1)".
+type MockTarget struct {
+	Calls []string    // One line per hook called, such as "GenAssign x (x + 1)".
+	At    source.Span // Construct being generated.
+}
+
+var _ Target = (*MockTarget)(nil)
+
+// Creates a MockTarget with no calls recorded.
+func NewMockTarget() *MockTarget {
+	return &MockTarget{Calls: []string{}}
+}
+
+// Records a call of hook with the names of its arguments.
+func (m *MockTarget) record(hook string, args ...string) {
+	m.Calls = append(m.Calls, strings.Join(append([]string{hook}, args...), " "))
+}
+
+// Returns an item of the same kind and type as x named name.
+func named(x *st.SymTableEntry, name string) *st.SymTableEntry {
+	y := st.Typed(x.EntryType, x)
+	y.Name = name
+	return y
+}
+
+// Sets the construct being generated.
+func (m *MockTarget) SetAt(span source.Span) {
+	m.At = span
+}
+
+// Records the start of the program.
+func (m *MockTarget) GenProgStart() {
+	m.record("GenProgStart")
+}
+
+// Records the entry to the program.
+func (m *MockTarget) GenProgEntry(ident string) {
+	m.record("GenProgEntry", ident)
+}
+
+// Records the exit of the program and returns the recorded calls, one per line.
+func (m *MockTarget) GenProgExit(x *st.SymTableEntry) string {
+	m.record("GenProgExit")
+	return strings.Join(m.Calls, "\n")
+}
+
+// Returns the boolean type unchanged; sizes are not needed.
+func (m *MockTarget) GenBool(tp *st.SymTableEntry) *st.SymTableEntry {
+	return tp
+}
+
+// Returns the integer type unchanged; sizes are not needed.
+func (m *MockTarget) GenInt(tp *st.SymTableEntry) *st.SymTableEntry {
+	return tp
+}
+
+// Records a record type.
+func (m *MockTarget) GenRec(tp *st.SymTableEntry) *st.SymTableEntry {
+	m.record("GenRec", tp.Name)
+	return tp
+}
+
+// Records an array type.
+func (m *MockTarget) GenArray(tp *st.SymTableEntry) *st.SymTableEntry {
+	m.record("GenArray", tp.Name)
+	return tp
+}
+
+// Returns the names of entries.
+func names(entries []*st.SymTableEntry) []string {
+	ns := []string{}
+	for _, x := range entries {
+		ns = append(ns, x.Name)
+	}
+	return ns
+}
+
+// Records the global variables.
+func (m *MockTarget) GenGlobalVars(vars []*st.SymTableEntry, start int) {
+	m.record("GenGlobalVars", names(vars[start:])...)
+}
+
+// Records the local variables of a procedure.
+func (m *MockTarget) GenLocalVars(vars []*st.SymTableEntry, start int) {
+	m.record("GenLocalVars", names(vars[start:])...)
+}
+
+// Returns an item named after the variable.
+func (m *MockTarget) GenVar(entry *st.SymTableEntry) *st.SymTableEntry {
+	return named(entry, entry.Name)
+}
+
+// Returns an item named after the value of the constant.
+func (m *MockTarget) GenConst(entry *st.SymTableEntry) *st.SymTableEntry {
+	return named(entry, strconv.Itoa(entry.Val))
+}
+
+// Records a unary operator. The first operand of and and or is passed here
+// before the second one is generated.
+func (m *MockTarget) GenUnaryOp(op int, x *st.SymTableEntry) *st.SymTableEntry {
+	m.record("GenUnaryOp", s.KindName(op), x.Name)
+	if op == k.MINUS || op == k.NOT {
+		return named(x, "("+s.KindName(op)+" "+x.Name+")")
+	}
+	return x
+}
+
+// Records a binary operator.
+func (m *MockTarget) GenBinaryOp(op int, x *st.SymTableEntry, y *st.SymTableEntry) *st.SymTableEntry {
+	m.record("GenBinaryOp", s.KindName(op), x.Name, y.Name)
+	z := st.Var(y.Tp)
+	z.Name = "(" + x.Name + " " + s.KindName(op) + " " + y.Name + ")"
+	return z
+}
+
+// Records a range check.
+func (m *MockTarget) GenRange(x *st.SymTableEntry, n int) *st.SymTableEntry {
+	m.record("GenRange", x.Name, strconv.Itoa(n))
+	return x
+}
+
+// Records a relation.
+func (m *MockTarget) GenRelation(op int, x *st.SymTableEntry, y *st.SymTableEntry) *st.SymTableEntry {
+	m.record("GenRelation", s.KindName(op), x.Name, y.Name)
+	z := st.Var(st.Bool)
+	z.Name = "(" + x.Name + " " + s.KindName(op) + " " + y.Name + ")"
+	return z
+}
+
+// Returns an item named after the selected field.
+func (m *MockTarget) GenSelect(x *st.SymTableEntry, field *st.SymTableEntry) *st.SymTableEntry {
+	y := named(field, x.Name+"."+field.Name)
+	y.EntryType = x.EntryType
+	return y
+}
+
+// Returns an item named after the indexed element.
+func (m *MockTarget) GenIndex(x *st.SymTableEntry, y *st.SymTableEntry) *st.SymTableEntry {
+	z := named(x.Ctp.Elem, x.Name+"["+y.Name+"]")
+	z.EntryType = x.EntryType
+	return z
+}
+
+// Records an assignment.
+func (m *MockTarget) GenAssign(x *st.SymTableEntry, y *st.SymTableEntry) {
+	m.record("GenAssign", x.Name, y.Name)
+}
+
+// Sequences are not recorded, as every statement records itself.
+func (m *MockTarget) GenSeq(x *st.SymTableEntry, y *st.SymTableEntry) {
+}
+
+// Records the condition of an if statement.
+func (m *MockTarget) GenThen(x *st.SymTableEntry) *st.SymTableEntry {
+	m.record("GenThen", x.Name)
+	return x
+}
+
+// Records the end of an if statement without else.
+func (m *MockTarget) GenIfThen(x *st.SymTableEntry, y *st.SymTableEntry) *st.SymTableEntry {
+	m.record("GenIfThen")
+	return x
+}
+
+// Records the else branch of an if statement.
+func (m *MockTarget) GenElse(x *st.SymTableEntry, y *st.SymTableEntry) *st.SymTableEntry {
+	m.record("GenElse")
+	return y
+}
+
+// Records the end of an if statement with else.
+func (m *MockTarget) GenIfElse(x *st.SymTableEntry, y *st.SymTableEntry, z *st.SymTableEntry) *st.SymTableEntry {
+	m.record("GenIfElse")
+	return x
+}
+
+// Records the start of a while statement.
+func (m *MockTarget) GenWhile() {
+	m.record("GenWhile")
+}
+
+// Records the condition of a while statement.
+func (m *MockTarget) GenDo(x *st.SymTableEntry) *st.SymTableEntry {
+	m.record("GenDo", x.Name)
+	return x
+}
+
+// Records the end of a while statement.
+func (m *MockTarget) GenWhileDo(x *st.SymTableEntry, y *st.SymTableEntry) {
+	m.record("GenWhileDo")
+}
+
+// Records the start of a procedure, with the name of the result type after a
+// colon for functions.
+func (m *MockTarget) GenProcStart(ident string, params []*st.SymTableEntry, result *st.SymTableEntry) {
+	args := append([]string{ident}, names(params)...)
+	if result != nil {
+		args = append(args, ":", result.Name)
+	}
+	m.record("GenProcStart", args...)
+}
+
+// Records the entry to a procedure body.
+func (m *MockTarget) GenProcEntry() {
+	m.record("GenProcEntry")
+}
+
+// Records the value a function returns.
+func (m *MockTarget) GenReturn(x *st.SymTableEntry) {
+	m.record("GenReturn", x.Name)
+}
+
+// Records the exit of a procedure.
+func (m *MockTarget) GenProcExit(x *st.SymTableEntry) {
+	m.record("GenProcExit")
+}
+
+// Records an actual parameter.
+func (m *MockTarget) GenActualPara(ap *st.SymTableEntry, fp *st.SymTableEntry) *st.SymTableEntry {
+	m.record("GenActualPara", ap.Name, fp.EntryType, fp.Name)
+	return ap
+}
+
+// Records a procedure call.
+func (m *MockTarget) GenCall(entry *st.SymTableEntry) *st.SymTableEntry {
+	m.record("GenCall", entry.Name)
+	if entry.Result != nil {
+		y := st.Var(entry.Result.Tp)
+		y.Name = entry.Name + "()"
+		return y
+	}
+	return entry
+}
+
+// Records a call of read.
+func (m *MockTarget) GenRead(x *st.SymTableEntry) {
+	m.record("GenRead", x.Name)
+}
+
+// Records a call of write.
+func (m *MockTarget) GenWrite(x *st.SymTableEntry) {
+	m.record("GenWrite", x.Name)
+}
+
+// Records a call of writeln.
+func (m *MockTarget) GenWriteln() {
+	m.record("GenWriteln")
+}
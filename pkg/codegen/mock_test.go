@@ -0,0 +1,58 @@
+package codegen_test
+
+import (
+	cg "group-11/pkg/codegen"
+	"group-11/pkg/compiler"
+	s "group-11/pkg/scanner"
+	"group-11/pkg/source"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// The walker calls the target's hooks in source order, with the operands named
+// after the expressions they stand for.
+func TestMockTarget(t *testing.T) {
+	src := `program mock;
+  var x: integer;
+  procedure inc(var n: integer);
+    begin n := n + 1 end;
+  begin
+    read(x);
+    if x > 0 then inc(x);
+    write(x)
+  end.
+`
+	c := compiler.New(source.NewFileSet().AddString("mock.p0", src), s.Options{})
+	prog := c.Analyze()
+	if prog == nil {
+		t.Fatalf("diagnostics: %v", c.Diagnostics)
+	}
+	m := cg.NewMockTarget()
+	got := cg.GenProgram(prog, m)
+	want := []string{
+		"GenProgStart",
+		"GenGlobalVars x",
+		"GenProcStart inc n",
+		"GenLocalVars",
+		"GenProcEntry",
+		"GenBinaryOp + n 1",
+		"GenAssign n (n + 1)",
+		"GenProcExit",
+		"GenProgEntry mock",
+		"GenRead x",
+		"GenRelation > x 0",
+		"GenThen (x > 0)",
+		"GenActualPara x ref n",
+		"GenCall inc",
+		"GenIfThen",
+		"GenWrite x",
+		"GenProgExit",
+	}
+	if got != strings.Join(m.Calls, "\n") {
+		t.Errorf("GenProgram returned %q, not the recorded calls", got)
+	}
+	if !reflect.DeepEqual(m.Calls, want) {
+		t.Errorf("calls:\n%s\nwant:\n%s", got, strings.Join(want, "\n"))
+	}
+}
@@ -0,0 +1,67 @@
+package codegen
+
+import (
+	"group-11/pkg/source"
+	st "group-11/pkg/symtable"
+)
+
+// A code generator that GenProgram drives through a checked program. Every
+// construct is passed to the target in source order, the way the P0 code
+// generators are called. Items are symbol table entries that describe where a
+// value is; each target decides what it keeps in them. The Emitter generates
+// WASM.
+type Target interface {
+	// Sets the construct being generated, for error messages.
+	SetAt(span source.Span)
+
+	// Program start and exit. GenProgExit returns the generated code.
+	GenProgStart()
+	GenProgEntry(ident string)
+	GenProgExit(x *st.SymTableEntry) string
+
+	// Types and variables. The type hooks compute sizes and offsets.
+	GenBool(tp *st.SymTableEntry) *st.SymTableEntry
+	GenInt(tp *st.SymTableEntry) *st.SymTableEntry
+	GenRec(tp *st.SymTableEntry) *st.SymTableEntry
+	GenArray(tp *st.SymTableEntry) *st.SymTableEntry
+	GenGlobalVars(vars []*st.SymTableEntry, start int)
+	GenLocalVars(vars []*st.SymTableEntry, start int)
+
+	// Expressions.
+	GenVar(entry *st.SymTableEntry) *st.SymTableEntry
+	GenConst(entry *st.SymTableEntry) *st.SymTableEntry
+	GenUnaryOp(op int, x *st.SymTableEntry) *st.SymTableEntry
+	GenBinaryOp(op int, x *st.SymTableEntry, y *st.SymTableEntry) *st.SymTableEntry
+	GenRelation(op int, x *st.SymTableEntry, y *st.SymTableEntry) *st.SymTableEntry
+	GenSelect(x *st.SymTableEntry, field *st.SymTableEntry) *st.SymTableEntry
+	GenIndex(x *st.SymTableEntry, y *st.SymTableEntry) *st.SymTableEntry
+	// Checks that x, computed by succ or pred, is the ordinal number of one of
+	// the n values of an enumeration, and stops the program otherwise.
+	GenRange(x *st.SymTableEntry, n int) *st.SymTableEntry
+
+	// Statements and control flow.
+	GenAssign(x *st.SymTableEntry, y *st.SymTableEntry)
+	GenSeq(x *st.SymTableEntry, y *st.SymTableEntry)
+	GenThen(x *st.SymTableEntry) *st.SymTableEntry
+	GenIfThen(x *st.SymTableEntry, y *st.SymTableEntry) *st.SymTableEntry
+	GenElse(x *st.SymTableEntry, y *st.SymTableEntry) *st.SymTableEntry
+	GenIfElse(x *st.SymTableEntry, y *st.SymTableEntry, z *st.SymTableEntry) *st.SymTableEntry
+	GenWhile()
+	GenDo(x *st.SymTableEntry) *st.SymTableEntry
+	GenWhileDo(x *st.SymTableEntry, y *st.SymTableEntry)
+
+	// Procedures and calls. result is the result type of a function, nil for
+	// procedures; GenReturn gets the value a function returns after its body,
+	// and GenCall returns the item of the result.
+	GenProcStart(ident string, params []*st.SymTableEntry, result *st.SymTableEntry)
+	GenProcEntry()
+	GenReturn(x *st.SymTableEntry)
+	GenProcExit(x *st.SymTableEntry)
+	GenActualPara(ap *st.SymTableEntry, fp *st.SymTableEntry) *st.SymTableEntry
+	GenCall(entry *st.SymTableEntry) *st.SymTableEntry
+	GenRead(x *st.SymTableEntry)
+	GenWrite(x *st.SymTableEntry)
+	GenWriteln()
+}
+
+var _ Target = (*Emitter)(nil)
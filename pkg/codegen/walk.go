@@ -0,0 +1,298 @@
+package codegen
+
+import (
+	"group-11/pkg/ast"
+	k "group-11/pkg/keywords"
+	st "group-11/pkg/symtable"
+)
+
+// Generates code for a checked program by walking its syntax tree, calling the
+// Gen functions of the target in the order the constructs appear in the source.
+func GenProgram(prog *ast.Program, t Target) string {
+	markAddressed(prog)
+	t.SetAt(prog.Span())
+	t.GenProgStart()
+	genDecls(prog.Decls, true, t)
+	t.SetAt(prog.Body.Span())
+	t.GenProgEntry(prog.Name.Name)
+	x := genStatement(prog.Body, t)
+	return t.GenProgExit(x)
+}
+
+// Computes the size of a type, the offsets of its fields and the sizes of its
+// elements.
+func layout(tp *st.SymTableEntry, t Target) *st.SymTableEntry {
+	if tp.ArrOrRec == "array" {
+		tp.Ctp.Size = layout(tp.Ctp.Elem, t).Size
+		return t.GenArray(tp)
+	} else if tp.ArrOrRec == "record" {
+		for _, f := range tp.Ctp.Fields {
+			layout(f, t)
+		}
+		return t.GenRec(tp)
+	} else if tp.Tp == st.Bool {
+		return t.GenBool(tp)
+	}
+	return t.GenInt(tp)
+}
+
+// Marks the variables and parameters whose address is needed, which targets
+// that keep variables outside of memory have to keep in memory: variables
+// passed by reference and the variables that procedures nested in the
+// procedure declaring them use.
+func markAddressed(prog *ast.Program) {
+	ast.Inspect(prog, func(n ast.Node) bool {
+		var proc *ast.Ident
+		var args []ast.Expr
+		if call, ok := n.(*ast.CallStmt); ok {
+			proc, args = call.Proc, call.Args
+		} else if call, ok := n.(*ast.CallExpr); ok {
+			proc, args = call.Proc, call.Args
+		}
+		if proc != nil && proc.Obj != nil && proc.Obj.EntryType == "proc" {
+			for j, arg := range args {
+				if j < len(proc.Obj.Par) && proc.Obj.Par[j].EntryType == "ref" {
+					if obj := rootVar(arg); obj != nil && obj.EntryType == "var" {
+						obj.Addressed = true
+					}
+				}
+			}
+		}
+		return true
+	})
+	markNested(prog.Decls)
+}
+
+// Returns the entry of the variable a designator starts with, nil if it is
+// not a designator.
+func rootVar(x ast.Expr) *st.SymTableEntry {
+	switch x := x.(type) {
+	case *ast.Ident:
+		return x.Obj
+	case *ast.SelectorExpr:
+		return rootVar(x.X)
+	case *ast.IndexExpr:
+		return rootVar(x.X)
+	}
+	return nil
+}
+
+// Marks the variables and parameters that procedures nested in the procedure
+// declaring them use.
+func markNested(decls []ast.Decl) {
+	for _, d := range decls {
+		p, ok := d.(*ast.ProcDecl)
+		if !ok {
+			continue
+		}
+		lev := p.Name.Obj.Lev + 1
+		var mark func(n ast.Node) bool
+		mark = func(n ast.Node) bool {
+			switch n := n.(type) {
+			case *ast.SelectorExpr:
+				// The field is not a variable.
+				ast.Inspect(n.X, mark)
+				return false
+			case *ast.Ident:
+				if obj := n.Obj; obj != nil && (obj.EntryType == "var" || obj.EntryType == "ref") && 0 < obj.Lev && obj.Lev < lev {
+					obj.Addressed = true
+				}
+			}
+			return true
+		}
+		ast.Inspect(p.Body, mark)
+		if p.Return != nil {
+			ast.Inspect(p.Return, mark)
+		}
+		markNested(p.Decls)
+	}
+}
+
+// Generates the variables and procedures of a declaration part.
+func genDecls(decls []ast.Decl, global bool, t Target) {
+	vars := []*st.SymTableEntry{}
+	for _, d := range decls {
+		t.SetAt(d.Span())
+		if td, ok := d.(*ast.TypeDecl); ok {
+			layout(td.Name.Obj, t)
+		} else if v, ok := d.(*ast.VarDecl); ok {
+			for _, id := range v.Names {
+				vars = append(vars, layout(id.Obj, t))
+			}
+		}
+	}
+	if global {
+		t.GenGlobalVars(vars, 0)
+	} else {
+		t.GenLocalVars(vars, 0)
+	}
+	for _, d := range decls {
+		if p, ok := d.(*ast.ProcDecl); ok {
+			genProc(p, t)
+		}
+	}
+}
+
+// Generates a procedure.
+func genProc(d *ast.ProcDecl, t Target) {
+	t.SetAt(d.Span())
+	for _, fp := range d.Name.Obj.Par {
+		layout(fp, t)
+	}
+	t.GenProcStart(d.Name.Name, d.Name.Obj.Par, d.Name.Obj.Result)
+	genDecls(d.Decls, false, t)
+	t.SetAt(d.Body.Span())
+	t.GenProcEntry()
+	x := genStatement(d.Body, t)
+	if d.Return != nil {
+		y := genExpression(d.Return, t)
+		t.SetAt(d.Return.Span())
+		t.GenReturn(y)
+	}
+	t.GenProcExit(x)
+}
+
+// Generates a statement.
+func genStatement(stmt ast.Stmt, t Target) *st.SymTableEntry {
+	t.SetAt(stmt.Span())
+	switch n := stmt.(type) {
+	case *ast.AssignStmt:
+		x := genExpression(n.Lhs, t)
+		y := genExpression(n.Rhs, t)
+		t.SetAt(n.Span())
+		t.GenAssign(x, y)
+		return x
+	case *ast.CallStmt:
+		x := n.Proc.Obj
+		var y *st.SymTableEntry
+		for j, arg := range n.Args {
+			y = genExpression(arg, t)
+			if x.EntryType == "proc" {
+				t.SetAt(arg.Span())
+				t.GenActualPara(y, x.Par[j])
+			}
+		}
+		t.SetAt(n.Span())
+		if x.EntryType == "stdproc" {
+			if x.Name == "read" {
+				t.GenRead(y)
+			} else if x.Name == "write" {
+				t.GenWrite(y)
+			} else if x.Name == "writeln" {
+				t.GenWriteln()
+			}
+			return x
+		}
+		return t.GenCall(x)
+	case *ast.CompoundStmt:
+		if len(n.Stmts) == 0 {
+			// The body of a function that only returns a value.
+			return nil
+		}
+		x := genStatement(n.Stmts[0], t)
+		for _, s := range n.Stmts[1:] {
+			y := genStatement(s, t)
+			t.GenSeq(x, y)
+		}
+		return x
+	case *ast.IfStmt:
+		x := genExpression(n.Cond, t)
+		t.SetAt(n.Cond.Span())
+		x = t.GenThen(x)
+		y := genStatement(n.Then, t)
+		t.SetAt(n.Span())
+		if n.Else != nil {
+			y = t.GenElse(x, y)
+			z := genStatement(n.Else, t)
+			t.SetAt(n.Span())
+			return t.GenIfElse(x, y, z)
+		}
+		return t.GenIfThen(x, y)
+	case *ast.WhileStmt:
+		t.GenWhile()
+		x := genExpression(n.Cond, t)
+		t.SetAt(n.Cond.Span())
+		x = t.GenDo(x)
+		y := genStatement(n.Body, t)
+		t.SetAt(n.Span())
+		t.GenWhileDo(x, y)
+		return x
+	}
+	return nil
+}
+
+// Generates an expression and returns the item describing where its value is.
+// Constants were folded by the parser and are not generated operand by operand.
+func genExpression(x ast.Expr, t Target) *st.SymTableEntry {
+	if info := x.Info(); info.Const {
+		return t.GenConst(st.Const(info.Type.Tp, info.Val))
+	}
+	switch n := x.(type) {
+	case *ast.Ident:
+		t.SetAt(n.Span())
+		return t.GenVar(n.Obj)
+	case *ast.ParenExpr:
+		return genExpression(n.X, t)
+	case *ast.SelectorExpr:
+		y := genExpression(n.X, t)
+		t.SetAt(n.Span())
+		return t.GenSelect(y, n.Field.Obj)
+	case *ast.IndexExpr:
+		y := genExpression(n.X, t)
+		z := genExpression(n.Index, t)
+		t.SetAt(n.Span())
+		return t.GenIndex(y, z)
+	case *ast.UnaryExpr:
+		y := genExpression(n.X, t)
+		if n.Op == k.PLUS {
+			return y
+		}
+		t.SetAt(n.Span())
+		return t.GenUnaryOp(n.Op, y)
+	case *ast.BinaryExpr:
+		if xi := n.X.Info(); xi.Const && (n.Op == k.AND || n.Op == k.OR) {
+			// true and y, false or y: the value is that of y.
+			return genExpression(n.Y, t)
+		}
+		y := genExpression(n.X, t)
+		if n.Op == k.AND || n.Op == k.OR {
+			t.SetAt(n.Span())
+			y = t.GenUnaryOp(n.Op, y)
+		}
+		z := genExpression(n.Y, t)
+		t.SetAt(n.Span())
+		if n.Op == k.EQ || n.Op == k.NE || n.Op == k.LT || n.Op == k.LE || n.Op == k.GT || n.Op == k.GE {
+			return t.GenRelation(n.Op, y, z)
+		}
+		return t.GenBinaryOp(n.Op, y, z)
+	case *ast.CallExpr:
+		x := n.Proc.Obj
+		if x.EntryType == "stdfunc" {
+			// Values of enumerations are their ordinal numbers.
+			y := genExpression(n.Args[0], t)
+			if x.Name == "ord" && n.Args[0].Info().Type.Tp == st.Bool {
+				// Adding 0 makes a boolean an integer on targets where
+				// it is not one (JavaScript).
+				return t.GenBinaryOp(k.PLUS, y, t.GenConst(st.Const(st.Int, 0)))
+			} else if x.Name == "ord" {
+				return y
+			}
+			z := t.GenConst(st.Const(st.Int, 1))
+			t.SetAt(n.Span())
+			if x.Name == "succ" {
+				y = t.GenBinaryOp(k.PLUS, y, z)
+			} else {
+				y = t.GenBinaryOp(k.MINUS, y, z)
+			}
+			return t.GenRange(y, n.Args[0].Info().Type.Ctp.Length)
+		}
+		for j, arg := range n.Args {
+			y := genExpression(arg, t)
+			t.SetAt(arg.Span())
+			t.GenActualPara(y, x.Par[j])
+		}
+		t.SetAt(n.Span())
+		return t.GenCall(x)
+	}
+	return t.GenConst(st.Const(st.None, 0))
+}
@@ -0,0 +1,56 @@
+package compiler
+
+import (
+	cg "group-11/pkg/codegen"
+	s "group-11/pkg/scanner"
+	"group-11/pkg/source"
+	"strings"
+	"testing"
+)
+
+// Invalid WASM is reported as an internal compiler error at the construct whose
+// code is invalid. The code of write(x), on line 5, is replaced by code with
+// the bug.
+func TestInvalidWasm(t *testing.T) {
+	tests := []struct {
+		name string
+		code string // Replaces call $write.
+		msg  string
+	}{
+		{"unknown local", "local.get 7", "unknown local 7"},
+		{"stack underflow", "i32.add", "stack underflow: i32.add needs more operands"},
+		{"unbalanced block", "block", "block is not closed by end"},
+		{"type mismatch", "i32.eqz", "value left on the stack at the end of function $program is never used"},
+	}
+	src := "program t;\n  var x: integer;\n  begin\n    x := 1;\n    write(x)\n  end.\n"
+	for _, test := range tests {
+		c := New(source.NewFileSet().AddString("t.p0", src), s.Options{})
+		prog := c.Parse(c.Lexer())
+		cg.GenProgram(prog, c.Target)
+		e := c.Target.(*cg.Emitter)
+		replaced := false
+		for i, asm := range e.Asm {
+			if asm == "call $write" && e.Spans[i].Start.Line == 5 {
+				e.Asm[i], replaced = test.code, true
+			}
+		}
+		if !replaced {
+			t.Fatalf("no call $write generated for line 5")
+		}
+		if c.check("\n"+strings.Join(e.Asm, "\n"), e) {
+			t.Errorf("%s: code is valid", test.name)
+			continue
+		}
+		if len(c.Diagnostics) != 1 {
+			t.Errorf("%s: %d diagnostics", test.name, len(c.Diagnostics))
+			continue
+		}
+		d := c.Diagnostics[0]
+		if d.Code != "E401" || d.Message != "internal compiler error: "+test.msg {
+			t.Errorf("%s: %s %s, want E401 %s", test.name, d.Code, d.Message, test.msg)
+		}
+		if d.Span.Start.Line != 5 || d.Span.Start.Col != 5 {
+			t.Errorf("%s: reported at %d:%d, want 5:5", test.name, d.Span.Start.Line, d.Span.Start.Col)
+		}
+	}
+}
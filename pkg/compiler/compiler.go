@@ -0,0 +1,144 @@
+package compiler
+
+import (
+	"errors"
+	"group-11/pkg/ast"
+	"group-11/pkg/bytecode"
+	"group-11/pkg/cgen"
+	cg "group-11/pkg/codegen"
+	"group-11/pkg/diag"
+	"group-11/pkg/jsgen"
+	"group-11/pkg/llvmgen"
+	"group-11/pkg/parser"
+	s "group-11/pkg/scanner"
+	"group-11/pkg/source"
+	st "group-11/pkg/symtable"
+	"group-11/pkg/wasm"
+	"group-11/pkg/x86gen"
+	"strconv"
+	"strings"
+)
+
+// Compiles one P0 program. A Compiler owns its symbol table, its target, its
+// diagnostics and the lexer and parser it creates, and shares none of them, so
+// any number of Compilers can run at the same time.
+type Compiler struct {
+	File        *source.File
+	Options     s.Options
+	Syms        *st.SymbolTable
+	Target      cg.Target // Generates the code, WASM by default.
+	Diagnostics diag.List // Diagnostics found so far, in the order they were found.
+	lastError   int       // Offset of the last error, used to drop repeated errors.
+}
+
+// Creates a compiler for the given source file.
+func New(file *source.File, opts s.Options) *Compiler {
+	c := &Compiler{File: file, Options: opts, Syms: st.NewSymbolTable(), lastError: -1}
+	c.Target = cg.NewEmitter(c.Report)
+	return c
+}
+
+// Names of the targets code can be generated for.
+var Targets = []string{"wasm", "c", "x86", "llvm", "js", "bytecode"}
+
+// Selects the target to generate code for by its name.
+func (c *Compiler) SetTarget(name string) error {
+	switch name {
+	case "wasm":
+		c.Target = cg.NewEmitter(c.Report)
+	case "c":
+		c.Target = cgen.NewEmitter(c.Report)
+	case "x86":
+		c.Target = x86gen.NewEmitter(c.Report)
+	case "llvm":
+		c.Target = llvmgen.NewEmitter(c.Report)
+	case "js":
+		c.Target = jsgen.NewEmitter(c.Report)
+	case "bytecode":
+		c.Target = bytecode.NewEmitter(c.Report)
+	default:
+		return errors.New("unknown target " + name)
+	}
+	return nil
+}
+
+// Records a diagnostic. An error that is not past the previous error is
+// dropped, since it is then most likely caused by it.
+func (c *Compiler) Report(d diag.Diagnostic) {
+	if d.Severity == diag.Error {
+		if d.Span.Start.Offset <= c.lastError {
+			return
+		}
+		c.lastError = d.Span.Start.Offset
+	}
+	c.Diagnostics = append(c.Diagnostics, d)
+}
+
+// Reports whether any errors were found.
+func (c *Compiler) Failed() bool {
+	return c.Diagnostics.Errors() > 0
+}
+
+// Returns a lexer for the source file, set up with the compiler's options.
+func (c *Compiler) Lexer() *s.Lexer {
+	lex := s.NewLexer(c.File.Content, c.File.ID, c.Report)
+	lex.SetOptions(c.Options)
+	return lex
+}
+
+// Parses the program, reading tokens from toks.
+func (c *Compiler) Parse(toks s.TokenReader) *ast.Program {
+	return parser.New(toks, c.Syms, c.Report).Program()
+}
+
+// Parses and checks the program without generating code, for running it
+// directly. Returns nil if errors were found.
+func (c *Compiler) Analyze() *ast.Program {
+	prog := c.Parse(c.Lexer())
+	if c.Failed() {
+		return nil
+	}
+	return prog
+}
+
+// Generates code for a parsed program. Returns "" if errors were found, as the
+// tree may then be incomplete, or if generated WASM is not valid.
+func (c *Compiler) Generate(prog *ast.Program) string {
+	if c.Failed() {
+		return ""
+	}
+	code := cg.GenProgram(prog, c.Target)
+	if e, ok := c.Target.(*cg.Emitter); ok && !c.check(code, e) {
+		return ""
+	}
+	if c.Failed() {
+		return ""
+	}
+	return code
+}
+
+// Validates generated WASM. Every problem is a bug in the code generator and
+// is reported as an internal compiler error at the construct whose code has
+// it. Returns whether the code is valid.
+func (c *Compiler) check(code string, e *cg.Emitter) bool {
+	errs := wasm.Check(code)
+	lines := strings.Split(code, "\n")
+	for _, err := range errs {
+		d := diag.NewError("E401", e.SpanOf(err.Line), "internal compiler error: "+err.Msg)
+		if err.Line >= 1 && err.Line <= len(lines) {
+			d = d.WithNote("generated line " + strconv.Itoa(err.Line) + ": " + lines[err.Line-1])
+		}
+		c.Report(d)
+	}
+	return len(errs) == 0
+}
+
+// Compiles the program in the current goroutine and returns the generated
+// WASM, or "" if the program has errors, together with the diagnostics found.
+func (c *Compiler) Compile() (string, diag.List) {
+	code := c.Generate(c.Parse(c.Lexer()))
+	if c.Failed() {
+		return "", c.Diagnostics
+	}
+	return code, c.Diagnostics
+}
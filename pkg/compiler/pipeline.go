@@ -0,0 +1,99 @@
+package compiler
+
+import (
+	"fmt"
+	k "group-11/pkg/keywords"
+	s "group-11/pkg/scanner"
+	"time"
+	"unicode/utf8"
+)
+
+// Number of bytes of source text in each chunk sent down the pipeline.
+const ChunkSize = 4096
+
+// Number of chunks or tokens each channel between two stages can hold. A stage
+// that gets this far ahead of the next one blocks until it catches up.
+const ChannelSize = 64
+
+// Figures collected while compiling a program.
+type Stats struct {
+	Bytes   int           // Bytes of source text read.
+	Tokens  int           // Tokens handed to the parser.
+	Elapsed time.Duration // Time from reading the source to the generated code.
+}
+
+// Formats the figures together with the throughput they amount to.
+func (stats Stats) String() string {
+	secs := stats.Elapsed.Seconds()
+	if secs == 0 {
+		secs = 1e-9
+	}
+	return fmt.Sprintf("%d bytes, %d tokens in %v (%.2f MB/s, %.0f tokens/s)",
+		stats.Bytes, stats.Tokens, stats.Elapsed, float64(stats.Bytes)/secs/1e6, float64(stats.Tokens)/secs)
+}
+
+// Splits the source text into chunks of at most size bytes and sends them down
+// out, never splitting a UTF-8 sequence. Closes out when done.
+func SendChunks(src string, size int, out chan<- string) {
+	for len(src) > 0 {
+		n := size
+		if n >= len(src) {
+			n = len(src)
+		} else {
+			for n > 1 && !utf8.RuneStart(src[n]) {
+				n -= 1
+			}
+		}
+		out <- src[:n]
+		src = src[n:]
+	}
+	close(out)
+}
+
+// Compiles the input with each stage in its own goroutine: the source is split
+// into chunks, the chunks are turned into tokens and the parser generates code
+// from the tokens. The stages are connected by channels
+// of ChannelSize elements, and the first two are stopped once the parser is
+// done. Returns the generated code, or "" if the program
+// has errors.
+func (c *Compiler) Pipeline() (string, Stats) {
+	start := time.Now()
+	src := c.File.Content
+	chunks := make(chan string, ChannelSize)
+	go SendChunks(src, ChunkSize, chunks)
+	toks := s.StreamTokens(chunks, c.File.ID, ChannelSize, c.Options, c.Report)
+	prog := c.Parse(toks)
+	toks.Close()
+	code := c.Generate(prog)
+	return code, Stats{
+		Bytes:   len(src),
+		Tokens:  toks.Count(),
+		Elapsed: time.Since(start)}
+}
+
+// Compiles the input one stage after the other in the current goroutine.
+// Produces the same code as Pipeline.
+func (c *Compiler) Sequential() (string, Stats) {
+	start := time.Now()
+	src := c.File.Content
+	counter := &countingReader{TokenReader: c.Lexer()}
+	code := c.Generate(c.Parse(counter))
+	return code, Stats{
+		Bytes:   len(src),
+		Tokens:  counter.count,
+		Elapsed: time.Since(start)}
+}
+
+// Counts the tokens read from a TokenReader.
+type countingReader struct {
+	s.TokenReader
+	count int
+}
+
+func (c *countingReader) Next() s.Token {
+	tok := c.TokenReader.Next()
+	if tok.Kind != k.EOF {
+		c.count += 1
+	}
+	return tok
+}
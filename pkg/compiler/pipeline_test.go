@@ -0,0 +1,77 @@
+package compiler_test
+
+import (
+	"group-11/pkg/compiler"
+	s "group-11/pkg/scanner"
+	"group-11/pkg/source"
+	"os"
+	"reflect"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Sources for comparing Pipeline with Sequential: the programs that are run,
+// one spanning many chunks, one with errors and one with a lot of text after
+// the end of the program, which the lexer must not be left scanning.
+func pipelineSources(t *testing.T) map[string]string {
+	srcs := map[string]string{}
+	for _, p := range programs {
+		b, err := os.ReadFile(p.path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		srcs[p.path] = string(b)
+	}
+	srcs["long"] = "program long;\n  var x: integer;\n  begin\n    x := 0" +
+		strings.Repeat(";\n    x := x + 1", compiler.ChunkSize/4) + ";\n    write(x)\n  end.\n"
+	srcs["errors"] = "program errors;\n  var x: integer;\n  begin\n    x := y + true;\n    write(x\n  end.\n"
+	srcs["trailing"] = "program trailing;\n  begin\n    writeln\n  end.\n" +
+		strings.Repeat("x := x + 1; (* not part of the program *)\n", 4*compiler.ChannelSize)
+	return srcs
+}
+
+// Compiles src by one of the two methods, returning the code, the number of
+// tokens and the diagnostics.
+func compileWith(name, src string, pipeline bool) (string, int, []string) {
+	c := compiler.New(source.NewFileSet().AddString(name, src), s.Options{})
+	var code string
+	var stats compiler.Stats
+	if pipeline {
+		code, stats = c.Pipeline()
+	} else {
+		code, stats = c.Sequential()
+	}
+	diags := []string{}
+	for _, d := range c.Diagnostics {
+		diags = append(diags, d.Code+" "+d.Message)
+	}
+	return code, stats.Tokens, diags
+}
+
+// Pipeline and Sequential produce the same code, token count and diagnostics,
+// and Pipeline leaves no goroutine behind. Meant to be run with -race.
+func TestPipeline(t *testing.T) {
+	before := runtime.NumGoroutine()
+	for name, src := range pipelineSources(t) {
+		code, toks, diags := compileWith(name, src, true)
+		seqCode, seqToks, seqDiags := compileWith(name, src, false)
+		if code != seqCode {
+			t.Errorf("%s: pipeline and sequential code differ", name)
+		}
+		if toks != seqToks {
+			t.Errorf("%s: pipeline read %d tokens, sequential %d", name, toks, seqToks)
+		}
+		if !reflect.DeepEqual(diags, seqDiags) {
+			t.Errorf("%s: pipeline diagnostics %q, sequential %q", name, diags, seqDiags)
+		}
+	}
+	deadline := time.Now().Add(time.Second)
+	for runtime.NumGoroutine() > before && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	if n := runtime.NumGoroutine(); n > before {
+		t.Errorf("%d goroutines left running", n-before)
+	}
+}
@@ -0,0 +1,186 @@
+package compiler_test
+
+import (
+	"bytes"
+	"group-11/pkg/bytecode"
+	"group-11/pkg/compiler"
+	"group-11/pkg/interp"
+	s "group-11/pkg/scanner"
+	"group-11/pkg/source"
+	"group-11/pkg/wasm"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// A program run end to end, with its input and the output it must write.
+type program struct {
+	path   string
+	input  string
+	want   string
+	nested bool // Has nested procedures, which C does not support.
+}
+
+var programs = []program{
+	{"../../config/p0code.txt", "47 5", " 9 2\n", false},
+	{"testdata/factorial.p0", "5", " 120", false},
+	{"testdata/records.p0", "4", " 4 13 0 9", false},
+	{"testdata/not.p0", "3", " 3 0 1 0", true},
+	{"testdata/locals.p0", "", " 0 0 0 0 0 0", false},
+	{"testdata/enums.p0", "3", " 2 1 3 4 9 1 2 2 0", false},
+	{"testdata/nesting.p0", "2", " 20 21 22 16 187", true},
+	{"testdata/frames.p0", "2", " 1 2 0 13 22 3 25 42 6", false},
+	{"testdata/refs.p0", "4", " 7 5 3 0 7 12 10 3 11", false},
+	{"testdata/functions.p0", "5", " 120 6 0 1 18 6", false},
+}
+
+// Programs that stop with an error after writing the output they must.
+var traps = []program{
+	{"testdata/range.p0", "0", " 2", false},
+	{"testdata/range.p0", "1", " 2", false},
+}
+
+// Compiles the program at path for target and returns the generated code.
+func compile(t *testing.T, path string, target string) string {
+	t.Helper()
+	fs := source.NewFileSet()
+	file, err := fs.AddFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := compiler.New(file, s.Options{})
+	if err := c.SetTarget(target); err != nil {
+		t.Fatal(err)
+	}
+	code, diags := c.Compile()
+	if code == "" {
+		t.Fatalf("%s: %d diagnostics, first: %v", path, len(diags), diags[0].Message)
+	}
+	return code
+}
+
+// Runs a program in one way, with its input from r and output to w.
+type runner func(t *testing.T, p program, r *strings.Reader, w *bytes.Buffer) error
+
+var runners = map[string]runner{
+	"wasm": func(t *testing.T, p program, r *strings.Reader, w *bytes.Buffer) error {
+		m, err := wasm.Parse(compile(t, p.path, "wasm"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return wasm.Run(m, r, w)
+	},
+	"interp": func(t *testing.T, p program, r *strings.Reader, w *bytes.Buffer) error {
+		fs := source.NewFileSet()
+		file, err := fs.AddFile(p.path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		prog := compiler.New(file, s.Options{}).Analyze()
+		if prog == nil {
+			t.Fatalf("%s has errors", p.path)
+		}
+		return interp.Run(prog, r, w)
+	},
+	"bytecode": func(t *testing.T, p program, r *strings.Reader, w *bytes.Buffer) error {
+		code, err := bytecode.Decode([]byte(compile(t, p.path, "bytecode")))
+		if err != nil {
+			t.Fatal(err)
+		}
+		host := bytecode.NewIOHost(r, w)
+		err = bytecode.NewVM(code, host).Run()
+		host.Flush()
+		return err
+	},
+	"js": func(t *testing.T, p program, r *strings.Reader, w *bytes.Buffer) error {
+		node, err := exec.LookPath("node")
+		if err != nil {
+			t.Skip("node not found")
+		}
+		js := filepath.Join(t.TempDir(), "result.js")
+		if err := os.WriteFile(js, []byte(compile(t, p.path, "js")), 0644); err != nil {
+			t.Fatal(err)
+		}
+		cmd := exec.Command(node, js)
+		cmd.Stdin, cmd.Stdout, cmd.Stderr = r, w, w
+		return cmd.Run()
+	},
+	"c": func(t *testing.T, p program, r *strings.Reader, w *bytes.Buffer) error {
+		cc, err := exec.LookPath("cc")
+		if err != nil {
+			t.Skip("cc not found")
+		}
+		if p.nested {
+			t.Skip("nested procedures")
+		}
+		dir := t.TempDir()
+		src, bin := filepath.Join(dir, "result.c"), filepath.Join(dir, "result")
+		if err := os.WriteFile(src, []byte(compile(t, p.path, "c")), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if out, err := exec.Command(cc, "-Wall", "-Werror", "-o", bin, src).CombinedOutput(); err != nil {
+			t.Fatalf("%v: %s", err, out)
+		}
+		cmd := exec.Command(bin)
+		cmd.Stdin, cmd.Stdout, cmd.Stderr = r, w, w
+		return cmd.Run()
+	},
+}
+
+// Compiles every program for every way of running it and checks its output.
+func TestRun(t *testing.T) {
+	for _, p := range programs {
+		for name, run := range runners {
+			t.Run(filepath.Base(p.path)+"/"+name, func(t *testing.T) {
+				var out bytes.Buffer
+				if err := run(t, p, strings.NewReader(p.input), &out); err != nil {
+					t.Fatalf("%v, output %q", err, out.String())
+				}
+				if out.String() != p.want {
+					t.Errorf("output %q, want %q", out.String(), p.want)
+				}
+			})
+		}
+	}
+}
+
+// Runs every program that must stop with an error in every way and checks that
+// it stops where it must.
+func TestTrap(t *testing.T) {
+	for _, p := range traps {
+		for name, run := range runners {
+			t.Run(filepath.Base(p.path)+"/"+p.input+"/"+name, func(t *testing.T) {
+				var out bytes.Buffer
+				err := run(t, p, strings.NewReader(p.input), &out)
+				if err == nil {
+					t.Fatalf("no error, output %q", out.String())
+				}
+				if !strings.HasPrefix(out.String(), p.want) || strings.Contains(out.String(), p.want+" ") {
+					t.Errorf("output %q, want %q", out.String(), p.want)
+				}
+			})
+		}
+	}
+}
+
+// Targets without nested procedures report the first one and nothing after it,
+// such as the variables of enclosing procedures that it uses.
+func TestNestedUnsupported(t *testing.T) {
+	for _, target := range []string{"c", "llvm"} {
+		fs := source.NewFileSet()
+		file, err := fs.AddFile("testdata/nesting.p0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		c := compiler.New(file, s.Options{})
+		if err := c.SetTarget(target); err != nil {
+			t.Fatal(err)
+		}
+		code, diags := c.Compile()
+		if code != "" || len(diags) != 1 || diags[0].Code != "E400" || !strings.HasSuffix(diags[0].Message, "no nested procedures") {
+			t.Errorf("%s: %d diagnostics %v", target, len(diags), diags)
+		}
+	}
+}
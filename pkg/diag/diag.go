@@ -0,0 +1,96 @@
+// Diagnostics: errors, warnings and notes about a program, with the source
+// text they refer to.
+//
+// Codes tell the kind of problem:
+//
+//	E1xx  lexical errors: characters, comments, numbers, identifiers
+//	E2xx  syntax errors: a symbol was expected but not found
+//	E3xx  semantic errors: declarations, types, parameters and constants
+//	E4xx  code generation: constructs the target cannot express, and invalid
+//	      generated code, which is an internal compiler error
+package diag
+
+import (
+	"group-11/pkg/source"
+)
+
+// How serious a diagnostic is.
+type Severity int
+
+const (
+	Error Severity = iota
+	Warning
+	Note
+)
+
+// Returns the name of the severity as printed in messages.
+func (sev Severity) String() string {
+	switch sev {
+	case Error:
+		return "error"
+	case Warning:
+		return "warning"
+	}
+	return "note"
+}
+
+// Another place in the source that helps to explain a diagnostic.
+type Related struct {
+	Span    source.Span
+	Message string
+}
+
+// A problem found in a program.
+type Diagnostic struct {
+	Severity Severity
+	Code     string      // Kind of problem, such as "E302".
+	Message  string      // What is wrong.
+	Span     source.Span // Where it is wrong.
+	Related  []Related   // Other places involved, like the start of an unfinished construct.
+	Notes    []string    // Further explanation.
+}
+
+// Called for every diagnostic found while compiling.
+type Handler func(d Diagnostic)
+
+// Returns an error diagnostic.
+func NewError(code string, span source.Span, msg string) Diagnostic {
+	return Diagnostic{Severity: Error, Code: code, Message: msg, Span: span}
+}
+
+// Returns a warning diagnostic.
+func NewWarning(code string, span source.Span, msg string) Diagnostic {
+	return Diagnostic{Severity: Warning, Code: code, Message: msg, Span: span}
+}
+
+// Returns the diagnostic with a note added.
+func (d Diagnostic) WithNote(note string) Diagnostic {
+	d.Notes = append(d.Notes[:len(d.Notes):len(d.Notes)], note)
+	return d
+}
+
+// Returns the diagnostic with a related place added.
+func (d Diagnostic) WithRelated(span source.Span, msg string) Diagnostic {
+	d.Related = append(d.Related[:len(d.Related):len(d.Related)], Related{span, msg})
+	return d
+}
+
+// Diagnostics collected while compiling a program.
+type List []Diagnostic
+
+// Returns the number of errors in the list.
+func (l List) Errors() int {
+	n := 0
+	for _, d := range l {
+		if d.Severity == Error {
+			n += 1
+		}
+	}
+	return n
+}
+
+// Looks up source files by ID, for printing the positions and text that
+// diagnostics refer to. A *source.FileSet is one.
+type Files interface {
+	File(id int) *source.File
+}
@@ -0,0 +1,163 @@
+package diag
+
+import (
+	"encoding/json"
+	"fmt"
+	"group-11/pkg/source"
+	"io"
+	"strings"
+	"unicode/utf8"
+)
+
+// Returns the name of the file a span is in.
+func fileName(files Files, span source.Span) string {
+	if f := files.File(span.File); f != nil {
+		return f.Name
+	}
+	return "<unknown>"
+}
+
+// Formats the start of a span as "name:line:col".
+func position(files Files, span source.Span) string {
+	return fmt.Sprintf("%s:%d:%d", fileName(files, span), span.Start.Line, span.Start.Col)
+}
+
+// Formats the first line of a diagnostic: "name:line:col: error: message [code]".
+func header(files Files, d Diagnostic) string {
+	text := position(files, d.Span) + ": " + d.Severity.String() + ": " + d.Message
+	if d.Code != "" {
+		text += " [" + d.Code + "]"
+	}
+	return text
+}
+
+// Writes diagnostics the way GCC does, one line each, followed by a note line
+// for every related place and every note.
+func WriteText(w io.Writer, files Files, diags []Diagnostic) {
+	for _, d := range diags {
+		fmt.Fprintln(w, header(files, d))
+		for _, r := range d.Related {
+			fmt.Fprintln(w, position(files, r.Span)+": note: "+r.Message)
+		}
+		for _, n := range d.Notes {
+			fmt.Fprintln(w, position(files, d.Span)+": note: "+n)
+		}
+	}
+}
+
+// Writes diagnostics like WriteText, but shows the source line of every place
+// with its text underlined.
+func WriteCaret(w io.Writer, files Files, diags []Diagnostic) {
+	for _, d := range diags {
+		fmt.Fprintln(w, header(files, d))
+		snippet(w, files, d.Span)
+		for _, r := range d.Related {
+			fmt.Fprintln(w, position(files, r.Span)+": note: "+r.Message)
+			snippet(w, files, r.Span)
+		}
+		for _, n := range d.Notes {
+			fmt.Fprintln(w, "      = note: "+n)
+		}
+	}
+}
+
+// Writes the first source line of a span with a caret under its first
+// character and tildes under the rest of it on that line.
+func snippet(w io.Writer, files Files, span source.Span) {
+	f := files.File(span.File)
+	if f == nil || span.Start.Offset > len(f.Content) {
+		return
+	}
+	start := span.Start.Offset
+	lineStart := strings.LastIndexByte(f.Content[:start], '\n') + 1
+	lineEnd := strings.IndexByte(f.Content[start:], '\n')
+	if lineEnd < 0 {
+		lineEnd = len(f.Content)
+	} else {
+		lineEnd += start
+	}
+	line := strings.TrimRight(f.Content[lineStart:lineEnd], "\r")
+	end := span.End.Offset
+	if end > lineStart+len(line) {
+		end = lineStart + len(line)
+	}
+	if end < start {
+		end = start
+	}
+
+	// Keep tabs in the padding so that the caret lines up with the text.
+	pad := []rune{}
+	for _, r := range f.Content[lineStart:start] {
+		if r == '\t' {
+			pad = append(pad, '\t')
+		} else {
+			pad = append(pad, ' ')
+		}
+	}
+	marks := "^"
+	if n := utf8.RuneCountInString(f.Content[start:end]); n > 1 {
+		marks += strings.Repeat("~", n-1)
+	}
+	num := fmt.Sprintf("%5d", span.Start.Line)
+	fmt.Fprintln(w, num+" | "+line)
+	fmt.Fprintln(w, strings.Repeat(" ", len(num))+" | "+string(pad)+marks)
+}
+
+// Position in JSON output.
+type jsonPos struct {
+	Line   int `json:"line"`
+	Col    int `json:"col"`
+	Offset int `json:"offset"`
+}
+
+// Span in JSON output.
+type jsonSpan struct {
+	File  string  `json:"file"`
+	Start jsonPos `json:"start"`
+	End   jsonPos `json:"end"`
+}
+
+// Related place in JSON output.
+type jsonRelated struct {
+	Span    jsonSpan `json:"span"`
+	Message string   `json:"message"`
+}
+
+// Diagnostic in JSON output.
+type jsonDiagnostic struct {
+	Severity string        `json:"severity"`
+	Code     string        `json:"code"`
+	Message  string        `json:"message"`
+	Span     jsonSpan      `json:"span"`
+	Related  []jsonRelated `json:"related"`
+	Notes    []string      `json:"notes"`
+}
+
+// Converts a span for JSON output.
+func toJSONSpan(files Files, span source.Span) jsonSpan {
+	return jsonSpan{
+		File:  fileName(files, span),
+		Start: jsonPos{span.Start.Line, span.Start.Col, span.Start.Offset},
+		End:   jsonPos{span.End.Line, span.End.Col, span.End.Offset}}
+}
+
+// Writes diagnostics as a JSON array, for editors and other tools.
+func WriteJSON(w io.Writer, files Files, diags []Diagnostic) error {
+	out := []jsonDiagnostic{}
+	for _, d := range diags {
+		jd := jsonDiagnostic{
+			Severity: d.Severity.String(),
+			Code:     d.Code,
+			Message:  d.Message,
+			Span:     toJSONSpan(files, d.Span),
+			Related:  []jsonRelated{},
+			Notes:    append([]string{}, d.Notes...)}
+		for _, r := range d.Related {
+			jd.Related = append(jd.Related, jsonRelated{toJSONSpan(files, r.Span), r.Message})
+		}
+		out = append(out, jd)
+	}
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(out)
+}
@@ -0,0 +1,373 @@
+// A tree-walking interpreter that runs P0 programs straight from the syntax
+// tree the parser returns. It is the reference for what programs mean and does
+// not depend on any code generator.
+//
+// Integers are 32 bits and wrap around; booleans are 0 and 1 and values of
+// enumerations their ordinal numbers. Division by zero and dividing the
+// smallest integer by -1 stop the program, as do an array index out of bounds
+// and succ or pred leaving an enumeration. read takes the next whitespace
+// separated integer of the input, 0 at its end; write writes " %d" and writeln
+// a newline.
+package interp
+
+import (
+	"bufio"
+	"fmt"
+	"group-11/pkg/ast"
+	k "group-11/pkg/keywords"
+	"group-11/pkg/source"
+	st "group-11/pkg/symtable"
+	"io"
+	"math"
+)
+
+// An error that stopped a program, at the construct it happened in.
+type RuntimeError struct {
+	Span source.Span
+	Msg  string
+}
+
+func (e *RuntimeError) Error() string {
+	return fmt.Sprintf("%d:%d: %s", e.Span.Start.Line, e.Span.Start.Col, e.Msg)
+}
+
+// The storage of a variable: an integer or boolean, or the elements of an
+// array or the fields of a record in the order they are declared.
+type cell struct {
+	val   int32
+	parts []*cell
+}
+
+// Returns the storage for a variable of the given type, set to zero.
+func newCell(tp *st.SymTableEntry) *cell {
+	c := &cell{}
+	if tp.ArrOrRec == "array" {
+		c.parts = make([]*cell, tp.Ctp.Length)
+		for i := range c.parts {
+			c.parts[i] = newCell(tp.Ctp.Elem)
+		}
+	} else if tp.ArrOrRec == "record" {
+		for _, f := range tp.Ctp.Fields {
+			c.parts = append(c.parts, newCell(f))
+		}
+	}
+	return c
+}
+
+// Copies the value of src to c, element by element.
+func (c *cell) assign(src *cell) {
+	c.val = src.val
+	for i, p := range src.parts {
+		c.parts[i].assign(p)
+	}
+}
+
+// Returns a copy of c.
+func (c *cell) copy() *cell {
+	d := &cell{val: c.val}
+	for _, p := range c.parts {
+		d.parts = append(d.parts, p.copy())
+	}
+	return d
+}
+
+// The variables of the main program or of a procedure call.
+type frame struct {
+	up    *frame // Frame of the procedure the procedure is declared in.
+	level int    // 0 for the main program, 1 for procedures declared in it, and so on.
+	vars  map[*st.SymTableEntry]*cell
+}
+
+// A declared procedure and the level of the frame it is declared in.
+type proc struct {
+	decl  *ast.ProcDecl
+	level int
+}
+
+// Runs programs.
+type Interpreter struct {
+	MaxDepth int // Most nested procedure calls.
+	in       *bufio.Reader
+	out      *bufio.Writer
+	procs    map[*st.SymTableEntry]proc
+	depth    int
+}
+
+// Creates an interpreter reading input from r and writing output to w.
+func New(r io.Reader, w io.Writer) *Interpreter {
+	return &Interpreter{MaxDepth: 10000, in: bufio.NewReader(r), out: bufio.NewWriter(w)}
+}
+
+// Runs a program that was parsed without errors. Output is written before
+// every read and when the program ends.
+func (it *Interpreter) Run(prog *ast.Program) (err error) {
+	it.procs, it.depth = map[*st.SymTableEntry]proc{}, 0
+	defer func() {
+		if r := recover(); r != nil {
+			rerr, ok := r.(*RuntimeError)
+			if !ok {
+				panic(r)
+			}
+			err = rerr
+		}
+		if ferr := it.out.Flush(); err == nil {
+			err = ferr
+		}
+	}()
+	f := &frame{vars: map[*st.SymTableEntry]*cell{}}
+	it.declare(f, prog.Decls)
+	it.statement(f, prog.Body)
+	return nil
+}
+
+// Runs a program that was parsed without errors with its input from r and
+// output to w.
+func Run(prog *ast.Program, r io.Reader, w io.Writer) error {
+	return New(r, w).Run(prog)
+}
+
+// Stops the program with an error at node.
+func fail(node ast.Node, msg string) {
+	panic(&RuntimeError{Span: node.Span(), Msg: msg})
+}
+
+// Makes the variables of a declaration part in f and remembers its procedures.
+func (it *Interpreter) declare(f *frame, decls []ast.Decl) {
+	for _, d := range decls {
+		switch d := d.(type) {
+		case *ast.VarDecl:
+			for _, id := range d.Names {
+				f.vars[id.Obj] = newCell(id.Obj)
+			}
+		case *ast.ProcDecl:
+			it.procs[d.Name.Obj] = proc{decl: d, level: f.level}
+		}
+	}
+}
+
+// Returns the storage of a variable, searching the frames along the static
+// links.
+func (f *frame) lookup(obj *st.SymTableEntry) *cell {
+	for ; f != nil; f = f.up {
+		if c, ok := f.vars[obj]; ok {
+			return c
+		}
+	}
+	return nil
+}
+
+// Returns the storage a designator denotes.
+func (it *Interpreter) place(f *frame, x ast.Expr) *cell {
+	switch x := x.(type) {
+	case *ast.Ident:
+		if c := f.lookup(x.Obj); c != nil {
+			return c
+		}
+	case *ast.SelectorExpr:
+		c := it.place(f, x.X)
+		for i, fld := range x.X.Info().Type.Ctp.Fields {
+			if fld == x.Field.Obj {
+				return c.parts[i]
+			}
+		}
+	case *ast.IndexExpr:
+		c := it.place(f, x.X)
+		tp := x.X.Info().Type.Ctp
+		i := int(it.eval(f, x.Index)) - tp.Lower
+		if i < 0 || i >= len(c.parts) {
+			fail(x.Index, "index out of bounds")
+		}
+		return c.parts[i]
+	}
+	fail(x, "variable expected")
+	return nil
+}
+
+// Returns the value of an integer or boolean expression.
+func (it *Interpreter) eval(f *frame, x ast.Expr) int32 {
+	if info := x.Info(); info.Const {
+		return int32(info.Val)
+	}
+	switch x := x.(type) {
+	case *ast.Ident:
+		if x.Obj.EntryType == "const" {
+			return int32(x.Obj.Val)
+		}
+		return it.place(f, x).val
+	case *ast.SelectorExpr, *ast.IndexExpr:
+		return it.place(f, x).val
+	case *ast.ParenExpr:
+		return it.eval(f, x.X)
+	case *ast.UnaryExpr:
+		y := it.eval(f, x.X)
+		if x.Op == k.NOT {
+			return 1 - y
+		} else if x.Op == k.MINUS {
+			return -y
+		}
+		return y
+	case *ast.BinaryExpr:
+		return it.binary(f, x)
+	case *ast.CallExpr:
+		if x.Proc.Obj.EntryType == "stdfunc" {
+			return it.stdFunc(f, x)
+		}
+		return it.call(f, x, x.Proc, x.Args)
+	}
+	fail(x, "expression expected")
+	return 0
+}
+
+// Returns the value of ord, succ or pred. Values of enumerations are their
+// ordinal numbers; succ of the last value and pred of the first stop the
+// program.
+func (it *Interpreter) stdFunc(f *frame, x *ast.CallExpr) int32 {
+	y := it.eval(f, x.Args[0])
+	switch x.Proc.Name {
+	case "succ":
+		y += 1
+	case "pred":
+		y -= 1
+	default:
+		return y
+	}
+	if y < 0 || int(y) >= x.Args[0].Info().Type.Ctp.Length {
+		fail(x, "value out of range")
+	}
+	return y
+}
+
+// Returns the value of a binary operator applied to its operands. and and or
+// only evaluate their right operand if the left one does not decide.
+func (it *Interpreter) binary(f *frame, b *ast.BinaryExpr) int32 {
+	x := it.eval(f, b.X)
+	if b.Op == k.AND && x == 0 || b.Op == k.OR && x != 0 {
+		return x
+	}
+	y := it.eval(f, b.Y)
+	switch b.Op {
+	case k.AND, k.OR:
+		return y
+	case k.PLUS:
+		return x + y
+	case k.MINUS:
+		return x - y
+	case k.TIMES:
+		return x * y
+	case k.DIV, k.MOD:
+		if y == 0 {
+			fail(b, "integer divide by zero")
+		} else if y == -1 && b.Op == k.MOD {
+			return 0
+		} else if y == -1 && x == math.MinInt32 {
+			fail(b, "integer overflow")
+		} else if b.Op == k.DIV {
+			return x / y
+		}
+		return x % y
+	}
+	r := false
+	switch b.Op {
+	case k.EQ:
+		r = x == y
+	case k.NE:
+		r = x != y
+	case k.LT:
+		r = x < y
+	case k.GT:
+		r = x > y
+	case k.LE:
+		r = x <= y
+	case k.GE:
+		r = x >= y
+	default:
+		fail(b, "operator expected")
+	}
+	if r {
+		return 1
+	}
+	return 0
+}
+
+// Runs a statement.
+func (it *Interpreter) statement(f *frame, s ast.Stmt) {
+	switch s := s.(type) {
+	case *ast.AssignStmt:
+		c := it.place(f, s.Lhs)
+		if s.Lhs.Info().Type.ArrOrRec != "" {
+			c.assign(it.place(f, s.Rhs))
+		} else {
+			c.val = it.eval(f, s.Rhs)
+		}
+	case *ast.CallStmt:
+		it.call(f, s, s.Proc, s.Args)
+	case *ast.CompoundStmt:
+		for _, t := range s.Stmts {
+			it.statement(f, t)
+		}
+	case *ast.IfStmt:
+		if it.eval(f, s.Cond) != 0 {
+			it.statement(f, s.Then)
+		} else if s.Else != nil {
+			it.statement(f, s.Else)
+		}
+	case *ast.WhileStmt:
+		for it.eval(f, s.Cond) != 0 {
+			it.statement(f, s.Body)
+		}
+	default:
+		fail(s, "statement expected")
+	}
+}
+
+// Calls a procedure or standard procedure and returns the result of a
+// function, 0 for procedures.
+func (it *Interpreter) call(f *frame, node ast.Node, id *ast.Ident, args []ast.Expr) int32 {
+	obj := id.Obj
+	if obj.EntryType == "stdproc" {
+		switch id.Name {
+		case "read":
+			c := it.place(f, args[0])
+			it.out.Flush()
+			var x int32
+			if _, err := fmt.Fscan(it.in, &x); err != nil {
+				x = 0
+			}
+			c.val = x
+		case "write":
+			fmt.Fprintf(it.out, " %d", it.eval(f, args[0]))
+		case "writeln":
+			it.out.WriteString("\n")
+		}
+		return 0
+	}
+	p, ok := it.procs[obj]
+	if !ok {
+		fail(id, "procedure expected")
+	}
+	callee := &frame{up: f, level: p.level + 1, vars: map[*st.SymTableEntry]*cell{}}
+	for callee.up.level > p.level {
+		callee.up = callee.up.up
+	}
+	for i, fp := range obj.Par {
+		if fp.EntryType == "ref" {
+			callee.vars[fp] = it.place(f, args[i])
+		} else if fp.ArrOrRec != "" {
+			callee.vars[fp] = it.place(f, args[i]).copy()
+		} else {
+			callee.vars[fp] = &cell{val: it.eval(f, args[i])}
+		}
+	}
+	if it.depth >= it.MaxDepth {
+		fail(node, "stack overflow")
+	}
+	it.depth += 1
+	it.declare(callee, p.decl.Decls)
+	it.statement(callee, p.decl.Body)
+	var result int32
+	if p.decl.Return != nil {
+		result = it.eval(callee, p.decl.Return)
+	}
+	it.depth -= 1
+	return result
+}
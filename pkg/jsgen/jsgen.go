@@ -0,0 +1,427 @@
+// JavaScript code generator: translates P0 programs into plain JavaScript
+// that runs without WebAssembly. The program becomes a function run(hooks)
+// with the global variables as its locals and every procedure as a nested
+// function, so nested procedures see the variables of enclosing ones. Control
+// flow follows the structure the WAT emitter generates.
+package jsgen
+
+import (
+	"fmt"
+	"group-11/pkg/diag"
+	k "group-11/pkg/keywords"
+	"group-11/pkg/source"
+	st "group-11/pkg/symtable"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// Output of the JavaScript code generator and the state it keeps while
+// generating. Items are symbol table entries whose Name is the JavaScript
+// expression for their value.
+type Emitter struct {
+	Lines  []string                        // Generated JavaScript, one line each.
+	Curlev int                             // Current scope level.
+	At     source.Span                     // Construct being generated, for error messages.
+	places map[*st.SymTableEntry][2]string // Array and record of every element and field item, and its key.
+	args   []string                        // Actual parameters of the calls being generated.
+	indent int
+	errors diag.Handler
+}
+
+// Creates an Emitter that reports errors to errh.
+func NewEmitter(errh diag.Handler) *Emitter {
+	return &Emitter{Lines: []string{}, places: map[*st.SymTableEntry][2]string{}, errors: errh}
+}
+
+// Reports an error at the construct being generated.
+func (e *Emitter) mark(msg string) {
+	e.errors(diag.NewError("E400", e.At, msg))
+}
+
+// Sets the construct being generated, for error messages.
+func (e *Emitter) SetAt(span source.Span) {
+	e.At = span
+}
+
+// Appends a line at the current indentation.
+func (e *Emitter) emit(line string) {
+	e.Lines = append(e.Lines, strings.Repeat("    ", e.indent)+line)
+}
+
+// Returns the JavaScript name of a P0 identifier. Every name gets a trailing
+// underscore, so that it cannot clash with JavaScript keywords or the
+// runtime, and characters outside ASCII are spelled out.
+func Mangle(name string) string {
+	b := strings.Builder{}
+	for _, r := range name {
+		if r < utf8.RuneSelf && (r == '_' || '0' <= r && r <= '9' || 'a' <= r && r <= 'z' || 'A' <= r && r <= 'Z') {
+			b.WriteRune(r)
+		} else if r <= 0xFFFF {
+			fmt.Fprintf(&b, "_u%04x", r)
+		} else {
+			fmt.Fprintf(&b, "_U%08x", r)
+		}
+	}
+	b.WriteString("_")
+	return b.String()
+}
+
+// Returns the initial value of a variable of the type of entry: zero, false,
+// or an array or object of those.
+func initial(entry *st.SymTableEntry) string {
+	if entry.ArrOrRec == "array" {
+		elem := initial(entry.Ctp.Elem)
+		if entry.Ctp.Elem.ArrOrRec == "record" {
+			// An arrow function would take the braces for a block.
+			elem = "(" + elem + ")"
+		}
+		return "p0.array(" + strconv.Itoa(entry.Ctp.Length) + ", () => " + elem + ")"
+	} else if entry.ArrOrRec == "record" {
+		fields := []string{}
+		for _, f := range entry.Ctp.Fields {
+			fields = append(fields, Mangle(f.Name)+": "+initial(f))
+		}
+		return "{ " + strings.Join(fields, ", ") + " }"
+	} else if entry.Tp == st.Bool {
+		return "false"
+	}
+	return "0"
+}
+
+// Removes parentheses around a whole expression.
+func bare(x string) string {
+	if !strings.HasPrefix(x, "(") || !strings.HasSuffix(x, ")") {
+		return x
+	}
+	depth := 0
+	for i, c := range x {
+		if c == '(' {
+			depth += 1
+		} else if c == ')' {
+			depth -= 1
+			if depth == 0 && i < len(x)-1 {
+				return x
+			}
+		}
+	}
+	return x[1 : len(x)-1]
+}
+
+// Returns an item of the same kind and type as x for the JavaScript
+// expression code.
+func item(x *st.SymTableEntry, code string) *st.SymTableEntry {
+	y := st.Typed(x.EntryType, x)
+	y.Name = code
+	y.Val = x.Val
+	return y
+}
+
+// Reports whether an entry is a record or an array.
+func structured(entry *st.SymTableEntry) bool {
+	return entry.ArrOrRec == "array" || entry.ArrOrRec == "record"
+}
+
+// Generates the start of programs.
+func (e *Emitter) GenProgStart() {
+	e.emit("// Generated by the P0 compiler.")
+	e.emit("\"use strict\";")
+	e.emit("")
+	e.emit("function run(hooks) {")
+	e.indent += 1
+	e.emit("const p0 = p0Runtime(hooks);")
+}
+
+// Specifies the Size of bool typed entries.
+func (e *Emitter) GenBool(entry *st.SymTableEntry) *st.SymTableEntry {
+	entry.Size = 4
+	return entry
+}
+
+// Specifies the Size of int typed entries.
+func (e *Emitter) GenInt(entry *st.SymTableEntry) *st.SymTableEntry {
+	entry.Size = 4
+	return entry
+}
+
+// Generates records, calculating the size. Records are objects with a
+// property per field.
+func (e *Emitter) GenRec(entry *st.SymTableEntry) *st.SymTableEntry {
+	s := 0
+	for _, f := range entry.Ctp.Fields {
+		f.Offset = s
+		s = s + f.Size
+	}
+	entry.Size = s
+	return entry
+}
+
+// Generates arrays, calculating the size. Arrays are JavaScript arrays.
+func (e *Emitter) GenArray(entry *st.SymTableEntry) *st.SymTableEntry {
+	entry.Size = entry.Ctp.Length * entry.Ctp.Size
+	return entry
+}
+
+// Generates the global variables.
+func (e *Emitter) GenGlobalVars(scope []*st.SymTableEntry, start int) {
+	e.GenLocalVars(scope, start)
+}
+
+// Generates the local variables of a procedure.
+func (e *Emitter) GenLocalVars(scope []*st.SymTableEntry, start int) {
+	for _, x := range scope[start:] {
+		if x.EntryType == "var" {
+			e.emit("let " + Mangle(x.Name) + " = " + initial(x) + ";")
+		}
+	}
+}
+
+// Generates a variable. A scalar var parameter holds a reference, whose
+// value is v; records and arrays are references already.
+func (e *Emitter) GenVar(entry *st.SymTableEntry) *st.SymTableEntry {
+	if entry.EntryType == "ref" && !structured(entry) {
+		return item(entry, Mangle(entry.Name)+".v")
+	}
+	return item(entry, Mangle(entry.Name))
+}
+
+// Generates a constant. Booleans are JavaScript booleans.
+func (e *Emitter) GenConst(entry *st.SymTableEntry) *st.SymTableEntry {
+	if entry.Tp == st.Bool {
+		return item(entry, strconv.FormatBool(entry.Val != 0))
+	} else if entry.Val < 0 {
+		return item(entry, "("+strconv.Itoa(entry.Val)+")")
+	}
+	return item(entry, strconv.Itoa(entry.Val))
+}
+
+// Generates operations with unary operators. For and and or, the first
+// operand is passed here before the second one is generated; JavaScript
+// evaluates && and || in the same short-circuit way.
+func (e *Emitter) GenUnaryOp(op int, x *st.SymTableEntry) *st.SymTableEntry {
+	y := st.Var(x.Tp)
+	if op == k.MINUS {
+		y.Name = "(-" + x.Name + " | 0)"
+	} else if op == k.NOT {
+		y.Name = "!" + x.Name
+	} else if op == k.AND || op == k.OR {
+		y.Name = x.Name
+	} else {
+		e.mark("JS: unary operator?")
+	}
+	return y
+}
+
+// JavaScript operators for addition, subtraction, and, or and the relations.
+var operators = map[int]string{k.PLUS: "+", k.MINUS: "-", k.AND: "&&", k.OR: "||", k.EQ: "===", k.NE: "!==", k.LT: "<", k.GT: ">", k.LE: "<=", k.GE: ">="}
+
+// Generates operations with binary operators. Results are truncated to 32
+// bits like WASM integers.
+func (e *Emitter) GenBinaryOp(op int, x *st.SymTableEntry, y *st.SymTableEntry) *st.SymTableEntry {
+	z := st.Var(x.Tp)
+	if op == k.PLUS || op == k.MINUS {
+		z.Name = "(" + x.Name + " " + operators[op] + " " + y.Name + " | 0)"
+	} else if op == k.TIMES {
+		z.Name = "Math.imul(" + bare(x.Name) + ", " + bare(y.Name) + ")"
+	} else if op == k.DIV {
+		z.Name = "p0.div(" + bare(x.Name) + ", " + bare(y.Name) + ")"
+	} else if op == k.MOD {
+		z.Name = "p0.mod(" + bare(x.Name) + ", " + bare(y.Name) + ")"
+	} else if op == k.AND || op == k.OR {
+		z.Name = "(" + x.Name + " " + operators[op] + " " + y.Name + ")"
+	} else {
+		e.mark("JS: binary operator?")
+	}
+	return z
+}
+
+// Generates the check that succ and pred stay in an enumeration.
+func (e *Emitter) GenRange(x *st.SymTableEntry, n int) *st.SymTableEntry {
+	z := st.Var(st.Int)
+	z.Name = "p0.range(" + bare(x.Name) + ", " + strconv.Itoa(n) + ")"
+	return z
+}
+
+// Generates relations between two entries, such as x > 5.
+func (e *Emitter) GenRelation(op int, x *st.SymTableEntry, y *st.SymTableEntry) *st.SymTableEntry {
+	z := st.Var(st.Bool)
+	z.Name = "(" + x.Name + " " + operators[op] + " " + y.Name + ")"
+	return z
+}
+
+// Generates selectors for records.
+func (e *Emitter) GenSelect(x *st.SymTableEntry, field *st.SymTableEntry) *st.SymTableEntry {
+	y := item(field, x.Name+"."+Mangle(field.Name))
+	y.EntryType = "var"
+	e.places[y] = [2]string{x.Name, "\"" + Mangle(field.Name) + "\""}
+	return y
+}
+
+// Generates indexes for arrays. JavaScript arrays start at 0.
+func (e *Emitter) GenIndex(x *st.SymTableEntry, y *st.SymTableEntry) *st.SymTableEntry {
+	index := bare(y.Name)
+	if x.Ctp.Lower != 0 {
+		if y.EntryType == "const" {
+			index = strconv.Itoa(y.Val - x.Ctp.Lower)
+		} else {
+			// | binds more loosely than -, so a sum keeps its parentheses.
+			index = y.Name + " - " + strconv.Itoa(x.Ctp.Lower)
+		}
+	}
+	z := item(x.Ctp.Elem, x.Name+"["+index+"]")
+	z.EntryType = "var"
+	e.places[z] = [2]string{x.Name, index}
+	return z
+}
+
+// Generates assignment to variables.
+func (e *Emitter) GenAssign(x *st.SymTableEntry, y *st.SymTableEntry) {
+	e.emit(x.Name + " = " + bare(y.Name) + ";")
+}
+
+// Generates the entry to the program.
+func (e *Emitter) GenProgEntry(ident string) {
+	e.emit("")
+}
+
+// Generates the exit of the program and returns the generated JavaScript.
+func (e *Emitter) GenProgExit(x *st.SymTableEntry) string {
+	e.indent -= 1
+	e.emit("}")
+	return strings.Join(e.Lines, "\n") + "\n" + Runtime
+}
+
+// Generates function declarations. Records and arrays passed by value are
+// copied on entry.
+func (e *Emitter) GenProcStart(ident string, listOfParams []*st.SymTableEntry, result *st.SymTableEntry) {
+	e.Curlev += 1
+	params := []string{}
+	for _, fp := range listOfParams {
+		params = append(params, Mangle(fp.Name))
+	}
+	e.emit("")
+	e.emit("function " + Mangle(ident) + "(" + strings.Join(params, ", ") + ") {")
+	e.indent += 1
+	for _, fp := range listOfParams {
+		if fp.EntryType == "var" && structured(fp) {
+			e.emit(Mangle(fp.Name) + " = p0.copy(" + Mangle(fp.Name) + ");")
+		}
+	}
+}
+
+// Dummy function for generating procedure entries.
+func (e *Emitter) GenProcEntry() {
+	//pass
+}
+
+// Generates the return statement of a function.
+func (e *Emitter) GenReturn(x *st.SymTableEntry) {
+	e.emit("return " + bare(x.Name) + ";")
+}
+
+// Generates procedure exits, which is simply a closing brace.
+func (e *Emitter) GenProcExit(x *st.SymTableEntry) {
+	e.Curlev -= 1
+	e.indent -= 1
+	e.emit("}")
+}
+
+// Generates the actual parameters. A scalar passed by var is passed as a
+// reference: the one a var parameter holds, one to an element or field, or
+// one with closures for a variable.
+func (e *Emitter) GenActualPara(ap *st.SymTableEntry, fp *st.SymTableEntry) *st.SymTableEntry {
+	if fp.EntryType != "ref" || structured(fp) {
+		e.args = append(e.args, bare(ap.Name))
+	} else if p, ok := e.places[ap]; ok {
+		e.args = append(e.args, "p0.ref("+p[0]+", "+p[1]+")")
+	} else if strings.HasSuffix(ap.Name, ".v") {
+		e.args = append(e.args, strings.TrimSuffix(ap.Name, ".v"))
+	} else {
+		e.args = append(e.args, "p0.box(() => "+ap.Name+", (v) => { "+ap.Name+" = v; })")
+	}
+	return ap
+}
+
+// Generates procedure calls with the actual parameters generated last. A call
+// of a function is an expression and is returned as an item.
+func (e *Emitter) GenCall(entry *st.SymTableEntry) *st.SymTableEntry {
+	n := len(e.args) - len(entry.Par)
+	call := Mangle(entry.Name) + "(" + strings.Join(e.args[n:], ", ") + ")"
+	e.args = e.args[:n]
+	if entry.Result != nil {
+		y := st.Var(entry.Result.Tp)
+		y.Name = call
+		return y
+	}
+	e.emit(call + ";")
+	return entry
+}
+
+// Generates call to the runtime read().
+func (e *Emitter) GenRead(x *st.SymTableEntry) {
+	e.emit(x.Name + " = p0.read();")
+}
+
+// Generates call to the runtime write().
+func (e *Emitter) GenWrite(x *st.SymTableEntry) {
+	e.emit("p0.write(" + bare(x.Name) + ");")
+}
+
+// Generates call to the runtime writeln().
+func (e *Emitter) GenWriteln() {
+	e.emit("p0.writeln();")
+}
+
+// Dummy function for generating sequences.
+func (e *Emitter) GenSeq(x *st.SymTableEntry, y *st.SymTableEntry) {
+	//pass
+}
+
+// Generates then, the if of WAT.
+func (e *Emitter) GenThen(x *st.SymTableEntry) *st.SymTableEntry {
+	e.emit("if (" + bare(x.Name) + ") {")
+	e.indent += 1
+	return x
+}
+
+// Generates if/then, the end of WAT.
+func (e *Emitter) GenIfThen(x *st.SymTableEntry, y *st.SymTableEntry) *st.SymTableEntry {
+	e.indent -= 1
+	e.emit("}")
+	return x
+}
+
+// Generates else.
+func (e *Emitter) GenElse(x *st.SymTableEntry, y *st.SymTableEntry) *st.SymTableEntry {
+	e.indent -= 1
+	e.emit("} else {")
+	e.indent += 1
+	return y
+}
+
+// Generates if/else
+func (e *Emitter) GenIfElse(x *st.SymTableEntry, y *st.SymTableEntry, z *st.SymTableEntry) *st.SymTableEntry {
+	e.indent -= 1
+	e.emit("}")
+	return x
+}
+
+// Generates while, the loop of WAT.
+func (e *Emitter) GenWhile() {
+	e.emit("while (true) {")
+	e.indent += 1
+}
+
+// Generates do: the loop is left when the condition is false.
+func (e *Emitter) GenDo(x *st.SymTableEntry) *st.SymTableEntry {
+	e.emit("if (!" + x.Name + ") {")
+	e.emit("    break;")
+	e.emit("}")
+	return x
+}
+
+// Generates while/do, the br back to the start of the loop.
+func (e *Emitter) GenWhileDo(x *st.SymTableEntry, y *st.SymTableEntry) {
+	e.indent -= 1
+	e.emit("}")
+}
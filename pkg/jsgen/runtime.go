@@ -0,0 +1,103 @@
+package jsgen
+
+// Runtime of compiled programs, put after the program in every generated
+// file. Input and output go through hooks: hooks.read() returns the next
+// integer or null at the end of the input, and hooks.write(text) prints text.
+// Arithmetic wraps around and division traps like WASM. Run with Node.js, the
+// file reads standard input and writes standard output; elsewhere run(hooks)
+// can be called with hooks of one's own.
+const Runtime = `
+function p0Runtime(hooks) {
+    const trap = (msg) => {
+        throw new Error(msg);
+    };
+    const copy = (v) => {
+        if (Array.isArray(v)) {
+            return v.map(copy);
+        } else if (typeof v === "object") {
+            return Object.fromEntries(Object.entries(v).map(([k, x]) => [k, copy(x)]));
+        }
+        return v;
+    };
+    return {
+        read() {
+            const x = hooks.read();
+            return x === null || x === undefined ? 0 : x | 0;
+        },
+        write(x) {
+            hooks.write(" " + x);
+        },
+        writeln() {
+            hooks.write("\n");
+        },
+        div(x, y) {
+            if (y === 0) {
+                trap("integer divide by zero");
+            }
+            if (x === -2147483648 && y === -1) {
+                trap("integer overflow");
+            }
+            return (x / y) | 0;
+        },
+        mod(x, y) {
+            if (y === 0) {
+                trap("integer divide by zero");
+            }
+            return y === -1 ? 0 : (x % y) | 0;
+        },
+        range(x, n) {
+            if (x < 0 || x >= n) {
+                trap("value out of range");
+            }
+            return x;
+        },
+        array(n, init) {
+            return Array.from({ length: n }, init);
+        },
+        copy,
+        // A variable passed by var: o[k] of an array or record.
+        ref(o, k) {
+            return {
+                get v() { return o[k]; },
+                set v(x) { o[k] = x; },
+            };
+        },
+        // A variable passed by var: a scalar variable read and written by closures.
+        box(get, set) {
+            return {
+                get v() { return get(); },
+                set v(x) { set(x); },
+            };
+        },
+    };
+}
+
+// Hooks for Node.js: integers separated by white space from standard input,
+// text to standard output.
+function p0NodeHooks() {
+    let words = null;
+    return {
+        read() {
+            if (words === null) {
+                words = require("fs").readFileSync(0, "utf8").split(/\s+/).filter((w) => w !== "");
+            }
+            return words.length > 0 ? parseInt(words.shift(), 10) : null;
+        },
+        write(text) {
+            process.stdout.write(text);
+        },
+    };
+}
+
+if (typeof module !== "undefined") {
+    module.exports = { run };
+    if (require.main === module) {
+        try {
+            run(p0NodeHooks());
+        } catch (e) {
+            process.stderr.write(e.message + "\n");
+            process.exitCode = 1;
+        }
+    }
+}
+`
@@ -42,6 +42,7 @@ const (
 	BEGIN     = 39
 	PROGRAM   = 40
 	EOF       = 41
+	RETURN    = 42
 )
 
 var Keywords = map[string]int{
@@ -64,4 +65,5 @@ var Keywords = map[string]int{
 	"var":       VAR,
 	"procedure": PROCEDURE,
 	"begin":     BEGIN,
-	"program":   PROGRAM}
\ No newline at end of file
+	"program":   PROGRAM,
+	"return":    RETURN}
\ No newline at end of file
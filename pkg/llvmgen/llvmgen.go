@@ -0,0 +1,549 @@
+// LLVM code generator: translates P0 programs into textual LLVM IR, which llc
+// or clang optimize and compile. Every variable is an alloca or a global and
+// every value is loaded when it is used, so that mem2reg can build SSA form.
+// Pointers are typed, as LLVM 14 expects.
+package llvmgen
+
+import (
+	"fmt"
+	"group-11/pkg/diag"
+	k "group-11/pkg/keywords"
+	"group-11/pkg/source"
+	st "group-11/pkg/symtable"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// Output of the LLVM code generator and the state it keeps while generating.
+// Items are symbol table entries: the Name of a variable is a pointer to it,
+// the Name of a value with Lev -1 is the register holding it.
+type Emitter struct {
+	Lines  []string                   // Generated global variables and functions, one line each.
+	Types  []string                   // Generated type definitions.
+	Curlev int                        // Current scope level.
+	At     source.Span                // Construct being generated, for error messages.
+	types  map[*st.ComplexType]string // LLVM type of every record and array type.
+	args   []string                   // Actual parameters of the calls being generated.
+	temps  int
+	block  string   // Label of the basic block being generated.
+	loops  []string // Labels of the conditions of the while loops being generated.
+	result bool     // Whether the procedure being generated is a function.
+	failed bool     // Whether an error was reported; nothing is generated after it.
+	errors diag.Handler
+}
+
+// Creates an Emitter that reports errors to errh.
+func NewEmitter(errh diag.Handler) *Emitter {
+	return &Emitter{Lines: []string{}, types: map[*st.ComplexType]string{}, errors: errh}
+}
+
+// Reports an error at the construct being generated. Only the first error is
+// reported, as what follows an unsupported construct, such as the variables of
+// a nested procedure, would be reported again.
+func (e *Emitter) mark(msg string) {
+	if !e.failed {
+		e.failed = true
+		e.errors(diag.NewError("E400", e.At, msg))
+	}
+}
+
+// Sets the construct being generated, for error messages.
+func (e *Emitter) SetAt(span source.Span) {
+	e.At = span
+}
+
+// Appends an instruction.
+func (e *Emitter) emit(format string, args ...interface{}) {
+	if e.failed {
+		return
+	}
+	e.Lines = append(e.Lines, "  "+fmt.Sprintf(format, args...))
+}
+
+// Returns a new register.
+func (e *Emitter) newTemp() string {
+	e.temps += 1
+	return "%t" + strconv.Itoa(e.temps)
+}
+
+// Returns a new label.
+func (e *Emitter) newLabel() string {
+	e.temps += 1
+	return "L" + strconv.Itoa(e.temps)
+}
+
+// Starts the basic block with a label.
+func (e *Emitter) label(name string) {
+	e.Lines = append(e.Lines, name+":")
+	e.block = name
+}
+
+// Returns the LLVM name of a P0 identifier. Every name gets a trailing
+// underscore, so that it cannot clash with the runtime, registers or the
+// names of anonymous types, and characters LLVM does not allow unquoted are
+// spelled out.
+func Mangle(name string) string {
+	b := strings.Builder{}
+	for _, r := range name {
+		if r < utf8.RuneSelf && (r == '_' || '0' <= r && r <= '9' || 'a' <= r && r <= 'z' || 'A' <= r && r <= 'Z') {
+			b.WriteRune(r)
+		} else if r <= 0xFFFF {
+			fmt.Fprintf(&b, "_u%04x", r)
+		} else {
+			fmt.Fprintf(&b, "_U%08x", r)
+		}
+	}
+	b.WriteString("_")
+	return b.String()
+}
+
+// Returns the LLVM type of a variable or type entry.
+func (e *Emitter) ltype(entry *st.SymTableEntry) string {
+	if entry.ArrOrRec == "array" || entry.ArrOrRec == "record" {
+		return e.types[entry.Ctp]
+	}
+	return "i32"
+}
+
+// Returns the value of an item, loading it if it is a variable.
+func (e *Emitter) load(x *st.SymTableEntry) string {
+	if x.EntryType == "const" {
+		return strconv.Itoa(x.Val)
+	} else if x.Lev == -1 {
+		return x.Name
+	}
+	t := e.newTemp()
+	e.emit("%s = load i32, i32* %s", t, x.Name)
+	return t
+}
+
+// Returns the i1 condition of a boolean item.
+func (e *Emitter) cond(x *st.SymTableEntry) string {
+	c := e.newTemp()
+	e.emit("%s = icmp ne i32 %s, 0", c, e.load(x))
+	return c
+}
+
+// Returns an item for the value in register t.
+func value(tp st.PrimitiveType, t string) *st.SymTableEntry {
+	y := st.Var(tp)
+	y.Lev = -1
+	y.Name = t
+	return y
+}
+
+// Returns an item of the same type as x for the variable pointer points to.
+func place(x *st.SymTableEntry, pointer string) *st.SymTableEntry {
+	y := st.Typed("var", x)
+	y.Name = pointer
+	return y
+}
+
+// Generates the start of programs.
+func (e *Emitter) GenProgStart() {
+	//pass
+}
+
+// Specifies the Size of bool typed entries.
+func (e *Emitter) GenBool(entry *st.SymTableEntry) *st.SymTableEntry {
+	entry.Size = 4
+	return entry
+}
+
+// Specifies the Size of int typed entries.
+func (e *Emitter) GenInt(entry *st.SymTableEntry) *st.SymTableEntry {
+	entry.Size = 4
+	return entry
+}
+
+// Generates a struct type for a record type the first time it is seen, named
+// after the P0 name of a declared type or T otherwise, with the number of the
+// struct appended as types in different scopes may have the same name. The
+// offsets of the fields are the ones LLVM gives them, as all fields are
+// aligned to 4 bytes.
+func (e *Emitter) GenRec(entry *st.SymTableEntry) *st.SymTableEntry {
+	if _, ok := e.types[entry.Ctp]; !ok {
+		name := "%T" + strconv.Itoa(len(e.Types)+1)
+		if entry.EntryType == "type" {
+			name = "%" + Mangle(entry.Name) + strconv.Itoa(len(e.Types)+1)
+		}
+		fields := []string{}
+		s := 0
+		for _, f := range entry.Ctp.Fields {
+			f.Offset = s
+			s = s + f.Size
+			fields = append(fields, e.ltype(f))
+		}
+		entry.Size = s
+		e.Types = append(e.Types, name+" = type { "+strings.Join(fields, ", ")+" }")
+		e.types[entry.Ctp] = name
+	}
+	return entry
+}
+
+// Generates an array type.
+func (e *Emitter) GenArray(entry *st.SymTableEntry) *st.SymTableEntry {
+	entry.Size = entry.Ctp.Length * entry.Ctp.Size
+	if _, ok := e.types[entry.Ctp]; !ok {
+		e.types[entry.Ctp] = "[" + strconv.Itoa(entry.Ctp.Length) + " x " + e.ltype(entry.Ctp.Elem) + "]"
+	}
+	return entry
+}
+
+// Generates the global variables, initialized to zero.
+func (e *Emitter) GenGlobalVars(scope []*st.SymTableEntry, start int) {
+	for _, x := range scope[start:] {
+		if x.EntryType == "var" {
+			init := "0"
+			if x.ArrOrRec == "array" || x.ArrOrRec == "record" {
+				init = "zeroinitializer"
+			}
+			e.Lines = append(e.Lines, "@"+Mangle(x.Name)+" = internal global "+e.ltype(x)+" "+init)
+		}
+	}
+}
+
+// Generates the local variables of a procedure, initialized to zero like
+// WASM locals.
+func (e *Emitter) GenLocalVars(scope []*st.SymTableEntry, start int) {
+	for _, x := range scope[start:] {
+		if x.EntryType == "var" {
+			t := e.ltype(x)
+			e.emit("%%%s = alloca %s", Mangle(x.Name), t)
+			if t == "i32" {
+				e.emit("store i32 0, i32* %%%s", Mangle(x.Name))
+			} else {
+				e.emit("store %s zeroinitializer, %s* %%%s", t, t, Mangle(x.Name))
+			}
+		}
+	}
+}
+
+// Generates a variable: a pointer to a global, an alloca or the pointer a var
+// parameter holds.
+func (e *Emitter) GenVar(entry *st.SymTableEntry) *st.SymTableEntry {
+	if 0 < entry.Lev && entry.Lev < e.Curlev {
+		e.mark("LLVM: Level")
+	}
+	if entry.Lev == 0 {
+		return place(entry, "@"+Mangle(entry.Name))
+	}
+	return place(entry, "%"+Mangle(entry.Name))
+}
+
+// Constants are simply constants so they do not need any extra work.
+func (e *Emitter) GenConst(entry *st.SymTableEntry) *st.SymTableEntry {
+	return entry
+}
+
+// Generates operations with unary operators. For and and or, the first
+// operand decides whether the second one is evaluated: the block of the
+// second one is L<Val>, the block after both L<Val+1>, and Name is the block
+// the first one ended in.
+func (e *Emitter) GenUnaryOp(op int, x *st.SymTableEntry) *st.SymTableEntry {
+	if op == k.MINUS {
+		t := e.newTemp()
+		e.emit("%s = sub i32 0, %s", t, e.load(x))
+		return value(st.Int, t)
+	} else if op == k.NOT {
+		t := e.newTemp()
+		e.emit("%s = xor i32 %s, 1", t, e.load(x))
+		return value(st.Bool, t)
+	} else if op == k.AND || op == k.OR {
+		c := e.cond(x)
+		y := st.Var(st.Bool)
+		y.Val = e.temps + 1
+		second, end := e.newLabel(), e.newLabel()
+		y.Name = e.block
+		if op == k.AND {
+			e.emit("br i1 %s, label %%%s, label %%%s", c, second, end)
+		} else {
+			e.emit("br i1 %s, label %%%s, label %%%s", c, end, second)
+		}
+		e.label(second)
+		return y
+	}
+	e.mark("LLVM: unary operator?")
+	return x
+}
+
+// Instructions for the arithmetic operators and the conditions of the
+// relations. Division goes through the runtime, which traps like WASM.
+var arithmetic = map[int]string{k.PLUS: "add", k.MINUS: "sub", k.TIMES: "mul"}
+var conditions = map[int]string{k.EQ: "eq", k.NE: "ne", k.LT: "slt", k.GT: "sgt", k.LE: "sle", k.GE: "sge"}
+
+// Generates operations with binary operators.
+func (e *Emitter) GenBinaryOp(op int, x *st.SymTableEntry, y *st.SymTableEntry) *st.SymTableEntry {
+	if op == k.AND || op == k.OR {
+		v := e.load(y)
+		end := "L" + strconv.Itoa(x.Val+1)
+		e.emit("br label %%%s", end)
+		from := e.block
+		e.label(end)
+		t, first := e.newTemp(), 0
+		if op == k.OR {
+			first = 1
+		}
+		e.emit("%s = phi i32 [ %d, %%%s ], [ %s, %%%s ]", t, first, x.Name, v, from)
+		return value(st.Bool, t)
+	}
+	a, b := e.load(x), e.load(y)
+	t := e.newTemp()
+	if ins, ok := arithmetic[op]; ok {
+		e.emit("%s = %s i32 %s, %s", t, ins, a, b)
+	} else if op == k.DIV {
+		e.emit("%s = call i32 @p0_div(i32 %s, i32 %s)", t, a, b)
+	} else if op == k.MOD {
+		e.emit("%s = call i32 @p0_mod(i32 %s, i32 %s)", t, a, b)
+	} else {
+		e.mark("LLVM: binary operator?")
+	}
+	return value(st.Int, t)
+}
+
+// Generates the check that succ and pred stay in an enumeration.
+func (e *Emitter) GenRange(x *st.SymTableEntry, n int) *st.SymTableEntry {
+	a, t := e.load(x), e.newTemp()
+	e.emit("%s = call i32 @p0_range(i32 %s, i32 %d)", t, a, n)
+	return value(st.Int, t)
+}
+
+// Generates relations between two entries, such as x > 5.
+func (e *Emitter) GenRelation(op int, x *st.SymTableEntry, y *st.SymTableEntry) *st.SymTableEntry {
+	a, b := e.load(x), e.load(y)
+	c, t := e.newTemp(), e.newTemp()
+	e.emit("%s = icmp %s i32 %s, %s", c, conditions[op], a, b)
+	e.emit("%s = zext i1 %s to i32", t, c)
+	return value(st.Bool, t)
+}
+
+// Generates selectors for records.
+func (e *Emitter) GenSelect(x *st.SymTableEntry, field *st.SymTableEntry) *st.SymTableEntry {
+	i := 0
+	for x.Ctp.Fields[i] != field {
+		i++
+	}
+	t, p := e.newTemp(), e.ltype(x)
+	e.emit("%s = getelementptr %s, %s* %s, i32 0, i32 %d", t, p, p, x.Name, i)
+	return place(field, t)
+}
+
+// Generates indexes for arrays. LLVM arrays start at 0.
+func (e *Emitter) GenIndex(x *st.SymTableEntry, y *st.SymTableEntry) *st.SymTableEntry {
+	index := ""
+	if y.EntryType == "const" {
+		index = strconv.Itoa(y.Val - x.Ctp.Lower)
+	} else {
+		index = e.load(y)
+		if x.Ctp.Lower != 0 {
+			t := e.newTemp()
+			e.emit("%s = sub i32 %s, %d", t, index, x.Ctp.Lower)
+			index = t
+		}
+	}
+	t, p := e.newTemp(), e.ltype(x)
+	e.emit("%s = getelementptr %s, %s* %s, i32 0, i32 %s", t, p, p, x.Name, index)
+	return place(x.Ctp.Elem, t)
+}
+
+// Generates assignment to variables.
+func (e *Emitter) GenAssign(x *st.SymTableEntry, y *st.SymTableEntry) {
+	e.emit("store i32 %s, i32* %s", e.load(y), x.Name)
+}
+
+// Generates the entry to the program.
+func (e *Emitter) GenProgEntry(ident string) {
+	e.Lines = append(e.Lines, "", "define i32 @main() {")
+	e.label("entry")
+}
+
+// Generates the exit of the program and returns the generated module.
+func (e *Emitter) GenProgExit(x *st.SymTableEntry) string {
+	e.emit("ret i32 0")
+	e.Lines = append(e.Lines, "}")
+	head := append([]string{"; Generated by the P0 compiler."}, e.Types...)
+	return strings.Join(head, "\n") + "\n\n" + strings.Join(e.Lines, "\n") + "\n" + Runtime
+}
+
+// Generates the LLVM type of a formal parameter: a pointer for var, record and
+// array parameters.
+func (e *Emitter) paramType(fp *st.SymTableEntry) string {
+	if fp.EntryType == "ref" || fp.ArrOrRec == "array" || fp.ArrOrRec == "record" {
+		return e.ltype(fp) + "*"
+	}
+	return "i32"
+}
+
+// Generates function definitions. Value parameters are stored in allocas,
+// records and arrays passed by value are copied into them. Functions return
+// an i32.
+func (e *Emitter) GenProcStart(ident string, listOfParams []*st.SymTableEntry, result *st.SymTableEntry) {
+	if e.Curlev > 0 {
+		e.mark("LLVM: no nested procedures")
+	}
+	e.Curlev += 1
+	e.result = result != nil
+	params := []string{}
+	for _, fp := range listOfParams {
+		name := "%" + Mangle(fp.Name)
+		if fp.EntryType == "var" {
+			name += "in"
+		}
+		params = append(params, e.paramType(fp)+" "+name)
+	}
+	ret := "void"
+	if e.result {
+		ret = "i32"
+	}
+	e.Lines = append(e.Lines, "", "define internal "+ret+" @"+Mangle(ident)+"("+strings.Join(params, ", ")+") {")
+	e.label("entry")
+	for _, fp := range listOfParams {
+		if fp.EntryType == "var" {
+			name, t := "%"+Mangle(fp.Name), e.ltype(fp)
+			e.emit("%s = alloca %s", name, t)
+			if t == "i32" {
+				e.emit("store i32 %sin, i32* %s", name, name)
+			} else {
+				v := e.newTemp()
+				e.emit("%s = load %s, %s* %sin", v, t, t, name)
+				e.emit("store %s %s, %s* %s", t, v, t, name)
+			}
+		}
+	}
+}
+
+// Dummy function for generating procedure entries.
+func (e *Emitter) GenProcEntry() {
+	//pass
+}
+
+// Generates the return of the value of a function.
+func (e *Emitter) GenReturn(x *st.SymTableEntry) {
+	e.emit("ret i32 %s", e.load(x))
+}
+
+// Generates procedure exits; functions returned in GenReturn.
+func (e *Emitter) GenProcExit(x *st.SymTableEntry) {
+	e.Curlev -= 1
+	if !e.result {
+		e.emit("ret void")
+	}
+	e.result = false
+	e.Lines = append(e.Lines, "}")
+}
+
+// Generates the actual parameters: a pointer for var, record and array
+// parameters, the value of any other.
+func (e *Emitter) GenActualPara(ap *st.SymTableEntry, fp *st.SymTableEntry) *st.SymTableEntry {
+	if t := e.paramType(fp); t != "i32" {
+		e.args = append(e.args, t+" "+ap.Name)
+	} else {
+		e.args = append(e.args, "i32 "+e.load(ap))
+	}
+	return ap
+}
+
+// Generates procedure calls with the actual parameters generated last. The
+// result of a function is returned in a register.
+func (e *Emitter) GenCall(entry *st.SymTableEntry) *st.SymTableEntry {
+	n := len(e.args) - len(entry.Par)
+	args := strings.Join(e.args[n:], ", ")
+	e.args = e.args[:n]
+	if entry.Result != nil {
+		t := e.newTemp()
+		e.emit("%s = call i32 @%s(%s)", t, Mangle(entry.Name), args)
+		return value(entry.Result.Tp, t)
+	}
+	e.emit("call void @%s(%s)", Mangle(entry.Name), args)
+	return entry
+}
+
+// Generates call to the runtime read().
+func (e *Emitter) GenRead(x *st.SymTableEntry) {
+	t := e.newTemp()
+	e.emit("%s = call i32 @p0_read()", t)
+	e.emit("store i32 %s, i32* %s", t, x.Name)
+}
+
+// Generates call to the runtime write().
+func (e *Emitter) GenWrite(x *st.SymTableEntry) {
+	e.emit("call void @p0_write(i32 %s)", e.load(x))
+}
+
+// Generates call to the runtime writeln().
+func (e *Emitter) GenWriteln() {
+	e.emit("call void @p0_writeln()")
+}
+
+// Dummy function for generating sequences.
+func (e *Emitter) GenSeq(x *st.SymTableEntry, y *st.SymTableEntry) {
+	//pass
+}
+
+// Generates then: the block of the then part is L<Val>, the block after it
+// L<Val+1>.
+func (e *Emitter) GenThen(x *st.SymTableEntry) *st.SymTableEntry {
+	c := e.cond(x)
+	y := st.Var(st.Bool)
+	y.Val = e.temps + 1
+	then, next := e.newLabel(), e.newLabel()
+	e.emit("br i1 %s, label %%%s, label %%%s", c, then, next)
+	e.label(then)
+	return y
+}
+
+// Generates if/then.
+func (e *Emitter) GenIfThen(x *st.SymTableEntry, y *st.SymTableEntry) *st.SymTableEntry {
+	next := "L" + strconv.Itoa(x.Val+1)
+	e.emit("br label %%%s", next)
+	e.label(next)
+	return x
+}
+
+// Generates else: the else part is the block after the then part, the block
+// after the statement is L<Val>.
+func (e *Emitter) GenElse(x *st.SymTableEntry, y *st.SymTableEntry) *st.SymTableEntry {
+	z := st.Var(st.None)
+	z.Val = e.temps + 1
+	end := e.newLabel()
+	e.emit("br label %%%s", end)
+	e.label("L" + strconv.Itoa(x.Val+1))
+	return z
+}
+
+// Generates if/else
+func (e *Emitter) GenIfElse(x *st.SymTableEntry, y *st.SymTableEntry, z *st.SymTableEntry) *st.SymTableEntry {
+	end := "L" + strconv.Itoa(y.Val)
+	e.emit("br label %%%s", end)
+	e.label(end)
+	return x
+}
+
+// Generates while: the condition gets a block of its own.
+func (e *Emitter) GenWhile() {
+	loop := e.newLabel()
+	e.loops = append(e.loops, loop)
+	e.emit("br label %%%s", loop)
+	e.label(loop)
+}
+
+// Generates do: the block of the body is L<Val>, the block after the loop
+// L<Val+1>.
+func (e *Emitter) GenDo(x *st.SymTableEntry) *st.SymTableEntry {
+	c := e.cond(x)
+	y := st.Var(st.Bool)
+	y.Val = e.temps + 1
+	body, exit := e.newLabel(), e.newLabel()
+	e.emit("br i1 %s, label %%%s, label %%%s", c, body, exit)
+	e.label(body)
+	return y
+}
+
+// Generates while/do.
+func (e *Emitter) GenWhileDo(x *st.SymTableEntry, y *st.SymTableEntry) {
+	loop := e.loops[len(e.loops)-1]
+	e.loops = e.loops[:len(e.loops)-1]
+	e.emit("br label %%%s", loop)
+	e.label("L" + strconv.Itoa(x.Val+1))
+}
@@ -0,0 +1,93 @@
+package llvmgen
+
+// Runtime of compiled programs, put at the end of every generated module. It
+// implements read, write and writeln like the P0lib imports of WASM programs
+// with the C library, and division that traps on zero the way WASM does.
+const Runtime = `
+@p0_fmt_read = private unnamed_addr constant [3 x i8] c"%d\00"
+@p0_fmt_write = private unnamed_addr constant [4 x i8] c" %d\00"
+@p0_fmt_trap = private unnamed_addr constant [4 x i8] c"%s\0A\00"
+@p0_msg_div = private unnamed_addr constant [23 x i8] c"integer divide by zero\00"
+@p0_msg_over = private unnamed_addr constant [17 x i8] c"integer overflow\00"
+@p0_msg_range = private unnamed_addr constant [19 x i8] c"value out of range\00"
+@stderr = external global i8*
+
+declare i32 @scanf(i8*, ...)
+declare i32 @printf(i8*, ...)
+declare i32 @fprintf(i8*, i8*, ...)
+declare i32 @putchar(i32)
+declare i32 @fflush(i8*)
+declare void @exit(i32)
+
+define internal i32 @p0_read() {
+  %x = alloca i32
+  %n = call i32 (i8*, ...) @scanf(i8* getelementptr ([3 x i8], [3 x i8]* @p0_fmt_read, i32 0, i32 0), i32* %x)
+  %ok = icmp eq i32 %n, 1
+  %v = load i32, i32* %x
+  %r = select i1 %ok, i32 %v, i32 0
+  ret i32 %r
+}
+
+define internal void @p0_write(i32 %x) {
+  %n = call i32 (i8*, ...) @printf(i8* getelementptr ([4 x i8], [4 x i8]* @p0_fmt_write, i32 0, i32 0), i32 %x)
+  ret void
+}
+
+define internal void @p0_writeln() {
+  %n = call i32 @putchar(i32 10)
+  ret void
+}
+
+define internal void @p0_trap(i8* %msg) {
+  %f = call i32 @fflush(i8* null)
+  %err = load i8*, i8** @stderr
+  %n = call i32 (i8*, i8*, ...) @fprintf(i8* %err, i8* getelementptr ([4 x i8], [4 x i8]* @p0_fmt_trap, i32 0, i32 0), i8* %msg)
+  call void @exit(i32 1)
+  unreachable
+}
+
+define internal i32 @p0_div(i32 %x, i32 %y) {
+  %zero = icmp eq i32 %y, 0
+  br i1 %zero, label %divzero, label %nonzero
+divzero:
+  call void @p0_trap(i8* getelementptr ([23 x i8], [23 x i8]* @p0_msg_div, i32 0, i32 0))
+  unreachable
+nonzero:
+  %min = icmp eq i32 %x, -2147483648
+  %minus = icmp eq i32 %y, -1
+  %over = and i1 %min, %minus
+  br i1 %over, label %overflow, label %ok
+overflow:
+  call void @p0_trap(i8* getelementptr ([17 x i8], [17 x i8]* @p0_msg_over, i32 0, i32 0))
+  unreachable
+ok:
+  %q = sdiv i32 %x, %y
+  ret i32 %q
+}
+
+define internal i32 @p0_range(i32 %x, i32 %n) {
+  %out = icmp uge i32 %x, %n
+  br i1 %out, label %outside, label %ok
+outside:
+  call void @p0_trap(i8* getelementptr ([19 x i8], [19 x i8]* @p0_msg_range, i32 0, i32 0))
+  unreachable
+ok:
+  ret i32 %x
+}
+
+define internal i32 @p0_mod(i32 %x, i32 %y) {
+  %zero = icmp eq i32 %y, 0
+  br i1 %zero, label %divzero, label %nonzero
+divzero:
+  call void @p0_trap(i8* getelementptr ([23 x i8], [23 x i8]* @p0_msg_div, i32 0, i32 0))
+  unreachable
+nonzero:
+  %minus = icmp eq i32 %y, -1
+  br i1 %minus, label %byminus, label %ok
+byminus:
+  ret i32 0
+ok:
+  %r = srem i32 %x, %y
+  ret i32 %r
+}
+`
@@ -1,27 +1,115 @@
 package parser
 
 import (
-	i "group-11/pkg/inputdata"
-	cg "group-11/pkg/codegen"
-	s "group-11/pkg/scanner"
+	"group-11/pkg/ast"
+	"group-11/pkg/diag"
 	k "group-11/pkg/keywords"
+	s "group-11/pkg/scanner"
+	"group-11/pkg/source"
 	st "group-11/pkg/symtable"
-	"strconv"
 )
 
-var FIRSTFACTOR = map[int]int{k.IDENT:1, k.NUMBER:1, k.LPAREN:1, k.NOT:1}
-var FOLLOWFACTOR = map[int]int{k.TIMES:1, k.DIV:1, k.MOD:1, k.AND:1, k.OR:1, k.PLUS:1, k.MINUS:1, 
-					k.EQ:1, k.NE:1, k.LT:1, k.LE:1, k.GT:1, k.GE:1, k.COMMA:1, k.SEMICOLON:1, k.THEN:1, 
-					k.ELSE:1, k.RPAREN:1, k.RBRAK:1, k.DO:1, k.PERIOD:1, k.END:1}
-var FIRSTEXPRESSION = map[int]int{k.PLUS:1, k.MINUS:1, k.IDENT:1, k.NUMBER:1, k.LPAREN:1, k.NOT:1}
-var FIRSTSTATEMENT = map[int]int{k.IDENT:1, k.IF:1, k.WHILE:1, k.BEGIN:1}
-var FOLLOWSTATEMENT = map[int]int{k.SEMICOLON:1, k.END:1, k.ELSE:1}
-var FIRSTTYPE = map[int]int{k.IDENT:1, k.RECORD:1, k.ARRAY:1, k.LPAREN:1}
-var FOLLOWTYPE = map[int]int{k.SEMICOLON:1}
-var FIRSTDECL = map[int]int{k.CONST:1, k.TYPE:1, k.VAR:1, k.PROCEDURE:1}
-var FOLLOWDECL = map[int]int{k.BEGIN:1}
-var FOLLOWPROCCALL = map[int]int{k.SEMICOLON:1, k.END:1, k.ELSE:1}
-var STRONGSYMS = map[int]int{k.CONST:1, k.TYPE:1, k.VAR:1, k.PROCEDURE:1, k.WHILE:1, k.IF:1, k.BEGIN:1, k.EOF:1}
+var FIRSTFACTOR = map[int]int{k.IDENT: 1, k.NUMBER: 1, k.LPAREN: 1, k.NOT: 1}
+var FOLLOWFACTOR = map[int]int{k.TIMES: 1, k.DIV: 1, k.MOD: 1, k.AND: 1, k.OR: 1, k.PLUS: 1, k.MINUS: 1,
+	k.EQ: 1, k.NE: 1, k.LT: 1, k.LE: 1, k.GT: 1, k.GE: 1, k.COMMA: 1, k.SEMICOLON: 1, k.THEN: 1,
+	k.ELSE: 1, k.RPAREN: 1, k.RBRAK: 1, k.DO: 1, k.PERIOD: 1, k.END: 1}
+var FIRSTEXPRESSION = map[int]int{k.PLUS: 1, k.MINUS: 1, k.IDENT: 1, k.NUMBER: 1, k.LPAREN: 1, k.NOT: 1}
+var FIRSTSTATEMENT = map[int]int{k.IDENT: 1, k.IF: 1, k.WHILE: 1, k.BEGIN: 1}
+var FOLLOWSTATEMENT = map[int]int{k.SEMICOLON: 1, k.END: 1, k.ELSE: 1, k.RETURN: 1}
+var FIRSTTYPE = map[int]int{k.IDENT: 1, k.RECORD: 1, k.ARRAY: 1, k.LPAREN: 1}
+var FOLLOWTYPE = map[int]int{k.SEMICOLON: 1}
+var FIRSTDECL = map[int]int{k.CONST: 1, k.TYPE: 1, k.VAR: 1, k.PROCEDURE: 1}
+var FOLLOWDECL = map[int]int{k.BEGIN: 1}
+var FOLLOWPROCCALL = map[int]int{k.SEMICOLON: 1, k.END: 1, k.ELSE: 1}
+var STRONGSYMS = map[int]int{k.CONST: 1, k.TYPE: 1, k.VAR: 1, k.PROCEDURE: 1, k.WHILE: 1, k.IF: 1, k.BEGIN: 1, k.EOF: 1}
+
+// Parser state: the lexer that supplies the tokens, the token being parsed and
+// the symbol table the declarations go into.
+type Parser struct {
+	lex      s.TokenReader
+	tok      s.Token    // Current token.
+	end      source.Pos // End of the token before the current one.
+	syms     *st.SymbolTable
+	errors   diag.Handler
+	intType  *st.SymTableEntry // The predeclared types, used as the types of expressions.
+	boolType *st.SymTableEntry
+	noType   *st.SymTableEntry // Type of expressions that could not be checked.
+	inner    int               // Scopes of records and formal parameters being parsed.
+}
+
+// Creates a parser that reads from the given tokens, declares into syms and
+// reports errors to errh, and reads the first token.
+func New(toks s.TokenReader, syms *st.SymbolTable, errh diag.Handler) *Parser {
+	p := &Parser{lex: toks, syms: syms, errors: errh, noType: st.Type(st.None)}
+	p.next()
+	return p
+}
+
+// Advances to the next token, remembering where the previous one ended.
+func (p *Parser) next() {
+	p.end = p.tok.End
+	p.tok = p.lex.Next()
+}
+
+// Reports an error at the current token.
+func (p *Parser) mark(code string, msg string) {
+	p.errors(diag.NewError(code, p.tok.Span(), msg))
+}
+
+// Reports an error about a construct already parsed, such as an expression of
+// the wrong type, at the span of its node.
+func (p *Parser) markAt(code string, x ast.Node, msg string) {
+	p.errors(diag.NewError(code, x.Span(), msg))
+}
+
+// Reports that the current token does not close the construct opened by the
+// token at open.
+func (p *Parser) markUnclosed(msg string, open source.Span, opener string) {
+	p.errors(diag.NewError("E200", p.tok.Span(), msg).WithRelated(open, "to match this "+opener))
+}
+
+// Adds an entry for an identifier to the innermost scope, reporting an error
+// if the name is already declared there.
+func (p *Parser) declare(id *ast.Ident, entry *st.SymTableEntry) {
+	if !p.syms.NewDecl(id.Name, entry) {
+		p.markAt("E301", id, "multiple definitions")
+	}
+}
+
+// Adds an entry to the innermost scope of declarations, outside of the records
+// and formal parameters being parsed, reporting an error if the name is
+// already declared there.
+func (p *Parser) declareOuter(id *ast.Ident, entry *st.SymTableEntry) {
+	if !p.syms.NewDeclOuter(p.inner, id.Name, entry) {
+		p.markAt("E301", id, "multiple definitions")
+	}
+}
+
+// Returns a Loc from start up to the end of the last token read.
+func (p *Parser) from(start source.Pos) ast.Loc {
+	return ast.At(source.Span{File: p.tok.File, Start: start, End: p.end})
+}
+
+// Makes an identifier node of the current token and advances past it.
+func (p *Parser) ident() *ast.Ident {
+	id := &ast.Ident{Loc: ast.At(p.tok.Span()), Name: p.tok.Lexeme}
+	p.next()
+	return id
+}
+
+// Makes an identifier node of the current token, looks up what it denotes and
+// advances past it.
+func (p *Parser) use() *ast.Ident {
+	obj := p.syms.FindInSymTab(p.tok.Lexeme)
+	if obj == nil {
+		p.mark("E300", "undefined identifier "+p.tok.Lexeme)
+		obj = &st.SymTableEntry{Tp: st.None}
+	}
+	id := p.ident()
+	id.Obj = obj
+	id.Type = obj
+	return id
+}
 
 // Helper function to check for that an element is in the first and follow sets.
 func exists(a int, dict map[int]int) bool {
@@ -32,730 +120,882 @@ func exists(a int, dict map[int]int) bool {
 	return false
 }
 
-// Generates selectors for records and arrays.
-func selector(x *st.SymTableEntry, inputData *i.InputData) *st.SymTableEntry {
-	for inputData.Sym == k.PERIOD || inputData.Sym == k.LBRAK {
-		if inputData.Sym == k.PERIOD {
-			s.GetSym(inputData)
-			if inputData.Sym == k.IDENT {
-				if x.ArrOrRec == "record" {
-					ctr := 0
-					for _, recfield := range x.Ctp.Fields {
-						if recfield.Name == inputData.Val {
-							x = cg.GenSelect(x, &recfield, inputData)
+// Reports whether any of the operands could not be checked, in which case an
+// error has been reported for it already.
+func unchecked(operands ...*ast.ExprInfo) bool {
+	for _, x := range operands {
+		if x.Type.Tp == st.None {
+			return true
+		}
+	}
+	return false
+}
+
+// Wraps a constant around to a 32 bit integer, as the target does.
+func wrap(v int) int {
+	return int(int32(v))
+}
+
+// Returns 1 for true and 0 for false.
+func boolVal(b bool) int {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+// Reports whether an expression denotes a variable, which can be assigned to
+// or passed by reference.
+func isVariable(x ast.Expr) bool {
+	switch x := x.(type) {
+	case *ast.Ident:
+		return x.Obj != nil && (x.Obj.EntryType == "var" || x.Obj.EntryType == "ref")
+	case *ast.SelectorExpr:
+		return isVariable(x.X)
+	case *ast.IndexExpr:
+		return isVariable(x.X)
+	}
+	return false
+}
+
+// Parses selectors for records and arrays.
+func (p *Parser) selector(x ast.Expr) ast.Expr {
+	for p.tok.Kind == k.PERIOD || p.tok.Kind == k.LBRAK {
+		if p.tok.Kind == k.PERIOD {
+			p.next()
+			if p.tok.Kind == k.IDENT {
+				field := p.ident()
+				sel := &ast.SelectorExpr{Loc: ast.Between(x, field), X: x, Field: field}
+				sel.Type = p.noType
+				if tp := x.Info().Type; tp.ArrOrRec == "record" {
+					for _, f := range tp.Ctp.Fields {
+						if f.Name == field.Name {
+							field.Obj = f
+							sel.Type = f
 							break
 						}
-						ctr += 1
 					}
-					if ctr == len(x.Ctp.Fields)-1 {
-						s.PrintError(inputData, "not a field")
+					if field.Obj == nil {
+						p.markAt("E303", field, "not a field")
 					}
-					s.GetSym(inputData)
-				} else {
-					s.PrintError(inputData, "not a record")
+				} else if !unchecked(x.Info()) {
+					p.markAt("E303", x, "not a record")
 				}
+				x = sel
 			} else {
-				s.PrintError(inputData,"identifier expected")
+				p.mark("E200", "identifier expected")
 			}
 		} else { // x[y]
-			s.GetSym(inputData)
-			y := expression(inputData)
-			if x.ArrOrRec == "array" {
-				if y .Tp == st.Int {
-					if y.EntryType == "const" {
-
-					} else {
-						x = cg.GenIndex(x, y, inputData)
-					}
-				}
+			p.next()
+			y := p.expression()
+			if p.tok.Kind == k.RBRAK {
+				p.next()
 			} else {
-				s.PrintError(inputData, "not an array")
+				p.mark("E200", "] expected")
 			}
-			if inputData.Sym == k.RBRAK {
-				s.GetSym(inputData)
-			} else {
-				s.PrintError(inputData, "] expected")
+			idx := &ast.IndexExpr{Loc: p.from(x.Span().Start), X: x, Index: y}
+			idx.Type = p.noType
+			if tp := x.Info().Type; tp.ArrOrRec == "array" {
+				if yi := y.Info(); tp.Ctp.Index == nil && yi.Type.Tp != st.Int {
+					if !unchecked(yi) {
+						p.markAt("E302", y, "index not integer")
+					}
+				} else if tp.Ctp.Index != nil && !st.SameType(yi.Type, tp.Ctp.Index) {
+					if !unchecked(yi) {
+						p.markAt("E302", y, "incompatible index")
+					}
+				} else if yi.Const && (yi.Val < tp.Ctp.Lower || yi.Val >= tp.Ctp.Lower+tp.Ctp.Length) {
+					p.markAt("E305", y, "index out of bounds")
+				} else {
+					idx.Type = tp.Ctp.Elem
+				}
+			} else if !unchecked(x.Info()) {
+				p.markAt("E303", x, "not an array")
 			}
+			x = idx
 		}
 	}
 	return x
 }
 
-// Generates factors.
-func factor(inputData *i.InputData) *st.SymTableEntry {
-	var x = &st.SymTableEntry{}
-	if !exists(inputData.Sym, FIRSTFACTOR) {
-		s.PrintError(inputData,"expression expected")
-		for !(exists(inputData.Sym, FIRSTFACTOR) || exists(inputData.Sym, FOLLOWFACTOR) || exists(inputData.Sym, STRONGSYMS)) {
-			s.GetSym(inputData)
-		}
-	}
-	if inputData.Sym == k.IDENT {
-		y := st.FindInSymTab(inputData, inputData.Val)
-		x = y
-		if x.EntryType == "var" || x.EntryType == "ref" {
-			x = cg.GenVar(x, inputData)
-			s.GetSym(inputData)
-		} else if x.EntryType == "const" {
-			x = st.Const(x .Tp, x.Val)
-			x = cg.GenConst(x)
-			s.GetSym(inputData)
-		} else {
-			s.PrintError(inputData,"expression expected")
-		}
-		x = selector(x, inputData)
-	} else if inputData.Sym == k.NUMBER {
-		constVal, err := strconv.Atoi(inputData.Val)
-		x = st.Const(st.Int, constVal)
-		x = cg.GenConst(x)
-		if err != nil {
-			s.PrintError(inputData, "error converting number")
-		}
-		s.GetSym(inputData)
-	} else if inputData.Sym == k.LPAREN {
-		s.GetSym(inputData)
-		x = expression(inputData)
-		if inputData.Sym == k.RPAREN {
-			s.GetSym(inputData)
-		} else {
-			s.PrintError(inputData,") expected")
-		}
-	} else if inputData.Sym == k.NOT {
-		s.GetSym(inputData)
-		x = factor(inputData)
-		if x .Tp != st.Bool {
-			s.PrintError(inputData,"not boolean")
-		} else if x.EntryType == "const" {
-			x.Val = 1 - x.Val
+// Parses factors.
+func (p *Parser) factor() ast.Expr {
+	if !exists(p.tok.Kind, FIRSTFACTOR) {
+		p.mark("E200", "expression expected")
+		for !(exists(p.tok.Kind, FIRSTFACTOR) || exists(p.tok.Kind, FOLLOWFACTOR) || exists(p.tok.Kind, STRONGSYMS)) {
+			p.next()
+		}
+	}
+	if p.tok.Kind == k.IDENT {
+		start := p.tok.Start
+		id := p.use()
+		if id.Obj.EntryType == "stdfunc" {
+			return p.stdFunc(start, id)
+		} else if id.Obj.EntryType == "proc" && id.Obj.Result != nil {
+			x := &ast.CallExpr{Proc: id, Args: p.actualParams(start, id.Obj.Par)}
+			x.Type = id.Obj.Result
+			x.Loc = p.from(start)
+			return x
+		} else if id.Obj.EntryType == "const" {
+			// Constants have no elements or fields, and a period after one
+			// starts the .. of array bounds.
+			id.Const, id.Val = true, id.Obj.Val
+			return id
+		} else if id.Obj.EntryType != "var" && id.Obj.EntryType != "ref" {
+			p.errors(diag.NewError("E303", id.Span(), "expression expected").
+				WithNote(id.Name + " is not a variable, constant or function"))
+			id.Type = p.noType
+		}
+		return p.selector(id)
+	} else if p.tok.Kind == k.NUMBER {
+		x := &ast.Number{Loc: ast.At(p.tok.Span()), Lexeme: p.tok.Lexeme}
+		x.Type, x.Const, x.Val = p.intType, true, p.tok.Val
+		p.next()
+		return x
+	} else if p.tok.Kind == k.LPAREN {
+		open := p.tok.Span()
+		start := p.tok.Start
+		p.next()
+		y := p.expression()
+		if p.tok.Kind == k.RPAREN {
+			p.next()
 		} else {
-			x = cg.GenUnaryOp(k.NOT, x, inputData)
+			p.markUnclosed(") expected", open, "'('")
+		}
+		x := &ast.ParenExpr{Loc: p.from(start), X: y}
+		x.ExprInfo = *y.Info()
+		return x
+	} else if p.tok.Kind == k.NOT {
+		start := p.tok.Start
+		p.next()
+		y := p.factor()
+		x := &ast.UnaryExpr{Loc: p.from(start), Op: k.NOT, X: y}
+		x.Type = p.boolType
+		if yi := y.Info(); yi.Type.Tp != st.Bool {
+			if !unchecked(yi) {
+				p.markAt("E302", y, "not boolean")
+			}
+			x.Type = p.noType
+		} else if yi.Const {
+			x.Const, x.Val = true, 1-yi.Val
 		}
+		return x
+	}
+	x := &ast.BadExpr{Loc: ast.At(p.tok.Span())}
+	x.Type = p.noType
+	return x
+}
+
+// Parses a call of ord, succ or pred, whose only argument is an enumeration.
+// ord also takes a boolean and returns an integer; succ and pred return the
+// next and the previous value of the enumeration. Calls with constant
+// arguments are folded.
+func (p *Parser) stdFunc(start source.Pos, id *ast.Ident) ast.Expr {
+	if p.tok.Kind == k.LPAREN {
+		p.next()
+	} else {
+		p.mark("E200", "'(' expected")
+	}
+	x := &ast.CallExpr{Proc: id, Args: []ast.Expr{p.expression()}}
+	if p.tok.Kind == k.RPAREN {
+		p.next()
+	} else {
+		p.mark("E200", ") expected")
+	}
+	x.Loc = p.from(start)
+	x.Type = p.noType
+	y := x.Args[0].Info()
+	if y.Type.Tp != st.Enum && !(id.Name == "ord" && y.Type.Tp == st.Bool) {
+		if !unchecked(y) {
+			p.markAt("E302", x.Args[0], "bad type")
+		}
+		return x
+	}
+	x.Type, x.Const, x.Val = y.Type, y.Const, y.Val
+	if id.Name == "ord" {
+		x.Type = p.intType
+	} else if id.Name == "succ" {
+		x.Val += 1
 	} else {
-		x = st.Const(st.None, 0)
+		x.Val -= 1
+	}
+	if id.Name != "ord" && x.Const && (x.Val < 0 || x.Val >= y.Type.Ctp.Length) {
+		p.markAt("E305", x, "value out of range")
 	}
 	return x
 }
 
-// Generates terms.
-func term(inputData *i.InputData) *st.SymTableEntry {
-	x := factor(inputData)
-	for inputData.Sym == k.TIMES || inputData.Sym == k.DIV || inputData.Sym == k.MOD || inputData.Sym == k.AND {
-		op := inputData.Sym
-		s.GetSym(inputData)
-		if op == k.AND && x.EntryType != "const" {
-			x = cg.GenUnaryOp(k.AND, x, inputData)
-		}
-		y := factor(inputData)
-		if (x .Tp == st.Int && y .Tp == st.Int) && exists(op, map[int]int{k.TIMES:1, k.DIV:1, k.MOD:1}) {
-			if x.EntryType == "const" && y.EntryType == "const" {
+// Parses terms.
+func (p *Parser) term() ast.Expr {
+	x := p.factor()
+	for p.tok.Kind == k.TIMES || p.tok.Kind == k.DIV || p.tok.Kind == k.MOD || p.tok.Kind == k.AND {
+		op := p.tok.Kind
+		p.next()
+		y := p.factor()
+		b := &ast.BinaryExpr{Loc: ast.Between(x, y), Op: op, X: x, Y: y}
+		xi, yi := x.Info(), y.Info()
+		if (xi.Type.Tp == st.Int && yi.Type.Tp == st.Int) && exists(op, map[int]int{k.TIMES: 1, k.DIV: 1, k.MOD: 1}) {
+			b.Type = p.intType
+			if xi.Const && yi.Const {
 				if op == k.TIMES {
-					x.Val = x.Val * y.Val
+					b.Const, b.Val = true, wrap(xi.Val*yi.Val)
+				} else if yi.Val == 0 {
+					p.markAt("E305", y, "division by zero")
 				} else if op == k.DIV {
-					x.Val = x.Val / y.Val
+					b.Const, b.Val = true, wrap(xi.Val/yi.Val)
 				} else if op == k.MOD {
-					x.Val = x.Val % y.Val
+					b.Const, b.Val = true, xi.Val%yi.Val
 				}
-			} else {
-				x = cg.GenBinaryOp(op, x, y, inputData)
 			}
-		} else if (x .Tp == st.Bool && y .Tp == st.Bool) && op == k.AND {
-			if x.EntryType == "const" {
-				if x.Val != st.EmptyInt { // Since Go doesn't provide a good way to check for empty int, I used a massively negative number
-					x = y
-				}
-			} else {
-				x = cg.GenBinaryOp(k.AND, x, y, inputData)
+		} else if (xi.Type.Tp == st.Bool && yi.Type.Tp == st.Bool) && op == k.AND {
+			b.Type = p.boolType
+			if xi.Const && (xi.Val == 0 || yi.Const) {
+				b.Const, b.Val = true, xi.Val*yi.Val
 			}
 		} else {
-			s.PrintError(inputData, "bad type")
+			if !unchecked(xi, yi) {
+				p.markAt("E302", b, "bad type")
+			}
+			b.Type = p.noType
 		}
+		x = b
 	}
 	return x
 }
 
-// Generates simple expressions.
-func simpleExpression(inputData *i.InputData) *st.SymTableEntry {
-	x := &st.SymTableEntry{}
-	if inputData.Sym == k.PLUS {
-		s.GetSym(inputData)
-		x = term(inputData)
-	} else if inputData.Sym == k.MINUS {
-		s.GetSym(inputData)
-		x = term(inputData)
-		if x .Tp != st.Int {
-			s.PrintError(inputData,"bad type")
-		} else if x.EntryType == "const" {
-			x.Val = -1 * x.Val
-		} else {
-			x = cg.GenUnaryOp(k.MINUS, x, inputData)
+// Parses simple expressions.
+func (p *Parser) simpleExpression() ast.Expr {
+	var x ast.Expr
+	if p.tok.Kind == k.PLUS || p.tok.Kind == k.MINUS {
+		op, start := p.tok.Kind, p.tok.Start
+		p.next()
+		y := p.term()
+		u := &ast.UnaryExpr{Loc: p.from(start), Op: op, X: y}
+		u.ExprInfo = *y.Info()
+		if u.Type.Tp != st.Int {
+			if !unchecked(&u.ExprInfo) {
+				p.markAt("E302", u, "bad type")
+			}
+			u.Type = p.noType
+		} else if u.Const && op == k.MINUS {
+			u.Val = wrap(-u.Val)
 		}
+		x = u
 	} else {
-		x = term(inputData)
+		x = p.term()
 	}
-	for inputData.Sym == k.PLUS || inputData.Sym == k.MINUS || inputData.Sym == k.OR {
-		op := inputData.Sym
-		s.GetSym(inputData)
-		if op == k.OR && x.EntryType != "const" {
-			x = cg.GenUnaryOp(k.OR, x, inputData)
-		}
-		y := term(inputData)
-		if (x .Tp == st.Int && y .Tp == st.Int) && (op == k.PLUS || op == k.MINUS) {
-			if x.EntryType == "const" && y.EntryType == "const" {
+	for p.tok.Kind == k.PLUS || p.tok.Kind == k.MINUS || p.tok.Kind == k.OR {
+		op := p.tok.Kind
+		p.next()
+		y := p.term()
+		b := &ast.BinaryExpr{Loc: ast.Between(x, y), Op: op, X: x, Y: y}
+		xi, yi := x.Info(), y.Info()
+		if (xi.Type.Tp == st.Int && yi.Type.Tp == st.Int) && (op == k.PLUS || op == k.MINUS) {
+			b.Type = p.intType
+			if xi.Const && yi.Const {
 				if op == k.PLUS {
-					x.Val = x.Val + y.Val
-				} else if op == k.MINUS {
-					x.Val = x.Val - y.Val
-				}
-			} else {
-				x = cg.GenBinaryOp(op, x, y, inputData)
-			}
-		} else if x .Tp == st.Bool && y .Tp == st.Bool && op == k.OR {
-			if x.EntryType == "const" {
-				if x.Val != st.EmptyInt {
-					x = y
+					b.Const, b.Val = true, wrap(xi.Val+yi.Val)
 				} else {
-					x = cg.GenBinaryOp(k.OR, x, y, inputData)
+					b.Const, b.Val = true, wrap(xi.Val-yi.Val)
 				}
 			}
+		} else if xi.Type.Tp == st.Bool && yi.Type.Tp == st.Bool && op == k.OR {
+			b.Type = p.boolType
+			if xi.Const && (xi.Val == 1 || yi.Const) {
+				b.Const, b.Val = true, boolVal(xi.Val == 1 || yi.Val == 1)
+			}
 		} else {
-			s.PrintError(inputData, "Bad type")
+			if !unchecked(xi, yi) {
+				p.markAt("E302", b, "bad type")
+			}
+			b.Type = p.noType
 		}
+		x = b
 	}
 	return x
 }
 
-// Generates whole expressions.
-// Generates whole expressions.
-func expression(inputData *i.InputData) *st.SymTableEntry {
-	x := simpleExpression(inputData)
-	for inputData.Sym == k.EQ || inputData.Sym == k.NE || inputData.Sym == k.LT || inputData.Sym == k.LE || inputData.Sym == k.GT || inputData.Sym == k.GE {
-		op := inputData.Sym
-		s.GetSym(inputData)
-		y := simpleExpression(inputData)
-
-		if x.Tp == y.Tp {
-			if x.EntryType == "const" && y.EntryType == "const" {
+// Parses whole expressions.
+func (p *Parser) expression() ast.Expr {
+	x := p.simpleExpression()
+	for p.tok.Kind == k.EQ || p.tok.Kind == k.NE || p.tok.Kind == k.LT || p.tok.Kind == k.LE || p.tok.Kind == k.GT || p.tok.Kind == k.GE {
+		op := p.tok.Kind
+		p.next()
+		y := p.simpleExpression()
+		b := &ast.BinaryExpr{Loc: ast.Between(x, y), Op: op, X: x, Y: y}
+		xi, yi := x.Info(), y.Info()
+		if st.SameType(xi.Type, yi.Type) && (xi.Type.Tp == st.Int || xi.Type.Tp == st.Bool || xi.Type.Tp == st.Enum) {
+			b.Type = p.boolType
+			if xi.Const && yi.Const {
+				b.Const = true
 				if op == k.EQ {
-					if x.Val == y.Val {
-						x.Val = 1
-					} else {
-						x.Val = 0
-					}
+					b.Val = boolVal(xi.Val == yi.Val)
 				} else if op == k.NE {
-					if x.Val != y.Val {
-						x.Val = 1
-					} else {
-						x.Val = 0
-					}
+					b.Val = boolVal(xi.Val != yi.Val)
 				} else if op == k.LT {
-					if x.Val < y.Val {
-						x.Val = 1
-					} else {
-						x.Val = 0
-					}
+					b.Val = boolVal(xi.Val < yi.Val)
 				} else if op == k.LE {
-					if x.Val <= y.Val {
-						x.Val = 1
-					} else {
-						x.Val = 0
-					}
+					b.Val = boolVal(xi.Val <= yi.Val)
 				} else if op == k.GT {
-					if x.Val > y.Val {
-						x.Val = 1
-					} else {
-						x.Val = 0
-					}
+					b.Val = boolVal(xi.Val > yi.Val)
 				} else if op == k.GE {
-					if x.Val >= y.Val {
-						x.Val = 1
-					} else {
-						x.Val = 0
-					}
+					b.Val = boolVal(xi.Val >= yi.Val)
 				}
-				x.Tp = st.Bool
-			} else {
-				x = cg.GenRelation(op, x, y, inputData)
 			}
 		} else {
-			s.PrintError(inputData, "bad type")
+			if !unchecked(xi, yi) {
+				p.markAt("E302", b, "bad type")
+			}
+			b.Type = p.noType
 		}
+		x = b
 	}
 
 	return x
 }
 
-// Generates compound statements.
-func compoundStatement(inputData *i.InputData) *st.SymTableEntry {
-	if inputData.Sym == k.BEGIN {
-		s.GetSym(inputData)
+// Parses compound statements.
+func (p *Parser) compoundStatement() *ast.CompoundStmt {
+	x, _ := p.block(false)
+	return x
+}
+
+// Parses the body of a procedure or, if function is set, of a function, which
+// ends with return and the expression whose value it returns. The statements
+// of a function body may be left out.
+func (p *Parser) block(function bool) (*ast.CompoundStmt, ast.Expr) {
+	start := p.tok.Start
+	open := p.tok.Span()
+	opened := p.tok.Kind == k.BEGIN
+	if opened {
+		p.next()
 	} else {
-		s.PrintError(inputData, "'begin' expected")
+		p.mark("E200", "'begin' expected")
 	}
-	x := statement(inputData)
-	for inputData.Sym == k.SEMICOLON || exists(inputData.Sym, FIRSTSTATEMENT) {
-		if inputData.Sym == k.SEMICOLON {
-			s.GetSym(inputData)
+	x := &ast.CompoundStmt{}
+	if !function || p.tok.Kind != k.RETURN {
+		x.Stmts = append(x.Stmts, p.statement())
+	}
+	for p.tok.Kind == k.SEMICOLON || exists(p.tok.Kind, FIRSTSTATEMENT) {
+		if p.tok.Kind == k.SEMICOLON {
+			p.next()
 		} else {
-			s.PrintError(inputData, "; missing")
+			p.mark("E200", "; missing")
+		}
+		if function && p.tok.Kind == k.RETURN {
+			break
 		}
-		y := statement(inputData)
-		cg.GenSeq(x, y, inputData)
+		x.Stmts = append(x.Stmts, p.statement())
+	}
+	var result ast.Expr
+	if p.tok.Kind == k.RETURN {
+		if !function {
+			p.mark("E200", "return outside of a function")
+		}
+		p.next()
+		result = p.expression()
+	} else if function {
+		p.mark("E200", "'return' expected")
 	}
-	if inputData.Sym == k.END {
-		s.GetSym(inputData)
+	if p.tok.Kind == k.END {
+		p.next()
+	} else if opened {
+		p.markUnclosed("'end' expected", open, "'begin'")
 	} else {
-		s.PrintError(inputData, "'end' expected")
+		p.mark("E200", "'end' expected")
 	}
-	return x
+	x.Loc = p.from(start)
+	return x, result
 }
 
-// Generates statements.
-func statement(inputData *i.InputData) *st.SymTableEntry {
-	x := &st.SymTableEntry{}
-	y := &st.SymTableEntry{}
-	if !exists(inputData.Sym, FIRSTSTATEMENT) {
-		s.PrintError(inputData, "statement expected")
-		s.GetSym(inputData)
-		for !(exists(inputData.Sym, FIRSTFACTOR) || exists(inputData.Sym, FOLLOWSTATEMENT) || exists(inputData.Sym, STRONGSYMS)){
-			s.GetSym(inputData)
-		}
-	}
-	if inputData.Sym == k.IDENT {
-		x = st.FindInSymTab(inputData, inputData.Val)
-		s.GetSym(inputData)
-		if x.EntryType == "var" || x.EntryType == "ref" {
-			x = cg.GenVar(x, inputData)
-			x = selector(x, inputData)
-			if inputData.Sym == k.BECOMES {
-				s.GetSym(inputData)
-				y = expression(inputData)
-				if x .Tp == st.Bool || x .Tp == st.Int || y .Tp == st.Bool || y .Tp == st.Int {
-					cg.GenAssign(x, y, inputData)
-				} else {
-					s.PrintError(inputData, "incompatible assignment")
-				}
-			} else if inputData.Sym == k.EQ {
-				s.PrintError(inputData,":= expected")
-				s.GetSym(inputData)
-				y = expression(inputData)
-				cg.GenSeq(y, y, inputData) // THIS IS ONLY CALk.LED BECAUSE GO k.DOESN'T LIKE UNUSED DECLARATIONS
-			} else {
-				s.PrintError(inputData, ":= expected")
-			}
-		} else if x.EntryType == "proc" || x.EntryType == "stdproc" {
-			fp := x.Par
-			var ap []*st.SymTableEntry
-			i := 0
-			if inputData.Sym == k.LPAREN {
-				s.GetSym(inputData)
-				if exists(inputData.Sym, FIRSTEXPRESSION) {
-					y = expression(inputData)
-					if i < len(fp) {
-						if (y.EntryType == "var" || fp[i].EntryType == "var") && (fp[i].EntryType == y.EntryType) {
-							if x.EntryType == "proc" {
-								ap = append(ap, cg.GenActualPara(y, &fp[i], inputData))
-							}
-						} else if x.Name != "read" {
-							s.PrintError(inputData, "illegal parameter mode")
-						}
-					} else {
-						s.PrintError(inputData, "extra parameter")
-					}
-					i++
-					for inputData.Sym == k.COMMA {
-						s.GetSym(inputData)
-						y = expression(inputData)
-						if i < len(fp) {
-							if (y.EntryType == "var" || fp[i].EntryType == "var") && (fp[i].EntryType == y.EntryType) {
-								if x.EntryType == "proc" {
-									ap = append(ap, cg.GenActualPara(y, &fp[i], inputData))
-								}
-							} else {
-								s.PrintError(inputData, "illegal parameter mode")
-							}
-						} else {
-							s.PrintError(inputData, "extra parameter")
-						}
-						i++
-					}
-				}
-				if inputData.Sym == k.RPAREN {
-					s.GetSym(inputData)
+// Checks an actual parameter against its formal parameter.
+func (p *Parser) actualParam(y ast.Expr, fp *st.SymTableEntry) {
+	if fp.EntryType == "ref" && !isVariable(y) {
+		p.markAt("E304", y, "illegal parameter mode")
+	} else if !st.SameType(fp, y.Info().Type) && !unchecked(y.Info()) {
+		p.markAt("E302", y, "incompatible parameter")
+	}
+}
+
+// Parses the actual parameters of a call starting at start, if there are any,
+// and checks them against the formal parameters fp.
+func (p *Parser) actualParams(start source.Pos, fp []*st.SymTableEntry) []ast.Expr {
+	var args []ast.Expr
+	if p.tok.Kind == k.LPAREN {
+		p.next()
+		if exists(p.tok.Kind, FIRSTEXPRESSION) {
+			for {
+				y := p.expression()
+				if len(args) < len(fp) {
+					p.actualParam(y, fp[len(args)])
 				} else {
-					s.PrintError(inputData, ") expected")
+					p.markAt("E304", y, "extra parameter")
 				}
-			}
-			if i < len(fp) {
-				s.PrintError(inputData, "too few parameters")
-			} else if x.EntryType == "stdproc" {
-				if x.Name == "read" {
-					cg.GenRead(y, inputData)
-				} else if x.Name == "write" {
-					cg.GenWrite(y, inputData)
-				} else if x.Name == "writeln" {
-					cg.GenWriteln(inputData)
+				args = append(args, y)
+				if p.tok.Kind != k.COMMA {
+					break
 				}
-			} else {
-				x = cg.GenCall(x, inputData)
+				p.next()
 			}
-		} else {
-			s.PrintError(inputData, "variable or procedure expected")
-		}
-	} else if inputData.Sym == k.BEGIN {
-		x = compoundStatement(inputData)
-	} else if inputData.Sym == k.IF {
-		s.GetSym(inputData)
-		x := expression(inputData)
-		if x .Tp == st.Bool {
-			x = cg.GenThen(x, inputData)
-		} else {
-			s.PrintError(inputData, "boolean expected")
 		}
-		if inputData.Sym == k.THEN {
-			s.GetSym(inputData)
+		if p.tok.Kind == k.RPAREN {
+			p.next()
 		} else {
-			s.PrintError(inputData,"'then' expected")
+			p.mark("E200", ") expected")
+		}
+	}
+	if len(args) < len(fp) {
+		call := p.from(start)
+		p.markAt("E304", &call, "too few parameters")
+	}
+	return args
+}
+
+// Parses statements.
+func (p *Parser) statement() ast.Stmt {
+	if !exists(p.tok.Kind, FIRSTSTATEMENT) {
+		p.mark("E200", "statement expected")
+		p.next()
+		for !(exists(p.tok.Kind, FIRSTFACTOR) || exists(p.tok.Kind, FOLLOWSTATEMENT) || exists(p.tok.Kind, STRONGSYMS)) {
+			p.next()
 		}
-		y = statement(inputData)
-		if inputData.Sym == k.ELSE {
-			if x .Tp == st.Bool {
-				y = cg.GenElse(x, y, inputData)
+	}
+	start := p.tok.Start
+	if p.tok.Kind == k.IDENT {
+		id := p.use()
+		x := id.Obj
+		// An undefined identifier, already reported, is taken for a variable.
+		if x.EntryType == "var" || x.EntryType == "ref" || x.EntryType == "" {
+			lhs := p.selector(id)
+			if p.tok.Kind == k.BECOMES || p.tok.Kind == k.EQ {
+				if p.tok.Kind == k.EQ {
+					p.mark("E200", ":= expected")
+				}
+				p.next()
+				y := p.expression()
+				xt, yt := lhs.Info().Type, y.Info().Type
+				x := &ast.AssignStmt{Loc: p.from(start), Lhs: lhs, Rhs: y}
+				if (!st.SameType(xt, yt) || (xt.Tp != st.Int && xt.Tp != st.Bool && xt.Tp != st.Enum)) && !unchecked(lhs.Info(), y.Info()) {
+					p.markAt("E302", x, "incompatible assignment")
+				}
+				return x
 			}
-			s.GetSym(inputData)
-			z:= statement(inputData)
-			if x .Tp == st.Bool {
-				x = cg.GenIfElse(x, y, z, inputData)
+			p.mark("E200", ":= expected")
+		} else if x.EntryType == "proc" || x.EntryType == "stdproc" {
+			if x.Result != nil {
+				p.errors(diag.NewError("E303", id.Span(), "procedure expected").
+					WithNote(id.Name + " is a function; its result must be used"))
 			}
+			call := &ast.CallStmt{Proc: id, Args: p.actualParams(start, x.Par)}
+			call.Loc = p.from(start)
+			return call
 		} else {
-			if x .Tp == st.Bool {
-				x = cg.GenIfThen(x, y, inputData)
-			}
-		}
-	} else if inputData.Sym == k.WHILE {
-		s.GetSym(inputData)
-		cg.GenWhile(inputData)
-		x = expression(inputData)
-		if x .Tp == st.Bool {
-			x = cg.GenDo(x, inputData)
+			p.markAt("E303", id, "variable or procedure expected")
+		}
+	} else if p.tok.Kind == k.BEGIN {
+		return p.compoundStatement()
+	} else if p.tok.Kind == k.IF {
+		p.next()
+		x := &ast.IfStmt{Cond: p.expression()}
+		if x.Cond.Info().Type.Tp != st.Bool && !unchecked(x.Cond.Info()) {
+			p.markAt("E302", x.Cond, "boolean expected")
+		}
+		if p.tok.Kind == k.THEN {
+			p.next()
 		} else {
-			s.PrintError(inputData, "boolean expected")
-		}
-		if inputData.Sym == k.DO {
-			s.GetSym(inputData)
+			p.mark("E200", "'then' expected")
+		}
+		x.Then = p.statement()
+		if p.tok.Kind == k.ELSE {
+			p.next()
+			x.Else = p.statement()
+		}
+		x.Loc = p.from(start)
+		return x
+	} else if p.tok.Kind == k.WHILE {
+		p.next()
+		x := &ast.WhileStmt{Cond: p.expression()}
+		if x.Cond.Info().Type.Tp != st.Bool && !unchecked(x.Cond.Info()) {
+			p.markAt("E302", x.Cond, "boolean expected")
+		}
+		if p.tok.Kind == k.DO {
+			p.next()
 		} else {
-			s.PrintError(inputData, "'do' expected")
+			p.mark("E200", "'do' expected")
 		}
-		y = statement(inputData)
-		if x .Tp == st.Bool {
-			cg.GenWhileDo(x, y, inputData)
-		}
-	} else {
-		x = nil
+		x.Body = p.statement()
+		x.Loc = p.from(start)
+		return x
 	}
-	return x
+	return &ast.BadStmt{Loc: p.from(start)}
 }
 
-// Generates the type of an identifier.
-func typ(inputData *i.InputData) *st.SymTableEntry {
-	x := &st.SymTableEntry{}
-	if !exists(inputData.Sym, FIRSTTYPE) {
-		s.PrintError(inputData, "type expected")
-		for !(exists(inputData.Sym, FIRSTFACTOR) || exists(inputData.Sym, FOLLOWFACTOR) || exists(inputData.Sym, STRONGSYMS)) {
-			s.GetSym(inputData)
+// Parses a type and returns it together with the entry describing it.
+func (p *Parser) typ() (ast.TypeExpr, *st.SymTableEntry) {
+	if !exists(p.tok.Kind, FIRSTTYPE) {
+		p.mark("E200", "type expected")
+		for !(exists(p.tok.Kind, FIRSTFACTOR) || exists(p.tok.Kind, FOLLOWFACTOR) || exists(p.tok.Kind, STRONGSYMS)) {
+			p.next()
 		}
 	}
-	if inputData.Sym == k.IDENT {
-		ident := inputData.Val
-		x = st.FindInSymTab(inputData, ident)
-		s.GetSym(inputData)
-		if x.EntryType == "type" {
-			x = st.Type(x .Tp)
-		} else {
-			s.PrintError(inputData, "not a type")
-			x = st.Type(st.None)
-		}
-	} else if inputData.Sym == k.ARRAY {
-		s.GetSym(inputData)
-		if inputData.Sym == k.LBRAK {
-			s.GetSym(inputData)
+	start := p.tok.Start
+	if p.tok.Kind == k.IDENT {
+		id := p.use()
+		if id.Obj.EntryType == "type" {
+			return &ast.NamedType{Loc: id.Loc, Name: id}, id.Obj
+		}
+		p.markAt("E303", id, "not a type")
+		return &ast.NamedType{Loc: id.Loc, Name: id}, p.noType
+	} else if p.tok.Kind == k.ARRAY {
+		p.next()
+		if p.tok.Kind == k.LBRAK {
+			p.next()
 		} else {
-			s.PrintError(inputData, "'[' expected")
+			p.mark("E200", "'[' expected")
 		}
-		x = expression(inputData)
-		if inputData.Sym == k.PERIOD {
-			s.GetSym(inputData)
+		arr := &ast.ArrayType{}
+		var index *st.SymTableEntry
+		if obj := p.syms.FindInSymTab(p.tok.Lexeme); p.tok.Kind == k.IDENT && obj != nil && obj.EntryType == "type" {
+			// An enumeration type gives the bounds.
+			arr.Index, index = p.typ()
 		} else {
-			s.PrintError(inputData,"'.' expected")
+			arr.Lower = p.expression()
+			if p.tok.Kind == k.PERIOD {
+				p.next()
+			} else {
+				p.mark("E200", "'.' expected")
+			}
+			if p.tok.Kind == k.PERIOD {
+				p.next()
+			} else {
+				p.mark("E200", "'.' expected")
+			}
+			arr.Upper = p.expression()
 		}
-		if inputData.Sym == k.PERIOD {
-			s.GetSym(inputData)
+		if p.tok.Kind == k.RBRAK {
+			p.next()
 		} else {
-			s.PrintError(inputData,"'.' expected")
+			p.mark("E200", "']' expected")
 		}
-		y := expression(inputData)
-		if inputData.Sym == k.RBRAK {
-			s.GetSym(inputData)
+		if p.tok.Kind == k.OF {
+			p.next()
 		} else {
-			s.PrintError(inputData,"']' expected")
+			p.mark("E200", "of expected")
+		}
+		var z *st.SymTableEntry
+		arr.Elem, z = p.typ()
+		arr.Loc = p.from(start)
+		if arr.Index != nil {
+			if index.Tp != st.Enum {
+				p.markAt("E302", arr.Index, "bad index type")
+				return arr, p.noType
+			}
+			a := st.Array(z, 0, index.Ctp.Length)
+			a.Ctp.Index = index
+			return arr, a
+		}
+		xi, yi := arr.Lower.Info(), arr.Upper.Info()
+		if !xi.Const || !(xi.Type.Tp == st.Int && xi.Val >= 0 || xi.Type.Tp == st.Enum) {
+			p.markAt("E305", arr.Lower, "bad lower bound")
+			return arr, p.noType
+		} else if !yi.Const || !st.SameType(xi.Type, yi.Type) || yi.Val < xi.Val {
+			p.markAt("E305", arr.Upper, "bad upper bound")
+			return arr, p.noType
+		}
+		a := st.Array(z, xi.Val, yi.Val-xi.Val+1)
+		if xi.Type.Tp == st.Enum {
+			a.Ctp.Index = xi.Type
+		}
+		return arr, a
+	} else if p.tok.Kind == k.RECORD {
+		open := p.tok.Span()
+		p.next()
+		p.syms.OpenScope()
+		p.inner += 1
+		rec := &ast.RecordType{}
+		rec.Fields = append(rec.Fields, p.typedIds("var"))
+		for {
+			if p.tok.Kind == k.SEMICOLON {
+				p.next()
+				rec.Fields = append(rec.Fields, p.typedIds("var"))
+			} else {
+				break
+			}
 		}
-		if inputData.Sym == k.OF {
-			s.GetSym(inputData)
-		} else {
-			s.PrintError(inputData,"of expected")
-		}
-		z := typ(inputData) .Tp
-		if x.EntryType != "const" || x.Val < 0 {
-			s.PrintError(inputData,"bad lower bound")
-			x = st.Type(st.None)
-		} else if y.EntryType != "const" || y.Val < 0 {
-			s.PrintError(inputData,"bad upper bound")
-			x = st.Type(st.None)
+		if p.tok.Kind == k.END {
+			p.next()
 		} else {
-			arr := st.Array(z, x.Val, y.Val - x.Val + 1)
-			x = cg.GenArray(arr)
-			x .Tp = z
-		}
-	} else if inputData.Sym == k.RECORD {
-		s.GetSym(inputData)
-		st.OpenScope(inputData)
-		typedIds("var", inputData)
+			p.markUnclosed("'end' expected", open, "'record'")
+		}
+		r := p.syms.TopScope()
+		p.syms.CloseScope()
+		p.inner -= 1
+		rec.Loc = p.from(start)
+		return rec, st.Record(r)
+	} else if p.tok.Kind == k.LPAREN {
+		open := p.tok.Span()
+		p.next()
+		enum := &ast.EnumType{}
 		for {
-			if inputData.Sym == k.SEMICOLON {
-				s.GetSym(inputData)
-				typedIds("var", inputData)
+			if p.tok.Kind == k.IDENT {
+				enum.Values = append(enum.Values, p.ident())
 			} else {
+				p.mark("E200", "identifier expected")
+			}
+			if p.tok.Kind != k.COMMA {
 				break
 			}
+			p.next()
 		}
-		if inputData.Sym == k.END {
-			s.GetSym(inputData)
+		if p.tok.Kind == k.RPAREN {
+			p.next()
 		} else {
-			s.PrintError(inputData,"'end' expected")
+			p.markUnclosed(") expected", open, "'('")
+		}
+		names := []string{}
+		for _, id := range enum.Values {
+			names = append(names, id.Name)
+		}
+		tp := st.Enumeration(names)
+		// The values are constants of the enclosing declarations, not fields
+		// or parameters.
+		for i, id := range enum.Values {
+			id.Obj = tp.Ctp.Consts[i]
+			p.declareOuter(id, id.Obj)
 		}
-		r := st.TopScope(inputData)
-		st.CloseScope(inputData)
-		rec := st.Record(r)
-		x = cg.GenRec(rec)
-		x .Tp = st.Nil
+		enum.Loc = p.from(start)
+		return enum, tp
 	}
 
-	return x
+	return &ast.BadType{Loc: p.from(start)}, p.noType
 }
 
-// Helps generate typed identifiers.
-func typedIds(entryType string, inputData *i.InputData) {
-	tid := []string{}
-	if inputData.Sym == k.IDENT {
-		tid = append([]string{inputData.Val}, tid...)
-		s.GetSym(inputData)
+// Parses identifiers of one type and declares them as var or ref entries.
+func (p *Parser) typedIds(entryType string) *ast.VarDecl {
+	start := p.tok.Start
+	x := &ast.VarDecl{}
+	if p.tok.Kind == k.IDENT {
+		x.Names = append(x.Names, p.ident())
 	} else {
-		s.PrintError(inputData,"identifier expected")
+		p.mark("E200", "identifier expected")
 	}
 
-	for inputData.Sym == k.COMMA {
-		s.GetSym(inputData)
-		if inputData.Sym == k.IDENT {
-			tid = append(tid, inputData.Val)
-			s.GetSym(inputData)
+	for p.tok.Kind == k.COMMA {
+		p.next()
+		if p.tok.Kind == k.IDENT {
+			x.Names = append(x.Names, p.ident())
 		} else {
-			s.PrintError(inputData, "identifier expected")
-		}
-	}
-
-	if inputData.Sym == k.COLON {
-		s.GetSym(inputData)
-		tp := typ(inputData)
-		if tp != (&st.SymTableEntry{}) {
-			for i := 0; i < len(tid); i++ {
-				if entryType == "var" {
-					if tp.ArrOrRec == "array" || tp.ArrOrRec == "record" {
-						tp.EntryType =  entryType
-						st.NewDecl(tid[i], tp, inputData)
-					} else {
-						v := st.Var(tp .Tp)
-						st.NewDecl(tid[i], v, inputData)
-					}
-				} else if entryType == "ref" {
-					if tp.ArrOrRec == "array" || tp.ArrOrRec == "record" {
-						tp.EntryType =  entryType
-						st.NewDecl(tid[i], tp, inputData)
-					} else {
-						r := st.Ref(tp .Tp)
-						st.NewDecl(tid[i], r, inputData)
-					}
-				}
-			}
+			p.mark("E200", "identifier expected")
+		}
+	}
+
+	if p.tok.Kind == k.COLON {
+		p.next()
+		var tp *st.SymTableEntry
+		x.Type, tp = p.typ()
+		for _, id := range x.Names {
+			id.Obj = st.Typed(entryType, tp)
+			p.declare(id, id.Obj)
 		}
 	} else {
-		s.PrintError(inputData,": expected")
+		p.mark("E200", ": expected")
+		x.Type = &ast.BadType{Loc: ast.At(p.tok.Span())}
+	}
+	x.Loc = p.from(start)
+	return x
+}
+
+// Parses the formal parameters of a procedure and declares them.
+func (p *Parser) formalParams() []*ast.Param {
+	var params []*ast.Param
+	for {
+		start := p.tok.Start
+		ref := p.tok.Kind == k.VAR
+		x := &ast.Param{Ref: ref}
+		if ref {
+			p.next()
+			d := p.typedIds("ref")
+			x.Names, x.Type = d.Names, d.Type
+		} else {
+			d := p.typedIds("var")
+			x.Names, x.Type = d.Names, d.Type
+		}
+		x.Loc = p.from(start)
+		params = append(params, x)
+		if p.tok.Kind != k.SEMICOLON {
+			return params
+		}
+		p.next()
 	}
 }
 
-// Generates various declarations.
-func declaration(allocVarLevel string, inputData *i.InputData) {
-	if !(exists(inputData.Sym, FIRSTDECL) || exists(inputData.Sym, FOLLOWDECL)) {
-		s.PrintError(inputData, "'begin' or declaration expected")
-		for !(exists(inputData.Sym, FIRSTDECL) || exists(inputData.Sym, FOLLOWDECL) || exists(inputData.Sym, STRONGSYMS)) {
-			s.GetSym(inputData)
+// Parses various declarations.
+func (p *Parser) declaration() []ast.Decl {
+	var decls []ast.Decl
+	if !(exists(p.tok.Kind, FIRSTDECL) || exists(p.tok.Kind, FOLLOWDECL)) {
+		p.mark("E200", "'begin' or declaration expected")
+		for !(exists(p.tok.Kind, FIRSTDECL) || exists(p.tok.Kind, FOLLOWDECL) || exists(p.tok.Kind, STRONGSYMS)) {
+			p.next()
 		}
 	}
-	for inputData.Sym == k.CONST {
-		s.GetSym(inputData)
-		if inputData.Sym == k.IDENT {
-			ident := inputData.Val
-			s.GetSym(inputData)
-			if inputData.Sym == k.EQ {
-				s.GetSym(inputData)
+	for p.tok.Kind == k.CONST {
+		p.next()
+		if p.tok.Kind == k.IDENT {
+			start := p.tok.Start
+			d := &ast.ConstDecl{Name: p.ident()}
+			if p.tok.Kind == k.EQ {
+				p.next()
 			} else {
-				s.PrintError(inputData,"= expected")
+				p.mark("E200", "= expected")
 			}
-			x := expression(inputData)
-			if x.EntryType == "const" {
-				c := st.Const(x .Tp, x.Val)
-				st.NewDecl(ident, c, inputData)
+			d.Value = p.expression()
+			if x := d.Value.Info(); x.Const {
+				d.Name.Obj = st.Const(x.Type.Tp, x.Val)
+				d.Name.Obj.Ctp = x.Type.Ctp
+				p.declare(d.Name, d.Name.Obj)
 			} else {
-				s.PrintError(inputData,"expression not constant")
+				p.markAt("E305", d.Value, "expression not constant")
 			}
+			d.Loc = p.from(start)
+			decls = append(decls, d)
 		} else {
-			s.PrintError(inputData,"constant name expected")
+			p.mark("E200", "constant name expected")
 		}
-		if inputData.Sym == k.SEMICOLON {
-			s.GetSym(inputData)
+		if p.tok.Kind == k.SEMICOLON {
+			p.next()
 		} else {
-			s.PrintError(inputData, "; expected")
+			p.mark("E200", "; expected")
 		}
 	}
-	for inputData.Sym == k.TYPE {
-		s.GetSym(inputData)
-		if inputData.Sym == k.IDENT {
-			ident := inputData.Val
-			s.GetSym(inputData)
-			if inputData.Sym == k.EQ {
-				s.GetSym(inputData)
+	for p.tok.Kind == k.TYPE {
+		p.next()
+		if p.tok.Kind == k.IDENT {
+			start := p.tok.Start
+			d := &ast.TypeDecl{Name: p.ident()}
+			if p.tok.Kind == k.EQ {
+				p.next()
 			} else {
-				s.PrintError(inputData,"= expected")
+				p.mark("E200", "= expected")
 			}
-			x := typ(inputData)
-			st.NewDecl(ident, x, inputData)
-			if inputData.Sym == k.SEMICOLON {
-				s.GetSym(inputData)
+			var tp *st.SymTableEntry
+			d.Type, tp = p.typ()
+			d.Name.Obj = st.Typed("type", tp)
+			p.declare(d.Name, d.Name.Obj)
+			d.Loc = p.from(start)
+			decls = append(decls, d)
+			if p.tok.Kind == k.SEMICOLON {
+				p.next()
 			} else {
-				s.PrintError(inputData, "; expected")
+				p.mark("E200", "; expected")
 			}
 		} else {
-			s.PrintError(inputData,"type name expected")
+			p.mark("E200", "type name expected")
 		}
 	}
-	start := len(st.TopScope(inputData))
-	for inputData.Sym == k.VAR {
-		s.GetSym(inputData)
-		typedIds("var", inputData)
-		if inputData.Sym == k.SEMICOLON {
-			s.GetSym(inputData)
+	for p.tok.Kind == k.VAR {
+		p.next()
+		decls = append(decls, p.typedIds("var"))
+		if p.tok.Kind == k.SEMICOLON {
+			p.next()
 		} else {
-			s.PrintError(inputData,"; expected")
+			p.mark("E200", "; expected")
 		}
 	}
-	if allocVarLevel == "global" {
-		cg.GenGlobalVars(st.TopScope(inputData), start, inputData)
-	} else {
-		cg.GenLocalVars(st.TopScope(inputData), start, inputData)
-	}
-	for inputData.Sym == k.PROCEDURE {
-		s.GetSym(inputData)
-		if inputData.Sym == k.IDENT {
-			s.GetSym(inputData)
+	for p.tok.Kind == k.PROCEDURE {
+		start := p.tok.Start
+		p.next()
+		d := &ast.ProcDecl{Name: &ast.Ident{Loc: ast.At(p.tok.Span())}}
+		if p.tok.Kind == k.IDENT {
+			d.Name = p.ident()
 		} else {
-			s.PrintError(inputData,"procedure named expected")
-		}
-		ident := inputData.Val
-		st.NewDecl(ident, st.Proc([]st.SymTableEntry{}), inputData)
-		sc := st.TopScope(inputData)
-		st.OpenScope(inputData)
-		if inputData.Sym == k.LPAREN {
-			s.GetSym(inputData)
-			if inputData.Sym == k.VAR || inputData.Sym == k.IDENT {
-				if inputData.Sym == k.VAR {
-					s.GetSym(inputData)
-					typedIds("ref", inputData)
-				} else {
-					typedIds("var", inputData)
-				}
-				for inputData.Sym == k.SEMICOLON {
-					s.GetSym(inputData)
-					if inputData.Sym == k.VAR {
-						s.GetSym(inputData)
-						typedIds("ref", inputData)
-					} else {
-						typedIds("var", inputData)
-					}
-				}
+			p.mark("E200", "procedure named expected")
+		}
+		d.Name.Obj = st.Proc([]*st.SymTableEntry{})
+		p.declare(d.Name, d.Name.Obj)
+		p.syms.OpenScope()
+		p.inner += 1
+		if p.tok.Kind == k.LPAREN {
+			p.next()
+			if p.tok.Kind == k.VAR || p.tok.Kind == k.IDENT {
+				d.Params = p.formalParams()
 			} else {
-				s.PrintError(inputData,"formal parameters expected")
+				p.mark("E200", "formal parameters expected")
 			}
-			fp := st.TopScope(inputData)
-			sc[len(sc) - 1].Par = fp
-			if inputData.Sym == k.RPAREN {
-				s.GetSym(inputData)
+			d.Name.Obj.Par = p.syms.TopScope()
+			if p.tok.Kind == k.RPAREN {
+				p.next()
 			} else {
-				s.PrintError(inputData,") expected")
+				p.mark("E200", ") expected")
 			}
-		} else {
-			fp := []st.SymTableEntry{}
-			cg.GenProcStart(ident, fp, inputData)
 		}
-		if inputData.Sym == k.SEMICOLON {
-			s.GetSym(inputData)
+		if p.tok.Kind == k.COLON {
+			p.next()
+			var tp *st.SymTableEntry
+			d.Result, tp = p.typ()
+			if tp.ArrOrRec != "" {
+				p.markAt("E302", d.Result, "bad result type")
+				tp = p.noType
+			}
+			d.Name.Obj.Result = tp
+		}
+		p.inner -= 1
+		if p.tok.Kind == k.SEMICOLON {
+			p.next()
 		} else {
-			s.PrintError(inputData,"; expected")
-		}
-		declaration("local", inputData)
-		cg.GenProcEntry(inputData)
-		x := compoundStatement(inputData)
-		cg.GenProcExit(x, inputData)
-		if inputData.Sym == k.SEMICOLON {
-			s.GetSym(inputData)
+			p.mark("E200", "; expected")
+		}
+		d.Decls = p.declaration()
+		d.Body, d.Return = p.block(d.Result != nil)
+		if r := d.Name.Obj.Result; d.Return != nil && r != nil && r.Tp != st.None && !st.SameType(d.Return.Info().Type, r) && !unchecked(d.Return.Info()) {
+			p.markAt("E302", d.Return, "incompatible result")
+		}
+		p.syms.CloseScope()
+		d.Loc = p.from(start)
+		decls = append(decls, d)
+		if p.tok.Kind == k.SEMICOLON {
+			p.next()
 		} else {
-			s.PrintError(inputData,"; expected")
+			p.mark("E200", "; expected")
 		}
 	}
+	return decls
 }
 
-// Parses the "program" part of the grammar.
-func Program(inputData *i.InputData) string {
-	st.NewDecl("boolean", cg.GenBool(st.Type(st.Bool)), inputData)
-	st.NewDecl("integer", cg.GenInt(st.Type(st.Int)), inputData)
-	st.NewDecl("true", st.Const(st.Bool, 1), inputData)
-	st.NewDecl("false", st.Const(st.Bool, 0), inputData)
-	st.NewDecl("read", st.StdProc([]st.SymTableEntry{*st.Ref(st.Int)}), inputData)
-	st.NewDecl("write", st.StdProc([]st.SymTableEntry{*st.Var(st.Int)}), inputData)
-	st.NewDecl("writeln", st.StdProc([]st.SymTableEntry{}), inputData)
-	cg.GenProgStart(inputData)
-	if inputData.Sym == k.PROGRAM {
-		s.GetSym(inputData)
+// Parses a program. The identifiers in the returned tree refer to their
+// symbol table entries and every expression records its type, so that code
+// can be generated from the tree alone.
+func (p *Parser) Program() *ast.Program {
+	p.boolType = st.Type(st.Bool)
+	p.intType = st.Type(st.Int)
+	p.syms.NewDecl("boolean", p.boolType)
+	p.syms.NewDecl("integer", p.intType)
+	p.syms.NewDecl("true", st.Const(st.Bool, 1))
+	p.syms.NewDecl("false", st.Const(st.Bool, 0))
+	p.syms.NewDecl("read", st.StdProc([]*st.SymTableEntry{st.Ref(st.Int)}))
+	p.syms.NewDecl("write", st.StdProc([]*st.SymTableEntry{st.Var(st.Int)}))
+	p.syms.NewDecl("writeln", st.StdProc([]*st.SymTableEntry{}))
+	p.syms.NewDecl("ord", st.StdFunc())
+	p.syms.NewDecl("succ", st.StdFunc())
+	p.syms.NewDecl("pred", st.StdFunc())
+	start := p.tok.Start
+	if p.tok.Kind == k.PROGRAM {
+		p.next()
 	} else {
-		s.PrintError(inputData, "'program' expected")
+		p.mark("E200", "'program' expected")
 	}
-	ident := inputData.Val
-	if inputData.Sym == k.IDENT {
-		s.GetSym(inputData)
+	prog := &ast.Program{Name: &ast.Ident{Loc: ast.At(p.tok.Span())}}
+	if p.tok.Kind == k.IDENT {
+		prog.Name = p.ident()
 	} else {
-		s.PrintError(inputData, "program name expected")
+		p.mark("E200", "program name expected")
 	}
-	if inputData.Sym == k.SEMICOLON {
-		s.GetSym(inputData)
+	if p.tok.Kind == k.SEMICOLON {
+		p.next()
 	} else {
-		s.PrintError(inputData, "; expected")
+		p.mark("E200", "; expected")
 	}
-	declaration("global", inputData)
-	cg.GenProgEntry(ident, inputData)
-	x := compoundStatement(inputData)
-	return cg.GenProgExit(x, inputData)
+	prog.Decls = p.declaration()
+	prog.Body = p.compoundStatement()
+	prog.Loc = p.from(start)
+	return prog
 }
-
-// Compiles the code into WASM.
-func CompileWasm(inputData *i.InputData) {
-	s.Init(inputData)
-	p := Program(inputData)
-	cg.WriteWasmFile("result.wasm", p)
-}
\ No newline at end of file
@@ -0,0 +1,101 @@
+package parser_test
+
+import (
+	"fmt"
+	"group-11/pkg/compiler"
+	s "group-11/pkg/scanner"
+	"group-11/pkg/source"
+	"reflect"
+	"testing"
+)
+
+// An operand that could not be checked, because it is undefined or does not
+// parse, causes no further type errors.
+func TestNoCascadingErrors(t *testing.T) {
+	tests := []struct {
+		stmt  string
+		codes []string
+	}{
+		{"write(𝔞𝔟 + ~)", []string{"E300", "E100"}},
+		{"write(y * 2 < 3)", []string{"E300"}},
+		{"write(-y)", []string{"E300"}},
+		{"if not y then x := y[1].f", []string{"E300", "E300"}},
+		{"y := 1", []string{"E300"}},
+		{"write(1 + true)", []string{"E302"}},
+	}
+	for _, test := range tests {
+		src := "program t;\n  var x: integer;\n  begin\n    " + test.stmt + "\n  end.\n"
+		c := compiler.New(source.NewFileSet().AddString("t.p0", src), s.Options{})
+		c.Analyze()
+		codes := []string{}
+		for _, d := range c.Diagnostics {
+			codes = append(codes, d.Code)
+		}
+		if !reflect.DeepEqual(codes, test.codes) {
+			t.Errorf("%s: diagnostics %v, want %v", test.stmt, codes, test.codes)
+		}
+	}
+}
+
+// The values of an enumeration declared in a record or a formal parameter are
+// constants of the enclosing declarations, not fields or parameters.
+func TestAnonymousEnumScope(t *testing.T) {
+	tests := []struct {
+		stmt  string
+		codes []string
+	}{
+		{"x.c := hi; p(b, 7)", []string{}},
+		{"x.lo := 1", []string{"E303"}},
+		{"p(b, 7, 1)", []string{"E304"}},
+	}
+	for _, test := range tests {
+		src := "program t;\n  var x: record c: (lo, hi); n: integer end;\n" +
+			"  procedure p(c: (a, b); n: integer);\n    begin write(n) end;\n" +
+			"  begin\n    " + test.stmt + "\n  end.\n"
+		c := compiler.New(source.NewFileSet().AddString("t.p0", src), s.Options{})
+		c.Analyze()
+		codes := []string{}
+		for _, d := range c.Diagnostics {
+			codes = append(codes, d.Code)
+		}
+		if !reflect.DeepEqual(codes, test.codes) {
+			t.Errorf("%s: diagnostics %v, want %v", test.stmt, codes, test.codes)
+		}
+	}
+}
+
+// Errors about a construct already parsed are reported at the construct, not
+// at the token after it.
+func TestErrorSpans(t *testing.T) {
+	tests := []struct {
+		stmt string
+		span string // Start and end column of the first diagnostic.
+	}{
+		{"x := true", "5-14"},
+		{"x := 1 + b", "10-15"},
+		{"if x then x := 1", "8-9"},
+		{"while b and 1 do x := 1", "11-18"},
+		{"x := a[b]", "12-13"},
+		{"x := a[4]", "12-13"},
+		{"x := 7 div 0", "16-17"},
+		{"p(b)", "7-8"},
+		{"p", "5-6"},
+		{"p(1, 2)", "10-11"},
+		{"x := succ(x)", "15-16"},
+		{"b := not x", "14-15"},
+	}
+	for _, test := range tests {
+		src := "program t;\n  var x: integer;\n  var b: boolean;\n  var a: array [1 .. 3] of integer;\n" +
+			"  procedure p(n: integer); begin write(n) end;\n  begin\n    " + test.stmt + "\n  end.\n"
+		c := compiler.New(source.NewFileSet().AddString("t.p0", src), s.Options{})
+		c.Analyze()
+		if len(c.Diagnostics) == 0 {
+			t.Errorf("%s: no diagnostics", test.stmt)
+			continue
+		}
+		d := c.Diagnostics[0]
+		if span := fmt.Sprintf("%d-%d", d.Span.Start.Col, d.Span.End.Col); d.Span.Start.Line != 7 || span != test.span {
+			t.Errorf("%s: %s at %d:%s, want 7:%s", test.stmt, d.Message, d.Span.Start.Line, span, test.span)
+		}
+	}
+}
@@ -0,0 +1,125 @@
+//go:build ignore
+
+// Generates nfc_tables.go from golang.org/x/text/unicode/norm. The tables must
+// be generated with the Unicode version used by the Go release that builds the
+// compiler, so that unicode.IsLetter and the tables agree.
+//
+//	go run gen_nfc.go > nfc_tables.go
+
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"golang.org/x/text/unicode/norm"
+)
+
+const (
+	hangulBase = 0xAC00
+	hangulEnd  = 0xD7A4
+)
+
+func main() {
+	if norm.Version != unicode.Version {
+		panic("norm " + norm.Version + " does not match unicode " + unicode.Version)
+	}
+	decomp := map[rune]string{}
+	ccc := map[rune]uint8{}
+	compose := map[[2]rune]rune{}
+	for r := rune(0); r <= unicode.MaxRune; r++ {
+		if !utf8.ValidRune(r) || hangulBase <= r && r < hangulEnd {
+			continue
+		}
+		s := string(r)
+		if c := norm.NFD.PropertiesString(s).CCC(); c != 0 {
+			ccc[r] = c
+		}
+		d := norm.NFD.String(s)
+		if d == s {
+			continue
+		}
+		decomp[r] = d
+		// A primary composite is the composition of its decomposition without
+		// the last mark and that mark.
+		if norm.NFC.String(s) != s {
+			continue
+		}
+		rs := []rune(d)
+		if len(rs) < 2 {
+			continue
+		}
+		first := []rune(norm.NFC.String(string(rs[:len(rs)-1])))
+		if len(first) == 1 && norm.NFC.String(string(first)+string(rs[len(rs)-1])) == s {
+			compose[[2]rune{first[0], rs[len(rs)-1]}] = r
+		}
+	}
+
+	fmt.Println("// Code generated by \"go run gen_nfc.go\"; DO NOT EDIT.")
+	fmt.Println()
+	fmt.Println("package scanner")
+	fmt.Println()
+	fmt.Printf("// Unicode version the tables were generated from.\nconst nfcVersion = %q\n\n", unicode.Version)
+
+	fmt.Println("// Full canonical decomposition of every rune that has one, except Hangul syllables.")
+	fmt.Println("var nfcDecomp = map[rune]string{")
+	var keys []rune
+	for r := range decomp {
+		keys = append(keys, r)
+	}
+	sort.Slice(keys, func(i, j int) bool { return keys[i] < keys[j] })
+	var items []string
+	for _, r := range keys {
+		items = append(items, fmt.Sprintf("0x%X: %+q", r, decomp[r]))
+	}
+	printItems(items, 4)
+	fmt.Println("}")
+	fmt.Println()
+
+	fmt.Println("// Canonical combining class of every rune whose class is not zero.")
+	fmt.Println("var nfcCombining = map[rune]uint8{")
+	keys = keys[:0]
+	for r := range ccc {
+		keys = append(keys, r)
+	}
+	sort.Slice(keys, func(i, j int) bool { return keys[i] < keys[j] })
+	items = items[:0]
+	for _, r := range keys {
+		items = append(items, fmt.Sprintf("0x%X: %d", r, ccc[r]))
+	}
+	printItems(items, 8)
+	fmt.Println("}")
+	fmt.Println()
+
+	fmt.Println("// Primary composite of each pair of runes that canonically compose, except Hangul.")
+	fmt.Println("var nfcCompose = map[[2]rune]rune{")
+	var pairs [][2]rune
+	for p := range compose {
+		pairs = append(pairs, p)
+	}
+	sort.Slice(pairs, func(i, j int) bool {
+		if pairs[i][0] != pairs[j][0] {
+			return pairs[i][0] < pairs[j][0]
+		}
+		return pairs[i][1] < pairs[j][1]
+	})
+	items = items[:0]
+	for _, p := range pairs {
+		items = append(items, fmt.Sprintf("{0x%X, 0x%X}: 0x%X", p[0], p[1], compose[p]))
+	}
+	printItems(items, 4)
+	fmt.Println("}")
+}
+
+func printItems(items []string, perLine int) {
+	for i := 0; i < len(items); i += perLine {
+		end := i + perLine
+		if end > len(items) {
+			end = len(items)
+		}
+		fmt.Println("\t" + strings.Join(items[i:end], ", ") + ",")
+	}
+}
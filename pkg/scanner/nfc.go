@@ -0,0 +1,158 @@
+package scanner
+
+import (
+	"unicode"
+)
+
+// Constants of the algorithmic Hangul decomposition.
+const (
+	hangulSBase  = 0xAC00
+	hangulLBase  = 0x1100
+	hangulVBase  = 0x1161
+	hangulTBase  = 0x11A7
+	hangulLCount = 19
+	hangulVCount = 21
+	hangulTCount = 28
+	hangulNCount = hangulVCount * hangulTCount
+	hangulSCount = hangulLCount * hangulNCount
+)
+
+// Returns s in Unicode Normalization Form C: s is fully decomposed, the
+// combining marks are put into canonical order, and the result is composed
+// again.
+func NFC(s string) string {
+	var rs []rune
+	for _, r := range s {
+		rs = decompose(rs, r)
+	}
+	reorder(rs)
+	return string(compose(rs))
+}
+
+// Appends the canonical decomposition of r to rs.
+func decompose(rs []rune, r rune) []rune {
+	if hangulSBase <= r && r < hangulSBase+hangulSCount {
+		si := r - hangulSBase
+		rs = append(rs, hangulLBase+si/hangulNCount, hangulVBase+(si%hangulNCount)/hangulTCount)
+		if t := si % hangulTCount; t != 0 {
+			rs = append(rs, hangulTBase+t)
+		}
+		return rs
+	}
+	if d, ok := nfcDecomp[r]; ok {
+		return append(rs, []rune(d)...)
+	}
+	return append(rs, r)
+}
+
+// Sorts every run of combining marks by combining class, keeping marks of the
+// same class in their order.
+func reorder(rs []rune) {
+	for i := 1; i < len(rs); i++ {
+		c := nfcCombining[rs[i]]
+		if c == 0 {
+			continue
+		}
+		for j := i; j > 0 && nfcCombining[rs[j-1]] > c; j-- {
+			rs[j], rs[j-1] = rs[j-1], rs[j]
+		}
+	}
+}
+
+// Combines each mark with the last starter before it, unless a mark of the
+// same or a higher class, or another starter, comes between them.
+func compose(rs []rune) []rune {
+	if len(rs) == 0 {
+		return rs
+	}
+	starter := 0
+	lastClass := int(nfcCombining[rs[0]])
+	if lastClass != 0 {
+		// No starter to compose with yet.
+		lastClass = 256
+	}
+	n := 1
+	for _, r := range rs[1:] {
+		class := int(nfcCombining[r])
+		if c, ok := composePair(rs[starter], r); ok && (lastClass < class || lastClass == 0) {
+			rs[starter] = c
+			continue
+		}
+		if class == 0 {
+			starter = n
+		}
+		lastClass = class
+		rs[n] = r
+		n += 1
+	}
+	return rs[:n]
+}
+
+// Returns the primary composite of a and b, if there is one.
+func composePair(a, b rune) (rune, bool) {
+	if hangulLBase <= a && a < hangulLBase+hangulLCount && hangulVBase <= b && b < hangulVBase+hangulVCount {
+		return hangulSBase + ((a-hangulLBase)*hangulVCount+b-hangulVBase)*hangulTCount, true
+	}
+	if hangulSBase <= a && a < hangulSBase+hangulSCount && (a-hangulSBase)%hangulTCount == 0 &&
+		hangulTBase < b && b < hangulTBase+hangulTCount {
+		return a + b - hangulTBase, true
+	}
+	c, ok := nfcCompose[[2]rune{a, b}]
+	return c, ok
+}
+
+// Scripts that may be mixed in one identifier, following the "highly
+// restrictive" level of Unicode TS #39. Characters common to all scripts, such
+// as digits, and inherited combining marks can appear with any script.
+var scriptSets = [][]string{
+	{"Latin", "Han", "Hiragana", "Katakana"},
+	{"Latin", "Han", "Bopomofo"},
+	{"Latin", "Han", "Hangul"},
+}
+
+// Returns an error message if the identifier could be mistaken for a different
+// one, or "" if it is fine. An identifier is rejected if it is not in NFC, so
+// that it may look like another spelling of the same letters, or if it mixes
+// scripts, like a Latin "a" next to a Cyrillic "а".
+func confusable(ident string) string {
+	if NFC(ident) != ident {
+		return "identifier " + ident + " is not in Unicode NFC"
+	}
+	scripts := map[string]bool{}
+	for _, r := range ident {
+		if r < 0x80 {
+			if unicode.IsLetter(r) {
+				scripts["Latin"] = true
+			}
+			continue
+		}
+		if name := scriptOf(r); name != "" && name != "Common" && name != "Inherited" {
+			scripts[name] = true
+		}
+	}
+	if len(scripts) <= 1 {
+		return ""
+	}
+	for _, set := range scriptSets {
+		allowed := 0
+		for _, name := range set {
+			if scripts[name] {
+				allowed += 1
+			}
+		}
+		if allowed == len(scripts) {
+			return ""
+		}
+	}
+	return "identifier " + ident + " mixes scripts"
+}
+
+// Returns the name of the script r belongs to, or "" if it is not known.
+func scriptOf(r rune) string {
+	for name, table := range unicode.Scripts {
+		if unicode.Is(table, r) {
+			return name
+		}
+	}
+	return ""
+}
@@ -1,162 +1,418 @@
 package scanner
 
 import (
-	"fmt"
-	i "group-11/pkg/inputdata"
+	"group-11/pkg/diag"
 	k "group-11/pkg/keywords"
-	"math"
+	"group-11/pkg/source"
 	"strconv"
 	"unicode"
+	"unicode/utf16"
+	"unicode/utf8"
 )
 
-func Init(inputData *i.InputData) {
-	GetChar(inputData)
-	GetSym(inputData)
+// Value of the current character at the end of the input.
+const eof = -1
+
+// Value of the current character before the first one has been read.
+const bof = -2
+
+// What the lexer does with identifiers that contain characters outside ASCII.
+type IdentPolicy int
+
+const (
+	IdentAsIs             IdentPolicy = iota // Keep identifiers as they are written.
+	IdentNFC                                 // Normalize identifiers to Unicode NFC.
+	IdentRejectConfusable                    // Report identifiers that are not in NFC or that mix scripts.
+)
+
+// Settings that change how the lexer reads identifiers and counts columns.
+type Options struct {
+	Idents       IdentPolicy // How identifiers are checked and normalized.
+	UTF16Columns bool        // Count columns in UTF-16 code units, as many editors do, instead of in characters.
+}
+
+// Width of integers in bits, that of the i32 every target computes with.
+const intBits = 32
+
+// Splits P0 source text into tokens. Tokens are produced on demand by Next,
+// and any number of tokens can be looked at in advance with Peek.
+type Lexer struct {
+	src    string                // Text being scanned, starting at offset base.
+	base   int                   // Offset of the first character in src.
+	more   func() (string, bool) // Supplies further text when src is used up, may be nil.
+	file   int                   // ID of the file the text belongs to.
+	opts   Options               // Settings given to SetOptions.
+	ch     rune                  // Current character, or eof at the end of the input.
+	pos    source.Pos            // Position of the current character.
+	next   source.Pos            // Position of the character after the current one.
+	ahead  []Token               // Tokens already scanned by Peek but not returned by Next.
+	errors diag.Handler          // Receives lexical errors, may be nil.
+}
+
+// Creates a lexer for the given source text. The file ID is copied into every
+// token, and lexical errors are passed to errh.
+func NewLexer(src string, file int, errh diag.Handler) *Lexer {
+	l := &Lexer{src: src, file: file, errors: errh}
+	l.next = source.Pos{Offset: 0, Line: 1, Col: 1}
+	l.ch = bof
+	return l
+}
+
+// Creates a lexer that reads its source text from a channel of chunks. A token
+// may span several chunks; the lexer stops reading when the channel is closed.
+func NewChunkLexer(chunks <-chan string, file int, errh diag.Handler) *Lexer {
+	l := &Lexer{file: file, errors: errh}
+	l.more = func() (string, bool) {
+		chunk, ok := <-chunks
+		return chunk, ok
+	}
+	l.next = source.Pos{Offset: 0, Line: 1, Col: 1}
+	l.ch = bof
+	return l
+}
+
+// Changes the settings of the lexer; must be called before the first token is read.
+func (l *Lexer) SetOptions(opts Options) {
+	l.opts = opts
+}
+
+// Returns the next token and advances past it. At the end of the input an EOF
+// token is returned on every call.
+func (l *Lexer) Next() Token {
+	if len(l.ahead) > 0 {
+		tok := l.ahead[0]
+		l.ahead = l.ahead[1:]
+		return tok
+	}
+	return l.scan()
 }
 
-// Moves the index to the next char
-func GetChar(inputData *i.InputData) {
-	// This loop will wait at a given index for the whitespace and comment eaters to catch up.
-	for string(inputData.Input[inputData.Index]) == " " {
-		//pass
+// Returns the token n positions ahead without consuming it; Peek(1) is the
+// token that the next call to Next returns.
+func (l *Lexer) Peek(n int) Token {
+	for len(l.ahead) < n {
+		l.ahead = append(l.ahead, l.scan())
 	}
-	currentChar := string(inputData.Input[inputData.Index])
-	if currentChar == "~" {
-		inputData.Ch = currentChar
+	return l.ahead[n-1]
+}
+
+// Moves to the next character. Columns count characters, not bytes, unless
+// UTF-16 columns were asked for.
+func (l *Lexer) getChar() {
+	l.pos = l.next
+	if !l.ensure(l.pos.Offset) {
+		l.ch = eof
+		return
+	}
+	r, size := utf8.DecodeRuneInString(l.src[l.pos.Offset-l.base:])
+	l.ch = r
+	l.next.Offset += size
+	if r == '\n' {
+		l.next.Line += 1
+		l.next.Col = 1
+	} else if l.opts.UTF16Columns {
+		l.next.Col += utf16.RuneLen(r)
 	} else {
-		inputData.Ch = currentChar
-		inputData.Index += 1
-		if currentChar == "\n" {
-			inputData.Pos = 0
-			inputData.LineNumber += 1
-		} else {
-			inputData.LastLine = inputData.LineNumber
-			inputData.Pos += 1
-		}
+		l.next.Col += 1
 	}
 }
 
-// Identifies keywords in a given sequence of characters.
-func IdentKeyword(inputData *i.InputData) {
-	start := inputData.Index - 1
-	current := ""
-	for unicode.IsLetter([]rune(inputData.Ch)[0]) {
-		current = inputData.Input[start:inputData.Index]
-		if val, ok := k.Keywords[current]; ok {
-			inputData.Sym = val
-			GetChar(inputData)
-			break
-		} else {
-			inputData.Sym = k.IDENT
+// Returns the character after the current one without moving to it.
+func (l *Lexer) peekChar() rune {
+	if !l.ensure(l.next.Offset) {
+		return eof
+	}
+	r, _ := utf8.DecodeRuneInString(l.src[l.next.Offset-l.base:])
+	return r
+}
+
+// Makes sure that the whole UTF-8 sequence starting at offset is in src,
+// reading more text if needed. Returns false if offset is at the end of the input.
+func (l *Lexer) ensure(offset int) bool {
+	for !utf8.FullRuneInString(l.src[offset-l.base:]) {
+		if !l.fill() {
+			// An incomplete sequence at the end is decoded as an illegal character.
+			return offset-l.base < len(l.src)
 		}
-		GetChar(inputData)
-	}
-	inputData.Val = current
-	fmt.Print(inputData.Val)
-}
-
-// Converts a string of numbers into value
-func Number(inputData *i.InputData) {
-	inputData.Sym = k.NUMBER
-	inputData.Val = ""
-	for unicode.IsNumber([]rune(inputData.Ch)[0]) {
-		inputData.Val += inputData.Ch
-		GetChar(inputData)
-	}
-
-	res, err3 := strconv.Atoi(inputData.Val)
-	if float64(res) >= math.Exp2(31) {
-		PrintError(inputData, "number too large")
-	}
-	if err3 != nil {
-		PrintError(inputData, "cannot convert to number")
-	}
-	fmt.Print("number is: ")
-	fmt.Print(inputData.Val)
-}
-
-func GetSym(inputData *i.InputData) {
-	// []byte{13} is an invisible character that gets picked up from the input file.
-	// ! is the delimiter to help identify the "do" from "while-do" statements.
-	for inputData.Ch == "\n" || inputData.Ch == " " || inputData.Ch == "!" {
-		GetChar(inputData)
-	}
-	if unicode.IsLetter([]rune(inputData.Ch)[0]) {
-		IdentKeyword(inputData)
-	} else if unicode.IsNumber([]rune(inputData.Ch)[0]) {
-		Number(inputData)
-	} else if inputData.Ch == "*" {
-		GetChar(inputData)
-		inputData.Sym = k.TIMES
-	} else if inputData.Ch == "+" {
-		GetChar(inputData)
-		inputData.Sym = k.PLUS
-	} else if inputData.Ch == "-" {
-		GetChar(inputData)
-		inputData.Sym = k.MINUS
-	} else if inputData.Ch == "=" {
-		GetChar(inputData)
-		inputData.Sym = k.EQ
-	} else if inputData.Ch == "<" {
-		GetChar(inputData)
-		if inputData.Ch == "=" {
-			GetChar(inputData)
-			inputData.Sym = k.LE
-		} else if inputData.Ch == ">" {
-			GetChar(inputData)
-			inputData.Sym = k.NE
+	}
+	return true
+}
+
+// Skips a { } or (* *) comment starting at the current character, together
+// with the comments nested in it; either kind can be nested in the other.
+// Returns false if the input ends before the comment is closed, together with
+// the opener of the innermost comment that is not closed.
+func (l *Lexer) blockComment() (source.Span, bool) {
+	open := source.Span{File: l.file, Start: l.pos}
+	paren := l.ch == '('
+	if paren {
+		l.getChar()
+	}
+	open.End = l.next
+	l.getChar()
+	for l.ch != eof {
+		if l.ch == '{' || l.ch == '(' && l.peekChar() == '*' {
+			if inner, ok := l.blockComment(); !ok {
+				return inner, false
+			}
+		} else if !paren && l.ch == '}' {
+			l.getChar()
+			return open, true
+		} else if paren && l.ch == '*' && l.peekChar() == ')' {
+			l.getChar()
+			l.getChar()
+			return open, true
 		} else {
-			inputData.Sym = k.LT
+			l.getChar()
 		}
-	} else if inputData.Ch == ">" {
-		GetChar(inputData)
-		if inputData.Ch == "=" {
-			GetChar(inputData)
-			inputData.Sym = k.GE
+	}
+	return open, false
+}
+
+// Appends the next chunk of text to src, returning false at the end of the input.
+func (l *Lexer) fill() bool {
+	if l.more == nil {
+		return false
+	}
+	chunk, ok := l.more()
+	if !ok {
+		l.more = nil
+		return false
+	}
+	l.src += chunk
+	return true
+}
+
+// Returns the source text between two offsets that are still held in src.
+func (l *Lexer) text(start, end int) string {
+	return l.src[start-l.base : end-l.base]
+}
+
+// Reports an error about the text from start up to the current character.
+func (l *Lexer) error(start source.Pos, code string, msg string) {
+	l.errorSpan(start, l.pos, code, msg)
+}
+
+// Reports an error about the text between start and end.
+func (l *Lexer) errorSpan(start, end source.Pos, code string, msg string) {
+	l.report(diag.NewError(code, source.Span{File: l.file, Start: start, End: end}, msg))
+}
+
+// Passes a diagnostic to the error handler, if there is one.
+func (l *Lexer) report(d diag.Diagnostic) {
+	if l.errors != nil {
+		l.errors(d)
+	}
+}
+
+// Reads the next token from the input.
+func (l *Lexer) scan() Token {
+	if l.ch == bof {
+		l.getChar()
+	}
+	for {
+		if unicode.IsSpace(l.ch) {
+			l.getChar()
+		} else if l.ch == '{' || l.ch == '(' && l.peekChar() == '*' {
+			start := l.pos
+			opening := l.next
+			if l.ch == '(' {
+				opening.Offset += 1
+				opening.Col += 1
+			}
+			if inner, ok := l.blockComment(); !ok {
+				d := diag.NewError("E101", inner, "comment not terminated")
+				if inner.Start != start {
+					d = d.WithRelated(source.Span{File: l.file, Start: start, End: opening}, "inside this comment")
+				}
+				l.report(d)
+			}
+		} else if l.ch == '/' && l.peekChar() == '/' {
+			for l.ch != '\n' && l.ch != eof {
+				l.getChar()
+			}
 		} else {
-			inputData.Sym = k.GT
+			break
 		}
-	} else if inputData.Ch == ";" {
-		GetChar(inputData)
-		inputData.Sym = k.SEMICOLON
-	} else if inputData.Ch == "," {
-		GetChar(inputData)
-		inputData.Sym = k.COMMA
-	} else if inputData.Ch == ":" {
-		GetChar(inputData)
-		inputData.Sym = k.COLON
-	} else if inputData.Ch == "." {
-		GetChar(inputData)
-		inputData.Sym = k.PERIOD
-	} else if inputData.Ch == "(" {
-		GetChar(inputData)
-		inputData.Sym = k.LPAREN
-	} else if inputData.Ch == ")" {
-		GetChar(inputData)
-		inputData.Sym = k.RPAREN
-	} else if inputData.Ch == "[" {
-		GetChar(inputData)
-		inputData.Sym = k.LBRAK
-	} else if inputData.Ch == "]" {
-		GetChar(inputData)
-		inputData.Sym = k.RBRAK
-	} else if inputData.Ch == "~" {
-		inputData.Sym = k.EOF
+	}
+	// Text before the token is no longer needed.
+	l.src = l.src[l.pos.Offset-l.base:]
+	l.base = l.pos.Offset
+	tok := Token{Start: l.pos, File: l.file}
+	if unicode.IsLetter(l.ch) {
+		tok.Kind, tok.Lexeme = l.identKeyword(tok.Start)
+	} else if '0' <= l.ch && l.ch <= '9' {
+		tok.Kind, tok.Val = l.number(tok.Start)
 	} else {
-		PrintError(inputData, "illegal character")
-		GetChar(inputData)
-		inputData.Sym = 0
+		switch l.ch {
+		case '*':
+			l.getChar()
+			tok.Kind = k.TIMES
+		case '+':
+			l.getChar()
+			tok.Kind = k.PLUS
+		case '-':
+			l.getChar()
+			tok.Kind = k.MINUS
+		case '=':
+			l.getChar()
+			tok.Kind = k.EQ
+		case '<':
+			l.getChar()
+			if l.ch == '=' {
+				l.getChar()
+				tok.Kind = k.LE
+			} else if l.ch == '>' {
+				l.getChar()
+				tok.Kind = k.NE
+			} else {
+				tok.Kind = k.LT
+			}
+		case '>':
+			l.getChar()
+			if l.ch == '=' {
+				l.getChar()
+				tok.Kind = k.GE
+			} else {
+				tok.Kind = k.GT
+			}
+		case ';':
+			l.getChar()
+			tok.Kind = k.SEMICOLON
+		case ',':
+			l.getChar()
+			tok.Kind = k.COMMA
+		case ':':
+			l.getChar()
+			if l.ch == '=' {
+				l.getChar()
+				tok.Kind = k.BECOMES
+			} else {
+				tok.Kind = k.COLON
+			}
+		case '.':
+			l.getChar()
+			tok.Kind = k.PERIOD
+		case '(':
+			l.getChar()
+			tok.Kind = k.LPAREN
+		case ')':
+			l.getChar()
+			tok.Kind = k.RPAREN
+		case '[':
+			l.getChar()
+			tok.Kind = k.LBRAK
+		case ']':
+			l.getChar()
+			tok.Kind = k.RBRAK
+		case eof:
+			tok.Kind = k.EOF
+		default:
+			l.getChar()
+			l.error(tok.Start, "E100", "illegal character")
+			tok.Kind = 0
+		}
 	}
-	fmt.Print("\t")
-	fmt.Println(inputData.Sym)
+	tok.End = l.pos
+	if tok.Lexeme == "" {
+		tok.Lexeme = l.text(tok.Start.Offset, tok.End.Offset)
+	}
+	return tok
 }
 
-// Prints out an error and the line and pos it was found on
-func PrintError(inputData *i.InputData, errorMsg string) {
-	if inputData.LastLine > inputData.ErrorLine || inputData.LastPos > inputData.ErrorPos {
-		fmt.Println("Error: line " + strconv.Itoa(inputData.LastLine) + ", pos " + strconv.Itoa(inputData.LastPos) + errorMsg)
+// Reads the longest sequence of letters, digits and combining marks and
+// identifies it as a keyword or an identifier, so "dox" and "endValue" are
+// identifiers. Returns the kind and the identifier, normalized if the options
+// ask for it.
+func (l *Lexer) identKeyword(start source.Pos) (int, string) {
+	for unicode.IsLetter(l.ch) || unicode.IsDigit(l.ch) || unicode.In(l.ch, unicode.Mn, unicode.Mc) {
+		l.getChar()
 	}
-	inputData.ErrorLine = inputData.LastLine
-	inputData.ErrorPos = inputData.LastPos
-	inputData.Error = true
-}
\ No newline at end of file
+	ident := l.text(start.Offset, l.pos.Offset)
+	if l.opts.Idents == IdentNFC {
+		ident = NFC(ident)
+	} else if l.opts.Idents == IdentRejectConfusable {
+		if msg := confusable(ident); msg != "" {
+			l.error(start, "E104", msg)
+		}
+	}
+	if kind, ok := k.Keywords[ident]; ok {
+		return kind, ident
+	}
+	return k.IDENT, ident
+}
+
+// Reads a number and returns its value. Numbers are written as
+//
+//	123       decimal
+//	0FFH      hexadecimal, Oberon style: starts with a digit and ends in H
+//	0x1F      hexadecimal
+//	0b1010    binary
+//
+// and digits may be separated by single underscores, as in 1_000_000 or
+// 0b1111_0000. A decimal number must fit into a signed 32 bit integer;
+// hexadecimal and binary numbers may use all 32 bits, so 0FFFFFFFFH is -1.
+func (l *Lexer) number(start source.Pos) (int, int) {
+	for l.ch < utf8.RuneSelf && (unicode.IsLetter(l.ch) || unicode.IsDigit(l.ch) || l.ch == '_') {
+		l.getChar()
+	}
+	text := l.text(start.Offset, l.pos.Offset)
+	digits, base := text, uint64(10)
+	if n := len(text); n > 1 && (text[n-1] == 'H' || text[n-1] == 'h') {
+		digits, base = text[:n-1], 16
+	} else if n > 2 && text[0] == '0' && (text[1] == 'x' || text[1] == 'X') {
+		digits, base = text[2:], 16
+	} else if n > 2 && text[0] == '0' && (text[1] == 'b' || text[1] == 'B') {
+		digits, base = text[2:], 2
+	}
+	limit := uint64(1)<<(intBits-1) - 1
+	if base != 10 {
+		limit = uint64(1)<<intBits - 1
+	}
+	var val uint64
+	tooLarge := false
+	for i := 0; i < len(digits); i++ {
+		c := digits[i]
+		if c == '_' {
+			if i == 0 || i == len(digits)-1 || digits[i+1] == '_' {
+				l.error(start, "E102", "malformed number "+text+": _ must stand between two digits")
+				return k.NUMBER, 0
+			}
+			continue
+		}
+		d := digitValue(c)
+		if d >= base {
+			l.error(start, "E102", "malformed number "+text)
+			return k.NUMBER, 0
+		}
+		val = val*base + d
+		if val > limit {
+			tooLarge = true
+			// Keep reading to report malformed digits first.
+			val = 0
+		}
+	}
+	if tooLarge {
+		msg := "number too large: " + text + " does not fit into " + strconv.Itoa(intBits) + " bit integers"
+		l.report(diag.NewError("E103", source.Span{File: l.file, Start: start, End: l.pos}, msg).
+			WithNote("the largest number that can be written this way is " + strconv.FormatUint(limit, 10)))
+		return k.NUMBER, 0
+	}
+	if val > uint64(1)<<(intBits-1)-1 {
+		// Hexadecimal and binary numbers denote bit patterns.
+		return k.NUMBER, int(int64(val) - int64(1)<<intBits)
+	}
+	return k.NUMBER, int(val)
+}
+
+// Returns the value of a digit in bases up to 16, or 99 for anything else.
+func digitValue(c byte) uint64 {
+	if '0' <= c && c <= '9' {
+		return uint64(c - '0')
+	} else if 'a' <= c && c <= 'f' {
+		return uint64(c - 'a' + 10)
+	} else if 'A' <= c && c <= 'F' {
+		return uint64(c - 'A' + 10)
+	}
+	return 99
+}
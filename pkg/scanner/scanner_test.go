@@ -0,0 +1,281 @@
+package scanner
+
+import (
+	"fmt"
+	"group-11/pkg/diag"
+	k "group-11/pkg/keywords"
+	"reflect"
+	"strings"
+	"testing"
+	"unicode"
+)
+
+// Returns the tokens of src up to EOF, scanned with opts, and the lexical
+// errors found.
+func scanAll(src string, opts Options) ([]Token, []diag.Diagnostic) {
+	var diags []diag.Diagnostic
+	l := NewLexer(src, 0, func(d diag.Diagnostic) { diags = append(diags, d) })
+	l.SetOptions(opts)
+	var toks []Token
+	for tok := l.Next(); tok.Kind != k.EOF; tok = l.Next() {
+		toks = append(toks, tok)
+	}
+	return toks, diags
+}
+
+// Formats a position as line:col.
+func at(line, col int) string {
+	return fmt.Sprintf("%d:%d", line, col)
+}
+
+// Peek looks any number of tokens ahead, and Next then returns the same tokens
+// in order.
+func TestPeek(t *testing.T) {
+	l := NewLexer("a := b + 1", 0, nil)
+	if tok := l.Peek(3); tok.Kind != k.IDENT || tok.Lexeme != "b" {
+		t.Errorf("Peek(3) = %v, want b", tok)
+	}
+	if tok := l.Peek(1); tok.Kind != k.IDENT || tok.Lexeme != "a" {
+		t.Errorf("Peek(1) = %v, want a", tok)
+	}
+	want := []int{k.IDENT, k.BECOMES, k.IDENT, k.PLUS, k.NUMBER, k.EOF, k.EOF}
+	for i, kind := range want {
+		if i == 2 {
+			if tok := l.Peek(2); tok.Kind != k.PLUS {
+				t.Errorf("Peek(2) before b = %v, want +", tok)
+			}
+		}
+		if tok := l.Next(); tok.Kind != kind {
+			t.Errorf("token %d = %v, want %s", i, tok, KindName(kind))
+		}
+	}
+}
+
+// Tokens know where they start and end: the end is just after the last
+// character, and lines and columns start at 1.
+func TestTokenPositions(t *testing.T) {
+	src := "x := 10;\n  while x<>0 do\n{ c }end."
+	want := []struct {
+		lexeme     string
+		start, end string
+		offset     int
+	}{
+		{"x", at(1, 1), at(1, 2), 0},
+		{":=", at(1, 3), at(1, 5), 2},
+		{"10", at(1, 6), at(1, 8), 5},
+		{";", at(1, 8), at(1, 9), 7},
+		{"while", at(2, 3), at(2, 8), 11},
+		{"x", at(2, 9), at(2, 10), 17},
+		{"<>", at(2, 10), at(2, 12), 18},
+		{"0", at(2, 12), at(2, 13), 20},
+		{"do", at(2, 14), at(2, 16), 22},
+		{"end", at(3, 6), at(3, 9), 30},
+		{".", at(3, 9), at(3, 10), 33},
+	}
+	toks, _ := scanAll(src, Options{})
+	if len(toks) != len(want) {
+		t.Fatalf("%d tokens, want %d", len(toks), len(want))
+	}
+	for i, w := range want {
+		tok := toks[i]
+		start, end := at(tok.Start.Line, tok.Start.Col), at(tok.End.Line, tok.End.Col)
+		if tok.Lexeme != w.lexeme || start != w.start || end != w.end || tok.Start.Offset != w.offset {
+			t.Errorf("token %d: %q %s-%s at %d, want %q %s-%s at %d", i, tok.Lexeme, start, end, tok.Start.Offset, w.lexeme, w.start, w.end, w.offset)
+		}
+		if tok.End.Offset-tok.Start.Offset != len(tok.Lexeme) {
+			t.Errorf("token %d: %q spans %d bytes", i, tok.Lexeme, tok.End.Offset-tok.Start.Offset)
+		}
+	}
+}
+
+// A comment that is not closed is reported at the opener of the innermost
+// comment left open, with the outermost one as a related span.
+func TestUnclosedComment(t *testing.T) {
+	tests := []struct {
+		src     string
+		at      int // Offset of the reported opener.
+		related int // Offset of the outermost opener, -1 if it is the reported one.
+	}{
+		{"(* never { closed *)", 9, 0},
+		{"x { a (* b } c", 6, 2},
+		{"x { a (* b *) c", 2, -1},
+		{"(* plain", 0, -1},
+	}
+	for _, test := range tests {
+		var diags []diag.Diagnostic
+		l := NewLexer(test.src, 0, func(d diag.Diagnostic) { diags = append(diags, d) })
+		for l.Next().Kind != k.EOF {
+		}
+		if len(diags) != 1 || diags[0].Code != "E101" {
+			t.Errorf("%q: diagnostics %v", test.src, diags)
+			continue
+		}
+		d := diags[0]
+		if d.Span.Start.Offset != test.at {
+			t.Errorf("%q: reported at %d, want %d", test.src, d.Span.Start.Offset, test.at)
+		}
+		if test.related < 0 && len(d.Related) != 0 || test.related >= 0 && (len(d.Related) != 1 || d.Related[0].Span.Start.Offset != test.related) {
+			t.Errorf("%q: related %v, want %d", test.src, d.Related, test.related)
+		}
+	}
+}
+
+// Identifiers and operators are read by maximal munch: a keyword at the start
+// of an identifier does not end it, and whitespace or comments separate tokens.
+func TestMaximalMunch(t *testing.T) {
+	tests := []struct {
+		src  string
+		want []string
+	}{
+		{"dox", []string{"identifier dox"}},
+		{"do x", []string{"do do", "identifier x"}},
+		{"endValue:=end", []string{"identifier endValue", ":= :=", "end end"}},
+		{"ifx then", []string{"identifier ifx", "then then"}},
+		{"divx div{c}mod", []string{"identifier divx", "div div", "mod mod"}},
+		{"a<=b<>c>=d<e", []string{"identifier a", "<= <=", "identifier b", "<> <>", "identifier c", ">= >=", "identifier d", "< <", "identifier e"}},
+		{"x: =y", []string{"identifier x", ": :", "= =", "identifier y"}},
+		{"1..3", []string{"number 1", ". .", ". .", "number 3"}},
+		{"a(*c*)b//d\ne", []string{"identifier a", "identifier b", "identifier e"}},
+	}
+	for _, test := range tests {
+		toks, diags := scanAll(test.src, Options{})
+		got := []string{}
+		for _, tok := range toks {
+			got = append(got, KindName(tok.Kind)+" "+tok.Lexeme)
+		}
+		if !reflect.DeepEqual(got, test.want) || len(diags) != 0 {
+			t.Errorf("%q: tokens %q, diagnostics %v, want %q", test.src, got, diags, test.want)
+		}
+	}
+}
+
+// Columns count characters, or UTF-16 code units if asked for, also for the
+// characters in comments; offsets count bytes.
+func TestColumns(t *testing.T) {
+	tests := []struct {
+		src    string
+		utf16  bool
+		starts []int // Column of every token.
+		end    int   // Column after the last token.
+	}{
+		{"äb := x", false, []int{1, 4, 7}, 8},
+		{"ä𝔞 := x", false, []int{1, 4, 7}, 8},
+		{"ä𝔞 := x", true, []int{1, 5, 8}, 9},
+		{"{ 𝔞𝔟 } y", false, []int{8}, 9},
+		{"{ 𝔞𝔟 } y", true, []int{10}, 11},
+	}
+	for _, test := range tests {
+		toks, _ := scanAll(test.src, Options{UTF16Columns: test.utf16})
+		starts := []int{}
+		for _, tok := range toks {
+			starts = append(starts, tok.Start.Col)
+		}
+		last := toks[len(toks)-1]
+		if !reflect.DeepEqual(starts, test.starts) || last.End.Col != test.end {
+			t.Errorf("%q, utf16 %v: columns %v to %d, want %v to %d", test.src, test.utf16, starts, last.End.Col, test.starts, test.end)
+		}
+		if last.End.Offset != len(test.src) {
+			t.Errorf("%q: ends at offset %d, want %d", test.src, last.End.Offset, len(test.src))
+		}
+	}
+}
+
+// Identifiers are kept as they are written, normalized to NFC, or rejected if
+// they are not in NFC or mix scripts, depending on the options.
+func TestIdentPolicy(t *testing.T) {
+	tests := []struct {
+		src    string
+		policy IdentPolicy
+		lexeme string
+		err    string // Message of the error reported, "" for none.
+	}{
+		{"cafe\u0301", IdentAsIs, "cafe\u0301", ""},
+		{"cafe\u0301", IdentNFC, "caf\u00e9", ""},
+		{"cafe\u0301", IdentRejectConfusable, "cafe\u0301", "identifier cafe\u0301 is not in Unicode NFC"},
+		{"caf\u00e9", IdentRejectConfusable, "caf\u00e9", ""},
+		{"\u1100\u1161", IdentNFC, "\uac00", ""},
+		{"p\u0430y", IdentAsIs, "p\u0430y", ""},
+		{"p\u0430y", IdentRejectConfusable, "p\u0430y", "identifier p\u0430y mixes scripts"},
+		{"x\u65e5\u672c", IdentRejectConfusable, "x\u65e5\u672c", ""},
+		{"x\u03b1", IdentRejectConfusable, "x\u03b1", "identifier x\u03b1 mixes scripts"},
+	}
+	for _, test := range tests {
+		toks, diags := scanAll(test.src, Options{Idents: test.policy})
+		if len(toks) != 1 || toks[0].Kind != k.IDENT || toks[0].Lexeme != test.lexeme {
+			t.Errorf("%+q: tokens %v, want identifier %+q", test.src, toks, test.lexeme)
+		}
+		if test.err == "" && len(diags) != 0 || test.err != "" && (len(diags) != 1 || diags[0].Code != "E104" || diags[0].Message != test.err) {
+			t.Errorf("%+q: diagnostics %v, want %q", test.src, diags, test.err)
+		}
+	}
+}
+
+// The NFC tables must come from the Unicode version of the Go release, which
+// decides what unicode.IsLetter accepts.
+func TestNFCVersion(t *testing.T) {
+	if nfcVersion != unicode.Version {
+		t.Errorf("NFC tables of Unicode %s, Go has Unicode %s; run go run gen_nfc.go > nfc_tables.go", nfcVersion, unicode.Version)
+	}
+}
+
+// Numbers can be written in several bases with _ between digits; hexadecimal
+// and binary ones are bit patterns of 32 bits.
+func TestNumbers(t *testing.T) {
+	tests := []struct {
+		src string
+		val int
+	}{
+		{"123", 123},
+		{"0FFH", 255},
+		{"0ffh", 255},
+		{"0x1F", 31},
+		{"0X1f", 31},
+		{"0b1010", 10},
+		{"1_000_000", 1000000},
+		{"0b1111_0000", 240},
+		{"2147483647", 2147483647},
+		{"7FFF_FFFFH", 2147483647},
+		{"0x8000_0000", -2147483648},
+		{"0FFFFFFFFH", -1},
+		{"0b" + strings.Repeat("1", 32), -1},
+	}
+	for _, test := range tests {
+		toks, diags := scanAll(test.src, Options{})
+		if len(toks) != 1 || toks[0].Kind != k.NUMBER || toks[0].Val != test.val || len(diags) != 0 {
+			t.Errorf("%s: tokens %v, diagnostics %v, want %d", test.src, toks, diags, test.val)
+		}
+	}
+}
+
+// Malformed numbers and numbers that do not fit into 32 bits are reported with
+// the span of the whole literal.
+func TestNumberErrors(t *testing.T) {
+	tests := []struct {
+		src  string
+		code string
+	}{
+		{"2147483648", "E103"},
+		{"99999999999999999999999", "E103"},
+		{"1_0000_0000H", "E103"},
+		{"0b1" + strings.Repeat("0", 32), "E103"},
+		{"1__0", "E102"},
+		{"1_", "E102"},
+		{"0x_1", "E102"},
+		{"0b102", "E102"},
+		{"12AB", "E102"},
+		{"0x", "E102"},
+	}
+	for _, test := range tests {
+		toks, diags := scanAll("x := "+test.src+";", Options{})
+		if len(diags) != 1 || diags[0].Code != test.code {
+			t.Errorf("%s: diagnostics %v, want %s", test.src, diags, test.code)
+			continue
+		}
+		if span := diags[0].Span; span.Start.Offset != 5 || span.End.Offset != 5+len(test.src) {
+			t.Errorf("%s: reported at %d-%d, want %d-%d", test.src, span.Start.Offset, span.End.Offset, 5, 5+len(test.src))
+		}
+		if len(toks) != 4 || toks[2].Kind != k.NUMBER || toks[3].Kind != k.SEMICOLON {
+			t.Errorf("%s: tokens %v", test.src, toks)
+		}
+	}
+}
@@ -0,0 +1,122 @@
+package scanner
+
+import (
+	"group-11/pkg/diag"
+	k "group-11/pkg/keywords"
+)
+
+// Anything the parser can read tokens from.
+type TokenReader interface {
+	Next() Token
+	Peek(n int) Token
+}
+
+// A token together with the lexical errors found while scanning it.
+type streamItem struct {
+	tok  Token
+	errs []diag.Diagnostic
+}
+
+// Delivers the tokens of a lexer that runs in its own goroutine. Errors found
+// by the lexer travel with the tokens and are reported by Next, so the error
+// handler always runs in the goroutine that consumes the tokens.
+type TokenStream struct {
+	items  <-chan streamItem
+	done   chan struct{} // Closed by Close to stop the lexer.
+	exited chan struct{} // Closed by the lexer when it has stopped.
+	ahead  []streamItem  // Items received by Peek but not returned by Next.
+	end    Token         // EOF token, returned again once the stream is drained.
+	count  int           // Number of tokens returned by Next.
+	errors diag.Handler
+}
+
+// Starts a lexer with the given options that reads chunks of source text and
+// sends its tokens down a channel holding at most capacity tokens, so the lexer
+// blocks when the consumer falls behind. The consumer calls Close when it
+// needs no more tokens.
+func StreamTokens(chunks <-chan string, file int, capacity int, opts Options, errh diag.Handler) *TokenStream {
+	items := make(chan streamItem, capacity)
+	ts := &TokenStream{items: items, done: make(chan struct{}), exited: make(chan struct{}), errors: errh}
+	go func() {
+		var pending []diag.Diagnostic
+		l := NewChunkLexer(chunks, file, func(d diag.Diagnostic) {
+			pending = append(pending, d)
+		})
+		l.SetOptions(opts)
+	scan:
+		for {
+			tok := l.Next()
+			select {
+			case items <- streamItem{tok, pending}:
+			case <-ts.done:
+				break scan
+			}
+			pending = nil
+			if tok.Kind == k.EOF {
+				break
+			}
+		}
+		close(items)
+		// Unblock the stage before us if it still has text after the end marker.
+		for range chunks {
+		}
+		close(ts.exited)
+	}()
+	return ts
+}
+
+// Stops the lexer, which may still be scanning text after the end of the
+// program, and waits until it and the stage before it are done.
+func (ts *TokenStream) Close() {
+	select {
+	case <-ts.done:
+	default:
+		close(ts.done)
+	}
+	<-ts.exited
+}
+
+// Returns the next token, reporting any errors found while scanning it.
+func (ts *TokenStream) Next() Token {
+	var it streamItem
+	if len(ts.ahead) > 0 {
+		it = ts.ahead[0]
+		ts.ahead = ts.ahead[1:]
+	} else {
+		it = ts.receive()
+	}
+	if ts.errors != nil {
+		for _, e := range it.errs {
+			ts.errors(e)
+		}
+	}
+	if it.tok.Kind != k.EOF {
+		ts.count += 1
+	}
+	return it.tok
+}
+
+// Returns the token n positions ahead without consuming it.
+func (ts *TokenStream) Peek(n int) Token {
+	for len(ts.ahead) < n {
+		ts.ahead = append(ts.ahead, ts.receive())
+	}
+	return ts.ahead[n-1].tok
+}
+
+// Number of tokens, not counting EOF, returned by Next so far.
+func (ts *TokenStream) Count() int {
+	return ts.count
+}
+
+// Waits for the next item from the lexer.
+func (ts *TokenStream) receive() streamItem {
+	it, ok := <-ts.items
+	if !ok {
+		return streamItem{tok: ts.end}
+	}
+	if it.tok.Kind == k.EOF {
+		ts.end = it.tok
+	}
+	return it
+}
@@ -0,0 +1,47 @@
+package scanner
+
+import (
+	k "group-11/pkg/keywords"
+	"group-11/pkg/source"
+	"strconv"
+)
+
+// A lexical token produced by the Lexer.
+type Token struct {
+	Kind   int        // Kind of token, one of the constants in keywords.
+	Lexeme string     // Source text of the token, normalized for identifiers if the options ask for it.
+	Val    int        // Value of a number.
+	Start  source.Pos // Position of the first character.
+	End    source.Pos // Position just after the last character.
+	File   int        // ID of the file the token was read from.
+}
+
+// Returns the source text range covered by the token.
+func (t Token) Span() source.Span {
+	return source.Span{File: t.File, Start: t.Start, End: t.End}
+}
+
+// Names of the token kinds, used when printing tokens.
+var kindNames = map[int]string{
+	k.TIMES: "*", k.DIV: "div", k.MOD: "mod", k.AND: "and", k.PLUS: "+", k.MINUS: "-",
+	k.OR: "or", k.EQ: "=", k.NE: "<>", k.LT: "<", k.GT: ">", k.LE: "<=", k.GE: ">=",
+	k.PERIOD: ".", k.COMMA: ",", k.COLON: ":", k.RPAREN: ")", k.RBRAK: "]", k.OF: "of",
+	k.THEN: "then", k.DO: "do", k.LPAREN: "(", k.LBRAK: "[", k.NOT: "not", k.BECOMES: ":=",
+	k.NUMBER: "number", k.IDENT: "identifier", k.SEMICOLON: ";", k.END: "end", k.ELSE: "else",
+	k.IF: "if", k.WHILE: "while", k.ARRAY: "array", k.RECORD: "record", k.CONST: "const",
+	k.TYPE: "type", k.VAR: "var", k.PROCEDURE: "procedure", k.BEGIN: "begin",
+	k.PROGRAM: "program", k.RETURN: "return", k.EOF: "end of file"}
+
+// Returns a readable name for a token kind.
+func KindName(kind int) string {
+	if name, ok := kindNames[kind]; ok {
+		return name
+	}
+	return "illegal"
+}
+
+// Formats a token as kind, lexeme and position, mainly for debugging.
+func (t Token) String() string {
+	return KindName(t.Kind) + " " + strconv.Quote(t.Lexeme) + " at " +
+		strconv.Itoa(t.Start.Line) + ":" + strconv.Itoa(t.Start.Col)
+}
@@ -0,0 +1,127 @@
+package source
+
+import (
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"sync"
+	"unicode/utf8"
+)
+
+// Name given to source text read from standard input.
+const StdinName = "<stdin>"
+
+// Position of a character in a source file.
+type Pos struct {
+	Offset int // Byte offset from the start of the file.
+	Line   int // Line number, starting at 1.
+	Col    int // Column number, starting at 1, counted in characters.
+}
+
+// A range of source text, from the first character up to but not including End.
+type Span struct {
+	File  int // ID of the file the text is in.
+	Start Pos
+	End   Pos
+}
+
+// P0 source text together with the name it was loaded under.
+type File struct {
+	ID      int    // Index of the file in its FileSet.
+	Name    string // Path of the file, StdinName, or the name given to a string.
+	Content string // Source text, without any end marker.
+	lines   []int  // Offsets at which the lines of Content begin.
+}
+
+// Returns the line and column of a byte offset in the file. The column counts
+// characters, not bytes.
+func (f *File) Position(offset int) Pos {
+	line := sort.Search(len(f.lines), func(i int) bool { return f.lines[i] > offset }) - 1
+	col := utf8.RuneCountInString(f.Content[f.lines[line]:offset]) + 1
+	return Pos{Offset: offset, Line: line + 1, Col: col}
+}
+
+// Returns the text of line n, without its line break, or "" if there is no such line.
+func (f *File) Line(n int) string {
+	if n < 1 || n > len(f.lines) {
+		return ""
+	}
+	end := len(f.Content)
+	if n < len(f.lines) {
+		end = f.lines[n] - 1
+	}
+	text := f.Content[f.lines[n-1]:end]
+	if len(text) > 0 && text[len(text)-1] == '\r' {
+		text = text[:len(text)-1]
+	}
+	return text
+}
+
+// Set of source files, each identified by the ID it was given when added.
+// It is safe to add files from several goroutines.
+type FileSet struct {
+	mu    sync.Mutex
+	files []*File
+}
+
+// Creates an empty file set.
+func NewFileSet() *FileSet {
+	return &FileSet{}
+}
+
+// Adds source text held in memory under the given name.
+func (fs *FileSet) AddString(name string, content string) *File {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	f := &File{ID: len(fs.files), Name: name, Content: content, lines: []int{0}}
+	for i := 0; i < len(content); i++ {
+		if content[i] == '\n' {
+			f.lines = append(f.lines, i+1)
+		}
+	}
+	fs.files = append(fs.files, f)
+	return f
+}
+
+// Reads all of r and adds it under the given name.
+func (fs *FileSet) AddReader(name string, r io.Reader) (*File, error) {
+	contents, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	return fs.AddString(name, string(contents)), nil
+}
+
+// Reads a file from disk and adds it under its path.
+func (fs *FileSet) AddFile(path string) (*File, error) {
+	contents, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return fs.AddString(path, string(contents)), nil
+}
+
+// Reads standard input and adds it under StdinName.
+func (fs *FileSet) AddStdin() (*File, error) {
+	return fs.AddReader(StdinName, os.Stdin)
+}
+
+// Returns the file with the given ID, or nil if there is none.
+func (fs *FileSet) File(id int) *File {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	if id < 0 || id >= len(fs.files) {
+		return nil
+	}
+	return fs.files[id]
+}
+
+// Formats a position in the file with the given ID as "name:line:col".
+func (fs *FileSet) Position(file int, pos Pos) string {
+	name := "<unknown>"
+	if f := fs.File(file); f != nil {
+		name = f.Name
+	}
+	return name + ":" + strconv.Itoa(pos.Line) + ":" + strconv.Itoa(pos.Col)
+}
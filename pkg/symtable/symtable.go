@@ -2,43 +2,48 @@ package symtable
 
 import (
 	"fmt"
-	i "group-11/pkg/inputdata"
-	s "group-11/pkg/scanner"
 )
 
 // Struct for data related to symbol table entries.
 type SymTableEntry struct {
-	EntryType string          // should only ever be var, ref, const, type, proc, stdproc, array, record
-	Name      string          // Name of entry (e.g, x)
-	Tp        PrimitiveType   // primitive type (if applicable)
-	Ctp       ComplexType     // for more complicated types; for instance, some entries contain records
-	Lev       int             // scope Level
-	Val       int             // the Value of (if applicable)
-	Par       []SymTableEntry // list of Parameters in a function (if applicable)
-	Size      int             // Memory required to represent the type (for Bool and Int)
-	Adr       int             // Address in memory
-	Offset    int             // Offset for a given element in a record or array
-	ArrOrRec  string		  // If applicable, is it an array or record
-}
-
-// Enum for the three allowed P0 primitive types.
+	EntryType string           // should only ever be var, ref, const, type, proc, stdproc, stdfunc, array, record
+	Name      string           // Name of entry (e.g, x)
+	Tp        PrimitiveType    // primitive type (if applicable)
+	Ctp       *ComplexType     // for more complicated types; for instance, some entries contain records
+	Lev       int              // scope Level
+	Val       int              // the Value of (if applicable)
+	Par       []*SymTableEntry // list of Parameters in a function (if applicable)
+	Size      int              // Memory required to represent the type (for Bool and Int)
+	Adr       int              // Address in memory
+	Offset    int              // Offset for a given element in a record or array
+	ArrOrRec  string           // If applicable, is it an array or record
+	Addressed bool             // Whether a variable needs an address: it is passed by reference or procedures nested in its own use it
+	Result    *SymTableEntry   // Result type of a function, nil for procedures
+}
+
+// Enum for the allowed P0 primitive types.
 type PrimitiveType string
 
 const (
 	Int      PrimitiveType = "int"
 	Bool     PrimitiveType = "bool"
+	Enum     PrimitiveType = "enum" // An enumeration; its Ctp tells enumerations apart.
 	None     PrimitiveType = "none"
 	Nil      PrimitiveType = ""
 	EmptyInt int           = -9999999999 //Go doesn't have null ints, so for simplicity I just put a big negative number.
 )
 
-// Represents an array or record.
+// Represents an array, record or enumeration. Entries of the same type share
+// one ComplexType, so sizes and offsets computed for one apply to all.
 type ComplexType struct {
-	Fields    []SymTableEntry // used for storing the Fields in a record
-	Base      PrimitiveType   // the Base type of an array
-	Lower     int             // Lower bound of an array
-	Length    int             // Length of an array
-	Size      int             // Size of the type allowed in an array
+	Fields []*SymTableEntry // used for storing the Fields in a record
+	Base   PrimitiveType    // the Base type of an array
+	Elem   *SymTableEntry   // the type of the elements of an array
+	Index  *SymTableEntry   // the enumeration that indexes an array, nil for integer indexes
+	Lower  int              // Lower bound of an array
+	Length int              // Length of an array, or the number of values of an enumeration
+	Size   int              // Size of the type allowed in an array
+	Consts []*SymTableEntry // the constants of an enumeration, in order
 }
 
 // Generates var symbol table entries.
@@ -75,8 +80,22 @@ func Type(Tp PrimitiveType) *SymTableEntry {
 	return e
 }
 
+// Generates enumeration type entries with one constant, valued 0, 1 and so
+// on, for each name.
+func Enumeration(names []string) *SymTableEntry {
+	e := Type(Enum)
+	e.Ctp = &ComplexType{Length: len(names)}
+	for i, name := range names {
+		c := Const(Enum, i)
+		c.Name = name
+		c.Ctp = e.Ctp
+		e.Ctp.Consts = append(e.Ctp.Consts, c)
+	}
+	return e
+}
+
 // Generates proc symbol table entries.
-func Proc(Par []SymTableEntry) *SymTableEntry {
+func Proc(Par []*SymTableEntry) *SymTableEntry {
 	e := &SymTableEntry{}
 	e.EntryType = "proc"
 	e.Tp = None
@@ -84,8 +103,17 @@ func Proc(Par []SymTableEntry) *SymTableEntry {
 	return e
 }
 
+// Generates stdfunc symbol table entries: ord, succ and pred, which take
+// arguments of several types and are checked by the parser.
+func StdFunc() *SymTableEntry {
+	e := &SymTableEntry{}
+	e.EntryType = "stdfunc"
+	e.Tp = None
+	return e
+}
+
 // Generates stdproc symbol table entries.
-func StdProc(Par []SymTableEntry) *SymTableEntry {
+func StdProc(Par []*SymTableEntry) *SymTableEntry {
 	e := &SymTableEntry{}
 	e.EntryType = "stdproc"
 	e.Tp = None
@@ -94,70 +122,111 @@ func StdProc(Par []SymTableEntry) *SymTableEntry {
 }
 
 // Generates record symbol table entries.
-func Record(Fields []SymTableEntry) *SymTableEntry {
+func Record(Fields []*SymTableEntry) *SymTableEntry {
 	e := &SymTableEntry{}
+	e.EntryType = "type"
 	e.ArrOrRec = "record"
-	e.Ctp = ComplexType{}
+	e.Tp = Nil
+	e.Ctp = &ComplexType{}
 	e.Ctp.Fields = Fields
 	return e
 }
 
 // Generates array symbol table entries.
-func Array(Base PrimitiveType, Lower int, Length int) *SymTableEntry {
+func Array(Elem *SymTableEntry, Lower int, Length int) *SymTableEntry {
 	e := &SymTableEntry{}
+	e.EntryType = "type"
 	e.ArrOrRec = "array"
-	e.Ctp = ComplexType{}
-	e.Ctp.Base = Base
+	e.Tp = Nil
+	e.Ctp = &ComplexType{}
+	e.Ctp.Elem = Elem
+	e.Ctp.Base = Elem.Tp
 	e.Ctp.Lower = Lower
 	e.Ctp.Length = Length
 	return e
 }
 
+// Returns an entry of the given kind, var or ref, whose type is described by
+// the type entry tp.
+func Typed(EntryType string, tp *SymTableEntry) *SymTableEntry {
+	e := &SymTableEntry{}
+	e.EntryType = EntryType
+	e.Tp = tp.Tp
+	e.Ctp = tp.Ctp
+	e.ArrOrRec = tp.ArrOrRec
+	e.Size = tp.Size
+	return e
+}
+
+// Reports whether x and y have the same type. Arrays and records are only
+// the same if they come from the same type declaration.
+func SameType(x *SymTableEntry, y *SymTableEntry) bool {
+	return x.Tp == y.Tp && x.ArrOrRec == y.ArrOrRec && x.Ctp == y.Ctp
+}
+
+// The scopes of declarations visible at a point of the program. Each
+// compilation has its own table.
+type SymbolTable struct {
+	scopes [][]*SymTableEntry // Innermost scope first.
+}
+
+// Creates a symbol table with one, outermost, scope.
+func NewSymbolTable() *SymbolTable {
+	return &SymbolTable{scopes: [][]*SymTableEntry{{}}}
+}
+
 // Prints the symbol table to the command line.
-func PrintSymTable(inputData *i.InputData) {
-	fmt.Println(inputData.SymTable)
+func (t *SymbolTable) PrintSymTable() {
+	fmt.Println(t.scopes)
 }
 
-// Add new symbol table entry.
-func NewDecl(inputData *i.InputData, Name string, EntryType string){
-	topLevel := inputData.SymTable[0]
-	Lev := len(topLevel) - 1
+// Adds a new entry with the given Name to the innermost scope. Returns false,
+// without adding it, if the scope already has an entry with that Name.
+func (t *SymbolTable) NewDecl(Name string, entry *SymTableEntry) bool {
+	return t.NewDeclOuter(0, Name, entry)
+}
 
-	for _, entry := range topLevel {
-		if entry.Name == Name {
-			s.PrintError(inputData, "multiple definitions")
-			return
+// Adds a new entry with the given Name to the scope up levels out from the
+// innermost one. Returns false, without adding it, if that scope already has
+// an entry with that Name.
+func (t *SymbolTable) NewDeclOuter(up int, Name string, entry *SymTableEntry) bool {
+	for _, e := range t.scopes[up] {
+		if e.Name == Name {
+			return false
 		}
 	}
 
-	inputData.SymTable[0] = append(inputData.SymTable[0], SymTableEntry{EntryType: EntryType, Name: Name, Lev: Lev, Tp: Int})
+	entry.Name = Name
+	entry.Lev = len(t.scopes) - 1 - up
+	t.scopes[up] = append(t.scopes[up], entry)
+	return true
 }
 
-// Finds a symbol table entry with a given Name.
-func FindInSymTab(inputData *i.InputData, Name string) SymTableEntry {
-	for _, Level := range inputData.SymTable {
+// Finds the symbol table entry with a given Name, searching from the
+// innermost scope outwards. Returns nil if there is none.
+func (t *SymbolTable) FindInSymTab(Name string) *SymTableEntry {
+	for _, Level := range t.scopes {
 		for _, entry := range Level {
 			if entry.Name == Name {
 				return entry
 			}
 		}
 	}
-	s.PrintError(inputData, "undefined identifier " + Name)
-	return SymTableEntry{}
+	return nil
 }
 
 // Each list of lists of entries is a scope level; a new scope can be added
 // by appending a new list of entries.
-func OpenScope(inputData *i.InputData){
-	inputData.SymTable = append([][]SymTableEntry{{}}, inputData.SymTable...)
+func (t *SymbolTable) OpenScope() {
+	t.scopes = append([][]*SymTableEntry{{}}, t.scopes...)
 }
 
 // Simply returns the top-level scope.
-func TopScope(inputData *i.InputData) []SymTableEntry {
-	return inputData.SymTable[0]
+func (t *SymbolTable) TopScope() []*SymTableEntry {
+	return t.scopes[0]
 }
 
 // Close the top level scope by removing the 0th list of lists.
-func CloseScope(inputData *i.InputData){
-	inputData.SymTable = inputData.SymTable[1:]
-}
\ No newline at end of file
+func (t *SymbolTable) CloseScope() {
+	t.scopes = t.scopes[1:]
+}
@@ -0,0 +1,247 @@
+package wasm
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+)
+
+// Instructions by opcode, for decoding.
+var opNames = map[byte]string{}
+
+func init() {
+	for name, op := range ops {
+		opNames[op.code] = name
+	}
+}
+
+// Reads the binary format, remembering the first error.
+type binReader struct {
+	data []byte
+	pos  int
+	err  error
+}
+
+func (r *binReader) fail(msg string) {
+	if r.err == nil {
+		r.err = fmt.Errorf("offset %d: %s", r.pos, msg)
+	}
+}
+
+func (r *binReader) byte() byte {
+	if r.err != nil || r.pos >= len(r.data) {
+		r.fail("unexpected end")
+		return 0
+	}
+	r.pos += 1
+	return r.data[r.pos-1]
+}
+
+// Reads an unsigned LEB128 number.
+func (r *binReader) u32() uint32 {
+	v, shift := uint32(0), uint(0)
+	for {
+		c := r.byte()
+		if r.err != nil {
+			return 0
+		}
+		v |= uint32(c&0x7F) << shift
+		if c&0x80 == 0 {
+			return v
+		}
+		if shift += 7; shift > 28 {
+			r.fail("integer too long")
+			return 0
+		}
+	}
+}
+
+// Reads a signed LEB128 number.
+func (r *binReader) s32() int32 {
+	v, shift := int32(0), uint(0)
+	for {
+		c := r.byte()
+		if r.err != nil {
+			return 0
+		}
+		v |= int32(c&0x7F) << shift
+		shift += 7
+		if c&0x80 == 0 {
+			if shift < 32 && c&0x40 != 0 {
+				v |= -1 << shift
+			}
+			return v
+		}
+		if shift > 28 {
+			r.fail("integer too long")
+			return 0
+		}
+	}
+}
+
+// Reads a count of items of at least one byte each.
+func (r *binReader) count() int {
+	n := int(r.u32())
+	if r.err == nil && n > len(r.data)-r.pos {
+		r.fail("count larger than the module")
+		return 0
+	}
+	return n
+}
+
+func (r *binReader) name() string {
+	n := r.count()
+	if r.err != nil {
+		return ""
+	}
+	r.pos += n
+	return string(r.data[r.pos-n : r.pos])
+}
+
+func (r *binReader) types() []ValType {
+	tps := []ValType{}
+	for n := r.count(); n > 0; n-- {
+		if tp := ValType(r.byte()); tp != I32 {
+			r.fail("only i32 values are supported")
+		} else {
+			tps = append(tps, tp)
+		}
+	}
+	return tps
+}
+
+// Reads a module in the binary format, as far as Encode writes it. Functions
+// are named after their exports, or numbered.
+func Decode(data []byte) (*Module, error) {
+	if len(data) < 8 || string(data[:8]) != "\x00asm\x01\x00\x00\x00" {
+		return nil, errors.New("not a WebAssembly module")
+	}
+	r := &binReader{data: data, pos: 8}
+	m := &Module{Memory: -1, Start: -1}
+	types := []FuncType{}
+	funcTypes := []uint32{}
+	for r.err == nil && r.pos < len(data) {
+		id := r.byte()
+		size := r.count()
+		end := r.pos + size
+		switch id {
+		case secType:
+			for n := r.count(); n > 0; n-- {
+				if r.byte() != 0x60 {
+					r.fail("function type expected")
+				}
+				types = append(types, FuncType{Params: r.types(), Results: r.types()})
+			}
+		case secImport:
+			for n := r.count(); n > 0; n-- {
+				imp := Import{Module: r.name(), Name: r.name()}
+				imp.Func = "$" + imp.Name
+				if r.byte() != 0x00 {
+					r.fail("only functions can be imported")
+				}
+				if t := int(r.u32()); t < len(types) {
+					imp.Type = types[t]
+				} else {
+					r.fail("unknown type")
+				}
+				m.Imports = append(m.Imports, imp)
+			}
+		case secFunction:
+			for n := r.count(); n > 0; n-- {
+				funcTypes = append(funcTypes, r.u32())
+			}
+		case secMemory:
+			if r.count() != 1 || r.byte() != 0x00 {
+				r.fail("one memory without a maximum expected")
+			}
+			if m.Memory = int(r.u32()); m.Memory > MaxPages {
+				r.fail("memory of more than " + strconv.Itoa(MaxPages) + " pages")
+			}
+		case secGlobal:
+			for n := r.count(); n > 0; n-- {
+				g := Global{Name: "$g" + strconv.Itoa(len(m.Globals)), Type: ValType(r.byte()), Mutable: r.byte() == 1}
+				if r.byte() != ops["i32.const"].code {
+					r.fail("constant initializer expected")
+				}
+				g.Init = r.s32()
+				if r.byte() != ops["end"].code {
+					r.fail("end of initializer expected")
+				}
+				m.Globals = append(m.Globals, g)
+			}
+		case secExport:
+			for n := r.count(); n > 0; n-- {
+				m.Exports = append(m.Exports, Export{Name: r.name(), Kind: r.byte(), Index: int(r.u32())})
+			}
+		case secStart:
+			m.Start = int(r.u32())
+		case secCode:
+			if r.count() != len(funcTypes) {
+				r.fail("function and code sections differ")
+			}
+			for i := 0; i < len(funcTypes) && r.err == nil; i++ {
+				size := r.count()
+				fn := &Func{Name: "$f" + strconv.Itoa(len(m.Imports)+i), Names: map[string]int{}}
+				if t := int(funcTypes[i]); t < len(types) {
+					fn.Type = types[t]
+				} else {
+					r.fail("unknown type")
+				}
+				decodeBody(r, fn, r.pos+size)
+				m.Funcs = append(m.Funcs, fn)
+			}
+		default:
+			r.fail("unsupported section " + strconv.Itoa(int(id)))
+		}
+		if r.err == nil && r.pos != end {
+			r.fail("section size does not match its content")
+		}
+	}
+	if r.err != nil {
+		return nil, r.err
+	}
+	for _, e := range m.Exports {
+		if e.Kind == ExportFunc && e.Index >= len(m.Imports) && e.Index < len(m.Imports)+len(m.Funcs) {
+			m.Funcs[e.Index-len(m.Imports)].Name = "$" + e.Name
+		}
+	}
+	return m, nil
+}
+
+// Reads the locals and the instructions of a function up to end, without the
+// implicit end of the body.
+func decodeBody(r *binReader, fn *Func, end int) {
+	for n := r.count(); n > 0; n-- {
+		k := r.count()
+		tp := ValType(r.byte())
+		for ; k > 0; k-- {
+			fn.Locals = append(fn.Locals, tp)
+		}
+	}
+	for r.err == nil && r.pos < end {
+		name, ok := opNames[r.byte()]
+		if !ok {
+			r.pos -= 1
+			r.fail("unsupported instruction")
+			return
+		}
+		in := Instr{Op: name}
+		switch ops[name].imm {
+		case immConst:
+			in.Arg = r.s32()
+		case immLocal, immGlobal, immFunc, immDepth:
+			in.Arg = int32(r.u32())
+		case immBlock:
+			in.Block = ValType(r.byte())
+		case immMem:
+			r.u32()
+			in.Arg = int32(r.u32())
+		}
+		fn.Body = append(fn.Body, in)
+	}
+	if n := len(fn.Body); n == 0 || fn.Body[n-1].Op != "end" {
+		r.fail("function body must end with end")
+		return
+	}
+	fn.Body = fn.Body[:len(fn.Body)-1]
+}
@@ -0,0 +1,32 @@
+package wasm
+
+import (
+	"strings"
+	"testing"
+)
+
+// Memory sizes are checked before Instantiate allocates the memory.
+func TestDecodeMemory(t *testing.T) {
+	tests := []struct {
+		data string
+		ok   bool
+	}{
+		{"\x00asm\x01\x00\x00\x00\x05\x03\x01\x00\x01", true},
+		{"\x00asm\x01\x00\x00\x00\x05\x05\x01\x00\x80\x80\x04", true},          // 65536 pages
+		{"\x00asm\x01\x00\x00\x00\x05\x05\x01\x00\x81\x80\x04", false},         // 65537 pages
+		{"\x00asm\x01\x00\x00\x00\x05\x07\x01\x00\xff\xff\xff\xff\x0f", false}, // 2^32-1 pages
+	}
+	for _, test := range tests {
+		m, err := Decode([]byte(test.data))
+		if (err == nil) != test.ok {
+			t.Errorf("%q: error %v", test.data, err)
+		} else if err != nil && !strings.Contains(err.Error(), "pages") {
+			t.Errorf("%q: error %v, want one about the number of pages", test.data, err)
+		} else if err == nil && len(Validate(m)) > 0 {
+			t.Errorf("%q: %v", test.data, Validate(m)[0])
+		}
+	}
+	if errs := Validate(&Module{Memory: MaxPages + 1, Start: -1}); len(errs) != 1 {
+		t.Errorf("Validate found %d errors in a memory of %d pages", len(errs), MaxPages+1)
+	}
+}
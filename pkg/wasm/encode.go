@@ -0,0 +1,176 @@
+package wasm
+
+// Section IDs of the binary format.
+const (
+	secType     = 1
+	secImport   = 2
+	secFunction = 3
+	secMemory   = 5
+	secGlobal   = 6
+	secExport   = 7
+	secStart    = 8
+	secCode     = 10
+)
+
+// Appends v in unsigned LEB128.
+func appendU32(b []byte, v uint32) []byte {
+	for v >= 0x80 {
+		b = append(b, byte(v)|0x80)
+		v >>= 7
+	}
+	return append(b, byte(v))
+}
+
+// Appends v in signed LEB128.
+func appendS32(b []byte, v int32) []byte {
+	for {
+		c := byte(v & 0x7F)
+		v >>= 7
+		if (v == 0 && c&0x40 == 0) || (v == -1 && c&0x40 != 0) {
+			return append(b, c)
+		}
+		b = append(b, c|0x80)
+	}
+}
+
+// Appends a name as its length followed by its UTF-8 bytes.
+func appendName(b []byte, s string) []byte {
+	return append(appendU32(b, uint32(len(s))), s...)
+}
+
+// Appends a section with its ID and size.
+func appendSection(b []byte, id byte, content []byte) []byte {
+	b = append(b, id)
+	b = appendU32(b, uint32(len(content)))
+	return append(b, content...)
+}
+
+// Appends a vector of value types.
+func appendTypes(b []byte, tps []ValType) []byte {
+	b = appendU32(b, uint32(len(tps)))
+	for _, tp := range tps {
+		b = append(b, byte(tp))
+	}
+	return b
+}
+
+// Function types of a module, each listed once.
+type typeSection []FuncType
+
+// Returns the index of t, adding it if it is new.
+func (ts *typeSection) index(t FuncType) uint32 {
+	for i, u := range *ts {
+		if u.equal(t) {
+			return uint32(i)
+		}
+	}
+	*ts = append(*ts, t)
+	return uint32(len(*ts) - 1)
+}
+
+// Encodes a module in the binary format.
+func Encode(m *Module) []byte {
+	types := typeSection{}
+	imports := appendU32(nil, uint32(len(m.Imports)))
+	for _, imp := range m.Imports {
+		imports = appendName(imports, imp.Module)
+		imports = appendName(imports, imp.Name)
+		imports = append(imports, 0x00)
+		imports = appendU32(imports, types.index(imp.Type))
+	}
+	funcs := appendU32(nil, uint32(len(m.Funcs)))
+	code := appendU32(nil, uint32(len(m.Funcs)))
+	for _, fn := range m.Funcs {
+		funcs = appendU32(funcs, types.index(fn.Type))
+		body := encodeBody(fn)
+		code = appendU32(code, uint32(len(body)))
+		code = append(code, body...)
+	}
+
+	out := []byte{0x00, 'a', 's', 'm', 0x01, 0x00, 0x00, 0x00}
+	typeSec := appendU32(nil, uint32(len(types)))
+	for _, t := range types {
+		typeSec = append(typeSec, 0x60)
+		typeSec = appendTypes(typeSec, t.Params)
+		typeSec = appendTypes(typeSec, t.Results)
+	}
+	out = appendSection(out, secType, typeSec)
+	if len(m.Imports) > 0 {
+		out = appendSection(out, secImport, imports)
+	}
+	out = appendSection(out, secFunction, funcs)
+	if m.Memory >= 0 {
+		out = appendSection(out, secMemory, appendU32([]byte{1, 0x00}, uint32(m.Memory)))
+	}
+	if len(m.Globals) > 0 {
+		globals := appendU32(nil, uint32(len(m.Globals)))
+		for _, g := range m.Globals {
+			mut := byte(0)
+			if g.Mutable {
+				mut = 1
+			}
+			globals = append(globals, byte(g.Type), mut, ops["i32.const"].code)
+			globals = appendS32(globals, g.Init)
+			globals = append(globals, ops["end"].code)
+		}
+		out = appendSection(out, secGlobal, globals)
+	}
+	if len(m.Exports) > 0 {
+		exports := appendU32(nil, uint32(len(m.Exports)))
+		for _, e := range m.Exports {
+			exports = appendName(exports, e.Name)
+			exports = append(exports, e.Kind)
+			exports = appendU32(exports, uint32(e.Index))
+		}
+		out = appendSection(out, secExport, exports)
+	}
+	if m.Start >= 0 {
+		out = appendSection(out, secStart, appendU32(nil, uint32(m.Start)))
+	}
+	return appendSection(out, secCode, code)
+}
+
+// Encodes the locals and the instructions of a function, which end with an
+// implicit end.
+func encodeBody(fn *Func) []byte {
+	// Locals are written as runs of the same type.
+	runs := [][2]int{}
+	for _, tp := range fn.Locals {
+		if n := len(runs); n > 0 && runs[n-1][1] == int(tp) {
+			runs[n-1][0] += 1
+		} else {
+			runs = append(runs, [2]int{1, int(tp)})
+		}
+	}
+	b := appendU32(nil, uint32(len(runs)))
+	for _, r := range runs {
+		b = appendU32(b, uint32(r[0]))
+		b = append(b, byte(r[1]))
+	}
+	for _, in := range fn.Body {
+		op := ops[in.Op]
+		b = append(b, op.code)
+		switch op.imm {
+		case immConst:
+			b = appendS32(b, in.Arg)
+		case immLocal, immGlobal, immFunc, immDepth:
+			b = appendU32(b, uint32(in.Arg))
+		case immBlock:
+			b = append(b, byte(in.Block))
+		case immMem:
+			// i32 accesses are aligned to 4 bytes.
+			b = appendU32(b, 2)
+			b = appendU32(b, uint32(in.Arg))
+		}
+	}
+	return append(b, ops["end"].code)
+}
+
+// Reads a module in the text format and encodes it in the binary format.
+func Assemble(text string) ([]byte, error) {
+	m, err := Parse(text)
+	if err != nil {
+		return nil, err
+	}
+	return Encode(m), nil
+}
@@ -0,0 +1,391 @@
+package wasm
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+)
+
+// A function of the host that a module imports. It returns the results or
+// an error that stops the module.
+type HostFunc func(args []int32) ([]int32, error)
+
+// An error that stopped a module, with the function and the line of the text
+// the instruction came from, 0 if the module was not read from text.
+type Trap struct {
+	Func string
+	Line int
+	Msg  string
+}
+
+func (t *Trap) Error() string {
+	if t.Line > 0 {
+		return fmt.Sprintf("line %d: %s: %s", t.Line, t.Func, t.Msg)
+	}
+	return t.Func + ": " + t.Msg
+}
+
+// A module ready to run, with its memory and globals.
+type Instance struct {
+	Module   *Module
+	Memory   []byte
+	Globals  []int32
+	MaxDepth int // Most nested calls.
+	MaxSteps int // Most instructions executed, 0 for no limit.
+	Steps    int // Instructions executed.
+	host     []HostFunc
+	ends     [][]int // Index of the matching end of every block, loop, if and else, per function.
+	elses    [][]int // Index of the else of every if, -1 if there is none, per function.
+}
+
+// Checks a module and sets up its memory and globals. Every import must be
+// in imports under "module.name".
+func Instantiate(m *Module, imports map[string]HostFunc) (*Instance, error) {
+	if errs := Validate(m); len(errs) > 0 {
+		return nil, errs[0]
+	}
+	inst := &Instance{Module: m, MaxDepth: 10000}
+	for _, imp := range m.Imports {
+		f, ok := imports[imp.Module+"."+imp.Name]
+		if !ok {
+			return nil, errors.New("unknown import " + imp.Module + "." + imp.Name)
+		}
+		inst.host = append(inst.host, f)
+	}
+	if m.Start >= 0 {
+		if m.Start >= len(m.Imports)+len(m.Funcs) {
+			return nil, errors.New("unknown start function")
+		}
+		if t := m.FuncType(m.Start); len(t.Params) > 0 || len(t.Results) > 0 {
+			return nil, errors.New("start function must have no parameters and results")
+		}
+	}
+	if m.Memory > 0 {
+		inst.Memory = make([]byte, m.Memory*65536)
+	}
+	for _, g := range m.Globals {
+		inst.Globals = append(inst.Globals, g.Init)
+	}
+	for _, fn := range m.Funcs {
+		ends, elses := make([]int, len(fn.Body)), make([]int, len(fn.Body))
+		open := []int{}
+		for pc, in := range fn.Body {
+			switch in.Op {
+			case "block", "loop", "if":
+				open = append(open, pc)
+				elses[pc] = -1
+			case "else":
+				elses[open[len(open)-1]] = pc
+				open = append(open, pc)
+			case "end":
+				for {
+					b := open[len(open)-1]
+					open = open[:len(open)-1]
+					ends[b] = pc
+					if fn.Body[b].Op != "else" {
+						break
+					}
+				}
+			}
+		}
+		inst.ends = append(inst.ends, ends)
+		inst.elses = append(inst.elses, elses)
+	}
+	return inst, nil
+}
+
+// Runs the start function, if there is one.
+func (inst *Instance) Start() error {
+	if inst.Module.Start < 0 {
+		return nil
+	}
+	_, err := inst.Call(inst.Module.Start)
+	return err
+}
+
+// Calls the function with the given index and returns its results.
+func (inst *Instance) Call(index int, args ...int32) ([]int32, error) {
+	if index < 0 || index >= len(inst.Module.Imports)+len(inst.Module.Funcs) {
+		return nil, errors.New("unknown function")
+	}
+	if t := inst.Module.FuncType(index); len(args) != len(t.Params) {
+		return nil, fmt.Errorf("%s needs %d arguments", inst.Module.FuncName(index), len(t.Params))
+	}
+	return inst.call(index, args, 0)
+}
+
+// A block being executed.
+type label struct {
+	start  int // Index of the block, loop or if.
+	height int // Height of the operand stack when the block was entered.
+	arity  int // Values a branch to the label keeps.
+	loop   bool
+}
+
+// Executes a function with its arguments at the given depth of calls.
+func (inst *Instance) call(index int, args []int32, depth int) ([]int32, error) {
+	m := inst.Module
+	if index < len(m.Imports) {
+		return inst.host[index](args)
+	}
+	fi := index - len(m.Imports)
+	fn := m.Funcs[fi]
+	ends, elses := inst.ends[fi], inst.elses[fi]
+	locals := make([]int32, len(args)+len(fn.Locals))
+	copy(locals, args)
+	stack := make([]int32, 0, 16)
+	labels := []label{}
+	pc := 0
+	trap := func(msg string) error {
+		return &Trap{Func: fn.Name, Line: fn.Body[pc].Line, Msg: msg}
+	}
+	pop := func() int32 {
+		x := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		return x
+	}
+	address := func(offset int32) (int, bool) {
+		a := int64(uint32(pop())) + int64(uint32(offset))
+		return int(a), a+4 <= int64(len(inst.Memory))
+	}
+	results := func() []int32 {
+		n := len(fn.Type.Results)
+		return append([]int32{}, stack[len(stack)-n:]...)
+	}
+	// Branches to the label depth levels out, reporting whether that is the
+	// function body, which returns.
+	branch := func(depth int) bool {
+		if depth >= len(labels) {
+			return true
+		}
+		l := labels[len(labels)-1-depth]
+		stack = append(stack[:l.height], stack[len(stack)-l.arity:]...)
+		if l.loop {
+			labels = labels[:len(labels)-depth]
+			pc = l.start
+		} else {
+			labels = labels[:len(labels)-1-depth]
+			pc = ends[l.start]
+		}
+		return false
+	}
+	for ; pc < len(fn.Body); pc++ {
+		in := fn.Body[pc]
+		if inst.MaxSteps > 0 && inst.Steps >= inst.MaxSteps {
+			return nil, trap("too many steps")
+		}
+		inst.Steps += 1
+		switch in.Op {
+		case "unreachable":
+			return nil, trap("unreachable")
+		case "nop":
+		case "block", "loop":
+			l := label{start: pc, height: len(stack), loop: in.Op == "loop"}
+			if in.Block != NoType && !l.loop {
+				l.arity = 1
+			}
+			labels = append(labels, l)
+		case "if":
+			l := label{start: pc, height: len(stack) - 1}
+			if in.Block != NoType {
+				l.arity = 1
+			}
+			labels = append(labels, l)
+			if pop() == 0 {
+				if elses[pc] >= 0 {
+					pc = elses[pc]
+				} else {
+					pc = ends[pc] - 1
+				}
+			}
+		case "else":
+			// The then branch is done.
+			pc = ends[pc] - 1
+		case "end":
+			labels = labels[:len(labels)-1]
+		case "br":
+			if branch(int(in.Arg)) {
+				return results(), nil
+			}
+		case "br_if":
+			if pop() != 0 && branch(int(in.Arg)) {
+				return results(), nil
+			}
+		case "return":
+			return results(), nil
+		case "call":
+			if depth >= inst.MaxDepth {
+				return nil, trap("call stack exhausted")
+			}
+			n := len(m.FuncType(int(in.Arg)).Params)
+			args := append([]int32{}, stack[len(stack)-n:]...)
+			stack = stack[:len(stack)-n]
+			res, err := inst.call(int(in.Arg), args, depth+1)
+			if err != nil {
+				return nil, err
+			}
+			stack = append(stack, res...)
+		case "drop":
+			pop()
+		case "select":
+			c, y := pop(), pop()
+			if c == 0 {
+				stack[len(stack)-1] = y
+			}
+		case "local.get":
+			stack = append(stack, locals[in.Arg])
+		case "local.set":
+			locals[in.Arg] = pop()
+		case "local.tee":
+			locals[in.Arg] = stack[len(stack)-1]
+		case "global.get":
+			stack = append(stack, inst.Globals[in.Arg])
+		case "global.set":
+			inst.Globals[in.Arg] = pop()
+		case "i32.load":
+			a, ok := address(in.Arg)
+			if !ok {
+				return nil, trap("out of bounds memory access")
+			}
+			stack = append(stack, int32(binary.LittleEndian.Uint32(inst.Memory[a:])))
+		case "i32.store":
+			x := pop()
+			a, ok := address(in.Arg)
+			if !ok {
+				return nil, trap("out of bounds memory access")
+			}
+			binary.LittleEndian.PutUint32(inst.Memory[a:], uint32(x))
+		case "i32.const":
+			stack = append(stack, in.Arg)
+		case "i32.eqz":
+			stack[len(stack)-1] = boolI32(stack[len(stack)-1] == 0)
+		default:
+			y := pop()
+			x := pop()
+			z, msg := binaryOp(in.Op, x, y)
+			if msg != "" {
+				return nil, trap(msg)
+			}
+			stack = append(stack, z)
+		}
+	}
+	return results(), nil
+}
+
+func boolI32(b bool) int32 {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+// Returns the result of a binary i32 instruction, or why it traps.
+func binaryOp(op string, x, y int32) (int32, string) {
+	ux, uy := uint32(x), uint32(y)
+	switch op {
+	case "i32.eq":
+		return boolI32(x == y), ""
+	case "i32.ne":
+		return boolI32(x != y), ""
+	case "i32.lt_s":
+		return boolI32(x < y), ""
+	case "i32.lt_u":
+		return boolI32(ux < uy), ""
+	case "i32.gt_s":
+		return boolI32(x > y), ""
+	case "i32.gt_u":
+		return boolI32(ux > uy), ""
+	case "i32.le_s":
+		return boolI32(x <= y), ""
+	case "i32.le_u":
+		return boolI32(ux <= uy), ""
+	case "i32.ge_s":
+		return boolI32(x >= y), ""
+	case "i32.ge_u":
+		return boolI32(ux >= uy), ""
+	case "i32.add":
+		return x + y, ""
+	case "i32.sub":
+		return x - y, ""
+	case "i32.mul":
+		return x * y, ""
+	case "i32.div_s", "i32.div_u", "i32.rem_s", "i32.rem_u":
+		if y == 0 {
+			return 0, "integer divide by zero"
+		}
+		switch op {
+		case "i32.div_s":
+			if x == math.MinInt32 && y == -1 {
+				return 0, "integer overflow"
+			}
+			return x / y, ""
+		case "i32.div_u":
+			return int32(ux / uy), ""
+		case "i32.rem_s":
+			if y == -1 {
+				return 0, ""
+			}
+			return x % y, ""
+		}
+		return int32(ux % uy), ""
+	case "i32.and":
+		return x & y, ""
+	case "i32.or":
+		return x | y, ""
+	case "i32.xor":
+		return x ^ y, ""
+	case "i32.shl":
+		return x << (uy & 31), ""
+	case "i32.shr_s":
+		return x >> (uy & 31), ""
+	case "i32.shr_u":
+		return int32(ux >> (uy & 31)), ""
+	}
+	return 0, "unsupported instruction " + op
+}
+
+// Returns the P0lib imports of the code generator: read takes the next
+// whitespace separated integer of r, 0 at its end; write writes " %d" and
+// writeln a newline to w. Output is buffered; it is written before every
+// read and by the returned flush.
+func P0lib(r io.Reader, w io.Writer) (imports map[string]HostFunc, flush func() error) {
+	in, out := bufio.NewReader(r), bufio.NewWriter(w)
+	imports = map[string]HostFunc{
+		"P0lib.read": func(args []int32) ([]int32, error) {
+			if err := out.Flush(); err != nil {
+				return nil, err
+			}
+			var x int32
+			if _, err := fmt.Fscan(in, &x); err != nil {
+				return []int32{0}, nil
+			}
+			return []int32{x}, nil
+		},
+		"P0lib.write": func(args []int32) ([]int32, error) {
+			fmt.Fprintf(out, " %d", args[0])
+			return nil, nil
+		},
+		"P0lib.writeln": func(args []int32) ([]int32, error) {
+			out.WriteString("\n")
+			return nil, nil
+		},
+	}
+	return imports, out.Flush
+}
+
+// Runs a module generated for P0 with its input from r and output to w.
+func Run(m *Module, r io.Reader, w io.Writer) error {
+	imports, flush := P0lib(r, w)
+	inst, err := Instantiate(m, imports)
+	if err != nil {
+		return err
+	}
+	err = inst.Start()
+	if ferr := flush(); err == nil {
+		err = ferr
+	}
+	return err
+}
@@ -0,0 +1,193 @@
+// WebAssembly modules: the text format the code generator emits is read into
+// a Module, which can be encoded in the binary format that engines load.
+package wasm
+
+import (
+	"strconv"
+)
+
+// Type of a WebAssembly value. P0 only needs i32.
+type ValType byte
+
+const (
+	NoType ValType = 0x40 // Result type of blocks without a result.
+	I32    ValType = 0x7F
+)
+
+// Parameter and result types of a function.
+type FuncType struct {
+	Params  []ValType
+	Results []ValType
+}
+
+// Reports whether two function types are the same.
+func (t FuncType) equal(u FuncType) bool {
+	if len(t.Params) != len(u.Params) || len(t.Results) != len(u.Results) {
+		return false
+	}
+	for i := range t.Params {
+		if t.Params[i] != u.Params[i] {
+			return false
+		}
+	}
+	for i := range t.Results {
+		if t.Results[i] != u.Results[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// A function imported from the host, such as P0lib.write.
+type Import struct {
+	Module string
+	Name   string
+	Func   string // Name of the function in the module, such as "$write".
+	Type   FuncType
+}
+
+// A function defined in the module.
+type Func struct {
+	Name   string
+	Type   FuncType
+	Locals []ValType      // Types of the locals declared after the parameters.
+	Names  map[string]int // Index of every named parameter and local.
+	Body   []Instr
+	Line   int // Line of the text the function starts on.
+}
+
+// A global variable.
+type Global struct {
+	Name    string
+	Type    ValType
+	Mutable bool
+	Init    int32
+}
+
+// Kinds of exported definitions.
+const (
+	ExportFunc   byte = 0
+	ExportMemory byte = 2
+	ExportGlobal byte = 3
+)
+
+// A definition made visible to the host under a name.
+type Export struct {
+	Name  string
+	Kind  byte
+	Index int
+}
+
+// One instruction of a function body.
+type Instr struct {
+	Op    string  // Name of the instruction, such as "i32.add".
+	Arg   int32   // Constant, index, branch depth or memory offset, if the instruction has one.
+	Block ValType // Result type of block, loop and if.
+	Line  int     // Line of the text the instruction was read from.
+}
+
+// Most 64 KiB pages a memory can have.
+const MaxPages = 65536
+
+// A WebAssembly module. Functions are numbered with the imports first.
+type Module struct {
+	Imports []Import
+	Funcs   []*Func
+	Globals []Global
+	Memory  int // Initial size of the memory in 64 KiB pages, -1 if there is none.
+	Start   int // Index of the start function, -1 if there is none.
+	Exports []Export
+}
+
+// Returns the type of the function with the given index.
+func (m *Module) FuncType(index int) FuncType {
+	if index < len(m.Imports) {
+		return m.Imports[index].Type
+	}
+	return m.Funcs[index-len(m.Imports)].Type
+}
+
+// Returns the name of the function with the given index.
+func (m *Module) FuncName(index int) string {
+	if index < len(m.Imports) {
+		return m.Imports[index].Func
+	}
+	return m.Funcs[index-len(m.Imports)].Name
+}
+
+// An error in the text of a module.
+type Error struct {
+	Line int
+	Msg  string
+}
+
+func (err *Error) Error() string {
+	return "line " + strconv.Itoa(err.Line) + ": " + err.Msg
+}
+
+// Immediate operands of instructions.
+const (
+	immNone   = iota
+	immConst  // i32 constant
+	immLocal  // local index
+	immGlobal // global index
+	immFunc   // function index
+	immDepth  // branch depth
+	immBlock  // optional (result t)
+	immMem    // optional offset= and align=
+)
+
+// Opcode and immediate operand of an instruction.
+type opInfo struct {
+	code byte
+	imm  int
+}
+
+// Instructions that can be read and encoded.
+var ops = map[string]opInfo{
+	"unreachable": {0x00, immNone},
+	"nop":         {0x01, immNone},
+	"block":       {0x02, immBlock},
+	"loop":        {0x03, immBlock},
+	"if":          {0x04, immBlock},
+	"else":        {0x05, immNone},
+	"end":         {0x0B, immNone},
+	"br":          {0x0C, immDepth},
+	"br_if":       {0x0D, immDepth},
+	"return":      {0x0F, immNone},
+	"call":        {0x10, immFunc},
+	"drop":        {0x1A, immNone},
+	"select":      {0x1B, immNone},
+	"local.get":   {0x20, immLocal},
+	"local.set":   {0x21, immLocal},
+	"local.tee":   {0x22, immLocal},
+	"global.get":  {0x23, immGlobal},
+	"global.set":  {0x24, immGlobal},
+	"i32.load":    {0x28, immMem},
+	"i32.store":   {0x36, immMem},
+	"i32.const":   {0x41, immConst},
+	"i32.eqz":     {0x45, immNone},
+	"i32.eq":      {0x46, immNone},
+	"i32.ne":      {0x47, immNone},
+	"i32.lt_s":    {0x48, immNone},
+	"i32.lt_u":    {0x49, immNone},
+	"i32.gt_s":    {0x4A, immNone},
+	"i32.gt_u":    {0x4B, immNone},
+	"i32.le_s":    {0x4C, immNone},
+	"i32.le_u":    {0x4D, immNone},
+	"i32.ge_s":    {0x4E, immNone},
+	"i32.ge_u":    {0x4F, immNone},
+	"i32.add":     {0x6A, immNone},
+	"i32.sub":     {0x6B, immNone},
+	"i32.mul":     {0x6C, immNone},
+	"i32.div_s":   {0x6D, immNone},
+	"i32.div_u":   {0x6E, immNone},
+	"i32.rem_s":   {0x6F, immNone},
+	"i32.rem_u":   {0x70, immNone},
+	"i32.and":     {0x71, immNone},
+	"i32.or":      {0x72, immNone},
+	"i32.xor":     {0x73, immNone},
+	"i32.shl":     {0x74, immNone},
+	"i32.shr_s":   {0x75, immNone},
+	"i32.shr_u":   {0x76, immNone},
+}
@@ -0,0 +1,510 @@
+package wasm
+
+import (
+	"strconv"
+	"strings"
+)
+
+// An atom, a string or a parenthesized list of the text format.
+type sexpr struct {
+	atom   string
+	quoted bool // The atom was written as a string.
+	list   []*sexpr
+	isList bool
+	line   int
+}
+
+// Reports whether x is a list starting with the keyword head.
+func (x *sexpr) is(head string) bool {
+	return x.isList && len(x.list) > 0 && !x.list[0].isList && !x.list[0].quoted && x.list[0].atom == head
+}
+
+// Splits text into its top-level expressions. Comments are skipped.
+func read(text string) ([]*sexpr, error) {
+	top := &sexpr{isList: true}
+	stack := []*sexpr{top}
+	line := 1
+	i := 0
+	for i < len(text) {
+		c := text[i]
+		cur := stack[len(stack)-1]
+		switch {
+		case c == '\n':
+			line += 1
+			i += 1
+		case c == ' ' || c == '\t' || c == '\r':
+			i += 1
+		case strings.HasPrefix(text[i:], ";;"):
+			for i < len(text) && text[i] != '\n' {
+				i += 1
+			}
+		case strings.HasPrefix(text[i:], "(;"):
+			end := strings.Index(text[i:], ";)")
+			if end < 0 {
+				return nil, &Error{line, "comment not terminated"}
+			}
+			line += strings.Count(text[i:i+end], "\n")
+			i += end + 2
+		case c == '(':
+			x := &sexpr{isList: true, line: line}
+			cur.list = append(cur.list, x)
+			stack = append(stack, x)
+			i += 1
+		case c == ')':
+			if len(stack) == 1 {
+				return nil, &Error{line, "unexpected )"}
+			}
+			stack = stack[:len(stack)-1]
+			i += 1
+		case c == '"':
+			j := i + 1
+			for j < len(text) && text[j] != '"' && text[j] != '\n' {
+				if text[j] == '\\' {
+					j += 1
+				}
+				j += 1
+			}
+			if j >= len(text) || text[j] != '"' {
+				return nil, &Error{line, "string not terminated"}
+			}
+			s, err := strconv.Unquote(text[i : j+1])
+			if err != nil {
+				return nil, &Error{line, "malformed string " + text[i:j+1]}
+			}
+			cur.list = append(cur.list, &sexpr{atom: s, quoted: true, line: line})
+			i = j + 1
+		default:
+			j := i
+			for j < len(text) && !strings.ContainsRune(" \t\r\n()\";", rune(text[j])) {
+				j += 1
+			}
+			cur.list = append(cur.list, &sexpr{atom: text[i:j], line: line})
+			i = j
+		}
+	}
+	if len(stack) > 1 {
+		return nil, &Error{stack[len(stack)-1].line, "( not closed"}
+	}
+	return top.list, nil
+}
+
+// Reads modules written in the text format. Function bodies are sequences of
+// plain instructions, as the code generator writes them.
+type textReader struct {
+	m       *Module
+	funcs   map[string]int
+	globals map[string]int
+}
+
+// Reads a module in the text format.
+func Parse(text string) (*Module, error) {
+	tops, err := read(text)
+	if err != nil {
+		return nil, err
+	}
+	if len(tops) != 1 || !tops[0].is("module") {
+		line := 1
+		if len(tops) > 0 {
+			line = tops[0].line
+		}
+		return nil, &Error{line, "(module ...) expected"}
+	}
+	r := &textReader{m: &Module{Memory: -1, Start: -1}, funcs: map[string]int{}, globals: map[string]int{}}
+	fields := tops[0].list[1:]
+
+	// Functions can be used before they are defined, so their names and types
+	// are collected first.
+	bodies := map[*Func]*sexpr{}
+	for _, f := range fields {
+		if f.is("import") {
+			if err := r.importField(f); err != nil {
+				return nil, err
+			}
+		}
+	}
+	for _, f := range fields {
+		if f.is("func") {
+			fn, err := r.funcHeader(f)
+			if err != nil {
+				return nil, err
+			}
+			bodies[fn] = f
+		} else if f.is("global") {
+			if err := r.globalField(f); err != nil {
+				return nil, err
+			}
+		} else if f.is("memory") {
+			if err := r.memoryField(f); err != nil {
+				return nil, err
+			}
+		}
+	}
+	for _, f := range fields {
+		var err error
+		switch {
+		case f.is("import"), f.is("func"), f.is("global"), f.is("memory"):
+		case f.is("start"):
+			err = r.startField(f)
+		case f.is("export"):
+			err = r.exportField(f)
+		default:
+			err = &Error{f.line, "unknown module field"}
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	for _, fn := range r.m.Funcs {
+		if err := r.body(fn, bodies[fn]); err != nil {
+			return nil, err
+		}
+	}
+	return r.m, nil
+}
+
+// Returns the name of a definition if x is one, such as $program, or "".
+func name(x *sexpr) string {
+	if !x.isList && !x.quoted && strings.HasPrefix(x.atom, "$") && len(x.atom) > 1 {
+		return x.atom
+	}
+	return ""
+}
+
+// Reads a value type.
+func valType(x *sexpr) (ValType, error) {
+	if !x.isList && x.atom == "i32" {
+		return I32, nil
+	}
+	return 0, &Error{x.line, "i32 expected"}
+}
+
+// Reads (param ...) and (result ...) lists starting at items[i] into t, and
+// records the names of parameters in names. Returns the index of the first
+// item that is not one of them.
+func typeUse(items []*sexpr, i int, t *FuncType, names map[string]int) (int, error) {
+	for ; i < len(items) && (items[i].is("param") || items[i].is("result")); i++ {
+		x := items[i]
+		args := x.list[1:]
+		if x.is("param") && len(args) == 2 && name(args[0]) != "" {
+			tp, err := valType(args[1])
+			if err != nil {
+				return i, err
+			}
+			if names != nil {
+				if _, ok := names[args[0].atom]; ok {
+					return i, &Error{x.line, "duplicate parameter " + args[0].atom}
+				}
+				names[args[0].atom] = len(t.Params)
+			}
+			t.Params = append(t.Params, tp)
+			continue
+		}
+		for _, a := range args {
+			tp, err := valType(a)
+			if err != nil {
+				return i, err
+			}
+			if x.is("param") {
+				t.Params = append(t.Params, tp)
+			} else {
+				t.Results = append(t.Results, tp)
+			}
+		}
+	}
+	return i, nil
+}
+
+// Reads (import "module" "name" (func $id (param ...) (result ...))).
+func (r *textReader) importField(x *sexpr) error {
+	if len(x.list) != 4 || !x.list[1].quoted || !x.list[2].quoted || !x.list[3].is("func") {
+		return &Error{x.line, "(import \"module\" \"name\" (func ...)) expected"}
+	}
+	imp := Import{Module: x.list[1].atom, Name: x.list[2].atom}
+	items := x.list[3].list[1:]
+	i := 0
+	if i < len(items) && name(items[i]) != "" {
+		imp.Func = items[i].atom
+		i += 1
+	}
+	i, err := typeUse(items, i, &imp.Type, nil)
+	if err != nil {
+		return err
+	}
+	if i < len(items) {
+		return &Error{items[i].line, "unexpected item in import"}
+	}
+	if err := r.define(r.funcs, imp.Func, len(r.m.Imports), x.line); err != nil {
+		return err
+	}
+	r.m.Imports = append(r.m.Imports, imp)
+	return nil
+}
+
+// Records the index of a named definition.
+func (r *textReader) define(names map[string]int, id string, index int, line int) error {
+	if id == "" {
+		return nil
+	}
+	if _, ok := names[id]; ok {
+		return &Error{line, "duplicate definition of " + id}
+	}
+	names[id] = index
+	return nil
+}
+
+// Reads the name, the parameters and the result of (func ...).
+func (r *textReader) funcHeader(x *sexpr) (*Func, error) {
+	fn := &Func{Names: map[string]int{}, Line: x.line}
+	items := x.list[1:]
+	i := 0
+	if i < len(items) && name(items[i]) != "" {
+		fn.Name = items[i].atom
+		i += 1
+	}
+	if _, err := typeUse(items, i, &fn.Type, fn.Names); err != nil {
+		return nil, err
+	}
+	if err := r.define(r.funcs, fn.Name, len(r.m.Imports)+len(r.m.Funcs), x.line); err != nil {
+		return nil, err
+	}
+	r.m.Funcs = append(r.m.Funcs, fn)
+	return fn, nil
+}
+
+// Reads the locals and the instructions of a function.
+func (r *textReader) body(fn *Func, x *sexpr) error {
+	items := x.list[1:]
+	i := 0
+	for i < len(items) && (name(items[i]) != "" || items[i].is("param") || items[i].is("result")) {
+		i += 1
+	}
+	for ; i < len(items) && items[i].is("local"); i++ {
+		args := items[i].list[1:]
+		if len(args) == 2 && name(args[0]) != "" {
+			tp, err := valType(args[1])
+			if err != nil {
+				return err
+			}
+			if err := r.define(fn.Names, args[0].atom, len(fn.Type.Params)+len(fn.Locals), items[i].line); err != nil {
+				return err
+			}
+			fn.Locals = append(fn.Locals, tp)
+			continue
+		}
+		for _, a := range args {
+			tp, err := valType(a)
+			if err != nil {
+				return err
+			}
+			fn.Locals = append(fn.Locals, tp)
+		}
+	}
+	for i < len(items) {
+		in, next, err := r.instr(fn, items, i)
+		if err != nil {
+			return err
+		}
+		fn.Body = append(fn.Body, in)
+		i = next
+	}
+	return nil
+}
+
+// Reads the instruction at items[i] with its operands. Returns the index of
+// the next instruction.
+func (r *textReader) instr(fn *Func, items []*sexpr, i int) (Instr, int, error) {
+	x := items[i]
+	if x.isList {
+		return Instr{}, i, &Error{x.line, "folded instructions are not supported"}
+	}
+	op, ok := ops[x.atom]
+	if x.quoted || !ok {
+		return Instr{}, i, &Error{x.line, "unknown instruction " + x.atom}
+	}
+	in := Instr{Op: x.atom, Line: x.line}
+	i += 1
+	if op.imm == immBlock {
+		in.Block = NoType
+		if i < len(items) && items[i].is("result") {
+			if len(items[i].list) != 2 {
+				return in, i, &Error{items[i].line, "one result type expected"}
+			}
+			tp, err := valType(items[i].list[1])
+			if err != nil {
+				return in, i, err
+			}
+			in.Block = tp
+			i += 1
+		}
+		return in, i, nil
+	}
+	if op.imm == immMem {
+		for i < len(items) && !items[i].isList && strings.HasPrefix(items[i].atom, "offset=") {
+			n, err := strconv.ParseUint(strings.TrimPrefix(items[i].atom, "offset="), 0, 32)
+			if err != nil {
+				return in, i, &Error{items[i].line, "malformed offset " + items[i].atom}
+			}
+			in.Arg = int32(n)
+			i += 1
+		}
+		return in, i, nil
+	}
+	if op.imm == immNone {
+		return in, i, nil
+	}
+	if i >= len(items) || items[i].isList || items[i].quoted {
+		return in, i, &Error{x.line, x.atom + " needs an operand"}
+	}
+	arg := items[i].atom
+	var err error
+	switch op.imm {
+	case immConst:
+		var n int64
+		n, err = strconv.ParseInt(arg, 0, 64)
+		if err != nil || n < -1<<31 || n >= 1<<32 {
+			err = &Error{x.line, "malformed i32 constant " + arg}
+		}
+		in.Arg = int32(n)
+	case immLocal:
+		in.Arg, err = index(arg, fn.Names, len(fn.Type.Params)+len(fn.Locals), "local", x.line)
+	case immGlobal:
+		in.Arg, err = index(arg, r.globals, len(r.m.Globals), "global", x.line)
+	case immFunc:
+		in.Arg, err = index(arg, r.funcs, len(r.m.Imports)+len(r.m.Funcs), "function", x.line)
+	case immDepth:
+		var n uint64
+		n, err = strconv.ParseUint(arg, 10, 32)
+		if err != nil {
+			err = &Error{x.line, "branch depth expected, found " + arg}
+		}
+		in.Arg = int32(n)
+	}
+	return in, i + 1, err
+}
+
+// Resolves a reference by name or by number to an index below count.
+func index(arg string, names map[string]int, count int, kind string, line int) (int32, error) {
+	if strings.HasPrefix(arg, "$") {
+		if n, ok := names[arg]; ok {
+			return int32(n), nil
+		}
+		return 0, &Error{line, "unknown " + kind + " " + arg}
+	}
+	n, err := strconv.ParseUint(arg, 10, 32)
+	if err != nil || int(n) >= count {
+		return 0, &Error{line, "unknown " + kind + " " + arg}
+	}
+	return int32(n), nil
+}
+
+// Reads (global $id (mut i32) i32.const n); the initial value may also be
+// written as (i32.const n).
+func (r *textReader) globalField(x *sexpr) error {
+	items := x.list[1:]
+	g := Global{}
+	i := 0
+	if i < len(items) && name(items[i]) != "" {
+		g.Name = items[i].atom
+		i += 1
+	}
+	if i >= len(items) {
+		return &Error{x.line, "global type expected"}
+	}
+	tp := items[i]
+	if tp.is("mut") && len(tp.list) == 2 {
+		g.Mutable = true
+		tp = tp.list[1]
+	}
+	var err error
+	if g.Type, err = valType(tp); err != nil {
+		return err
+	}
+	init := items[i+1:]
+	if len(init) == 1 && init[0].is("i32.const") {
+		init = init[0].list
+	}
+	if len(init) != 2 || init[0].isList || init[0].atom != "i32.const" || init[1].isList {
+		return &Error{x.line, "i32.const initializer expected"}
+	}
+	n, err := strconv.ParseInt(init[1].atom, 0, 32)
+	if err != nil {
+		return &Error{x.line, "malformed i32 constant " + init[1].atom}
+	}
+	g.Init = int32(n)
+	if err := r.define(r.globals, g.Name, len(r.m.Globals), x.line); err != nil {
+		return err
+	}
+	r.m.Globals = append(r.m.Globals, g)
+	return nil
+}
+
+// Reads (memory n).
+func (r *textReader) memoryField(x *sexpr) error {
+	if r.m.Memory >= 0 {
+		return &Error{x.line, "only one memory is allowed"}
+	}
+	if len(x.list) != 2 || x.list[1].isList {
+		return &Error{x.line, "(memory n) expected"}
+	}
+	n, err := strconv.ParseUint(x.list[1].atom, 10, 16)
+	if err != nil || n > MaxPages {
+		return &Error{x.line, "malformed memory size " + x.list[1].atom}
+	}
+	r.m.Memory = int(n)
+	return nil
+}
+
+// Reads (start $f).
+func (r *textReader) startField(x *sexpr) error {
+	if len(x.list) != 2 || x.list[1].isList {
+		return &Error{x.line, "(start $f) expected"}
+	}
+	n, err := index(x.list[1].atom, r.funcs, len(r.m.Imports)+len(r.m.Funcs), "function", x.line)
+	if err != nil {
+		return err
+	}
+	if t := r.m.FuncType(int(n)); len(t.Params) > 0 || len(t.Results) > 0 {
+		return &Error{x.line, "start function must have no parameters and no result"}
+	}
+	r.m.Start = int(n)
+	return nil
+}
+
+// Reads (export "name" (func $f)), (export "name" (memory 0)) or
+// (export "name" (global $g)).
+func (r *textReader) exportField(x *sexpr) error {
+	if len(x.list) != 3 || !x.list[1].quoted || !x.list[2].isList || len(x.list[2].list) != 2 {
+		return &Error{x.line, "(export \"name\" (kind index)) expected"}
+	}
+	desc := x.list[2]
+	arg := desc.list[1].atom
+	exp := Export{Name: x.list[1].atom}
+	var n int32
+	var err error
+	switch {
+	case desc.is("func"):
+		exp.Kind = ExportFunc
+		n, err = index(arg, r.funcs, len(r.m.Imports)+len(r.m.Funcs), "function", x.line)
+	case desc.is("global"):
+		exp.Kind = ExportGlobal
+		n, err = index(arg, r.globals, len(r.m.Globals), "global", x.line)
+	case desc.is("memory"):
+		exp.Kind = ExportMemory
+		if arg != "0" || r.m.Memory < 0 {
+			err = &Error{x.line, "unknown memory " + arg}
+		}
+	default:
+		err = &Error{x.line, "func, memory or global expected"}
+	}
+	if err != nil {
+		return err
+	}
+	for _, e := range r.m.Exports {
+		if e.Name == exp.Name {
+			return &Error{x.line, "duplicate export " + exp.Name}
+		}
+	}
+	exp.Index = int(n)
+	r.m.Exports = append(r.m.Exports, exp)
+	return nil
+}
@@ -0,0 +1,284 @@
+package wasm
+
+import (
+	"strconv"
+)
+
+// A block being checked: the function body, block, loop or if.
+type frame struct {
+	op          string
+	result      ValType // NoType if the block has no result.
+	height      int     // Height of the operand stack when the block was entered.
+	unreachable bool    // The rest of the block cannot be reached, after br or return.
+	hasElse     bool
+	line        int
+}
+
+// A value on the operand stack and the line of the instruction that pushed it.
+type operand struct {
+	tp   ValType // NoType for values of unreachable code, which match any type.
+	line int
+}
+
+// Checks the instructions of one function.
+type validator struct {
+	m      *Module
+	fn     *Func
+	stack  []operand
+	frames []frame
+}
+
+// Checks that a module is valid: blocks are nested properly, every
+// instruction finds operands of the right type on the stack, and locals,
+// globals, functions and branch targets exist, and that the memory has at most
+// MaxPages pages. Returns the first problem in every function that has one.
+func Validate(m *Module) []*Error {
+	errs := []*Error{}
+	if m.Memory > MaxPages {
+		errs = append(errs, &Error{0, "memory of more than " + strconv.Itoa(MaxPages) + " pages"})
+	}
+	for _, fn := range m.Funcs {
+		v := &validator{m: m, fn: fn}
+		if err := v.function(); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errs
+}
+
+// Reads a module in the text format and checks it.
+func Check(text string) []*Error {
+	m, err := Parse(text)
+	if err != nil {
+		return []*Error{err.(*Error)}
+	}
+	return Validate(m)
+}
+
+// Pushes a value pushed by the instruction on line.
+func (v *validator) push(tp ValType, line int) {
+	v.stack = append(v.stack, operand{tp, line})
+}
+
+// Pops a value of type tp for in.
+func (v *validator) pop(tp ValType, in Instr) (operand, *Error) {
+	f := &v.frames[len(v.frames)-1]
+	if len(v.stack) == f.height {
+		if f.unreachable {
+			return operand{NoType, in.Line}, nil
+		}
+		return operand{}, &Error{in.Line, "stack underflow: " + in.Op + " needs more operands"}
+	}
+	x := v.stack[len(v.stack)-1]
+	v.stack = v.stack[:len(v.stack)-1]
+	if x.tp != NoType && tp != NoType && x.tp != tp {
+		return x, &Error{in.Line, in.Op + ": operand has the wrong type"}
+	}
+	return x, nil
+}
+
+// Pops n i32 values for in.
+func (v *validator) popI32(n int, in Instr) *Error {
+	for ; n > 0; n-- {
+		if _, err := v.pop(I32, in); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Returns the number of values a branch to the block depth blocks out passes.
+func (v *validator) labelTypes(depth int32, in Instr) (int, *Error) {
+	if int(depth) >= len(v.frames) {
+		return 0, &Error{in.Line, in.Op + " " + strconv.Itoa(int(depth)) + ": no block at that depth"}
+	}
+	f := v.frames[len(v.frames)-1-int(depth)]
+	if f.op == "loop" || f.result == NoType {
+		return 0, nil
+	}
+	return 1, nil
+}
+
+// Checks that the innermost block leaves exactly its result on the stack.
+func (v *validator) endBlock(in Instr) *Error {
+	f := v.frames[len(v.frames)-1]
+	want := 0
+	if f.result != NoType {
+		want = 1
+	}
+	if err := v.popI32(want, in); err != nil {
+		if f.op == "func" {
+			return &Error{in.Line, "function " + v.fn.Name + " must leave a result on the stack"}
+		}
+		return err
+	}
+	if len(v.stack) > f.height {
+		x := v.stack[f.height]
+		what := f.op
+		if f.op == "func" {
+			what = "function " + v.fn.Name
+		}
+		return &Error{x.line, "value left on the stack at the end of " + what + " is never used"}
+	}
+	return nil
+}
+
+// Marks the rest of the innermost block as unreachable.
+func (v *validator) unreachable() {
+	f := &v.frames[len(v.frames)-1]
+	v.stack = v.stack[:f.height]
+	f.unreachable = true
+}
+
+// Checks a function body.
+func (v *validator) function() *Error {
+	result := NoType
+	if len(v.fn.Type.Results) == 1 {
+		result = v.fn.Type.Results[0]
+	} else if len(v.fn.Type.Results) > 1 {
+		return &Error{v.fn.Line, "function " + v.fn.Name + " has more than one result"}
+	}
+	v.frames = []frame{{op: "func", result: result, line: v.fn.Line}}
+	for _, in := range v.fn.Body {
+		if err := v.instr(in); err != nil {
+			return err
+		}
+	}
+	if len(v.frames) > 1 {
+		f := v.frames[len(v.frames)-1]
+		return &Error{f.line, f.op + " is not closed by end"}
+	}
+	line := v.fn.Line
+	if n := len(v.fn.Body); n > 0 {
+		line = v.fn.Body[n-1].Line
+	}
+	return v.endBlock(Instr{Op: "end", Line: line})
+}
+
+// Checks one instruction.
+func (v *validator) instr(in Instr) *Error {
+	op, ok := ops[in.Op]
+	if !ok {
+		return &Error{in.Line, "unknown instruction " + in.Op}
+	}
+	switch op.imm {
+	case immLocal:
+		if int(in.Arg) >= len(v.fn.Type.Params)+len(v.fn.Locals) || in.Arg < 0 {
+			return &Error{in.Line, "unknown local " + strconv.Itoa(int(in.Arg))}
+		}
+	case immGlobal:
+		if int(in.Arg) >= len(v.m.Globals) || in.Arg < 0 {
+			return &Error{in.Line, "unknown global " + strconv.Itoa(int(in.Arg))}
+		}
+	case immFunc:
+		if int(in.Arg) >= len(v.m.Imports)+len(v.m.Funcs) || in.Arg < 0 {
+			return &Error{in.Line, "unknown function " + strconv.Itoa(int(in.Arg))}
+		}
+	case immMem:
+		if v.m.Memory < 0 {
+			return &Error{in.Line, in.Op + " needs a memory"}
+		}
+	}
+
+	switch in.Op {
+	case "unreachable":
+		v.unreachable()
+	case "nop":
+	case "block", "loop":
+		v.frames = append(v.frames, frame{op: in.Op, result: in.Block, height: len(v.stack), line: in.Line})
+	case "if":
+		if err := v.popI32(1, in); err != nil {
+			return err
+		}
+		v.frames = append(v.frames, frame{op: in.Op, result: in.Block, height: len(v.stack), line: in.Line})
+	case "else":
+		f := &v.frames[len(v.frames)-1]
+		if f.op != "if" || f.hasElse {
+			return &Error{in.Line, "else without if"}
+		}
+		if err := v.endBlock(in); err != nil {
+			return err
+		}
+		v.stack = v.stack[:f.height]
+		f.unreachable = false
+		f.hasElse = true
+	case "end":
+		if len(v.frames) == 1 {
+			return &Error{in.Line, "end without block"}
+		}
+		f := v.frames[len(v.frames)-1]
+		if f.op == "if" && f.result != NoType && !f.hasElse {
+			return &Error{in.Line, "if with a result needs else"}
+		}
+		if err := v.endBlock(in); err != nil {
+			return err
+		}
+		v.frames = v.frames[:len(v.frames)-1]
+		if f.result != NoType {
+			v.push(f.result, in.Line)
+		}
+	case "br", "br_if":
+		n, err := v.labelTypes(in.Arg, in)
+		if err != nil {
+			return err
+		}
+		if in.Op == "br_if" {
+			if err := v.popI32(1, in); err != nil {
+				return err
+			}
+		}
+		if err := v.popI32(n, in); err != nil {
+			return err
+		}
+		if in.Op == "br" {
+			v.unreachable()
+		} else if n > 0 {
+			v.push(I32, in.Line)
+		}
+	case "return":
+		if err := v.popI32(len(v.fn.Type.Results), in); err != nil {
+			return err
+		}
+		v.unreachable()
+	case "call":
+		t := v.m.FuncType(int(in.Arg))
+		if err := v.popI32(len(t.Params), in); err != nil {
+			return &Error{in.Line, "call " + v.m.FuncName(int(in.Arg)) + " needs " + strconv.Itoa(len(t.Params)) + " arguments"}
+		}
+		for _, r := range t.Results {
+			v.push(r, in.Line)
+		}
+	case "drop":
+		if _, err := v.pop(NoType, in); err != nil {
+			return err
+		}
+	case "select":
+		if err := v.popI32(3, in); err != nil {
+			return err
+		}
+		v.push(I32, in.Line)
+	case "local.get", "global.get", "i32.const":
+		v.push(I32, in.Line)
+	case "local.set":
+		return v.popI32(1, in)
+	case "i32.store":
+		return v.popI32(2, in)
+	case "global.set":
+		if !v.m.Globals[in.Arg].Mutable {
+			return &Error{in.Line, "global " + v.m.Globals[in.Arg].Name + " is immutable"}
+		}
+		return v.popI32(1, in)
+	case "local.tee", "i32.load", "i32.eqz":
+		if err := v.popI32(1, in); err != nil {
+			return err
+		}
+		v.push(I32, in.Line)
+	default:
+		// All other instructions take two i32 values and produce one.
+		if err := v.popI32(2, in); err != nil {
+			return err
+		}
+		v.push(I32, in.Line)
+	}
+	return nil
+}
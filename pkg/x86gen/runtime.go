@@ -0,0 +1,216 @@
+package x86gen
+
+// Runtime of compiled programs, put at the end of every generated file. It
+// provides read, write and writeln like the P0lib imports of WASM programs
+// with Linux system calls and buffered input and output, and division that
+// traps on zero the way WASM does.
+const Runtime = `
+# p0_write: writes %edi in decimal after a space.
+p0_write:
+	movl %edi, %eax
+	leaq p0_digits+16(%rip), %rsi
+	movl %eax, %r8d
+	testl %eax, %eax
+	jns 1f
+	negl %eax
+1:	movl $10, %r9d
+2:	xorl %edx, %edx
+	divl %r9d
+	addb $48, %dl
+	decq %rsi
+	movb %dl, (%rsi)
+	testl %eax, %eax
+	jnz 2b
+	testl %r8d, %r8d
+	jns 3f
+	decq %rsi
+	movb $45, (%rsi)
+3:	decq %rsi
+	movb $32, (%rsi)
+	leaq p0_digits+16(%rip), %rdx
+	subq %rsi, %rdx
+	jmp p0_put
+
+# p0_writeln: writes a newline and flushes the output.
+p0_writeln:
+	leaq p0_newline(%rip), %rsi
+	movq $1, %rdx
+	call p0_put
+	jmp p0_flush
+
+# p0_put: appends %rdx bytes at %rsi to the output buffer.
+p0_put:
+	movq p0_outlen(%rip), %rax
+	addq %rdx, %rax
+	cmpq $4096, %rax
+	jbe 1f
+	pushq %rsi
+	pushq %rdx
+	call p0_flush
+	popq %rdx
+	popq %rsi
+1:	leaq p0_out(%rip), %rdi
+	addq p0_outlen(%rip), %rdi
+	addq %rdx, p0_outlen(%rip)
+	movq %rdx, %rcx
+	rep movsb
+	ret
+
+# p0_flush: writes the output buffer to standard output.
+p0_flush:
+	leaq p0_out(%rip), %rsi
+	movq p0_outlen(%rip), %rdx
+1:	testq %rdx, %rdx
+	jz 2f
+	movq $1, %rax
+	movq $1, %rdi
+	syscall
+	testq %rax, %rax
+	jle 2f
+	addq %rax, %rsi
+	subq %rax, %rdx
+	jmp 1b
+2:	movq $0, p0_outlen(%rip)
+	ret
+
+# p0_getc: returns the next byte of standard input in %eax, -1 at its end.
+p0_getc:
+	movq p0_inpos(%rip), %rax
+	cmpq p0_inlen(%rip), %rax
+	jb 1f
+	xorl %eax, %eax
+	xorl %edi, %edi
+	leaq p0_in(%rip), %rsi
+	movl $4096, %edx
+	syscall
+	testq %rax, %rax
+	jg 2f
+	movl $-1, %eax
+	ret
+2:	movq %rax, p0_inlen(%rip)
+	xorl %eax, %eax
+1:	leaq p0_in(%rip), %rsi
+	movzbl (%rsi,%rax), %edx
+	incq %rax
+	movq %rax, p0_inpos(%rip)
+	movl %edx, %eax
+	ret
+
+# p0_read: reads a decimal integer from standard input into %eax, 0 at its end.
+p0_read:
+	call p0_flush
+1:	call p0_getc
+	cmpl $-1, %eax
+	je 6f
+	cmpl $32, %eax
+	jle 1b
+	xorl %r8d, %r8d
+	cmpl $45, %eax
+	jne 2f
+	movl $1, %r8d
+	call p0_getc
+2:	xorl %r9d, %r9d
+3:	cmpl $48, %eax
+	jl 4f
+	cmpl $57, %eax
+	jg 4f
+	imull $10, %r9d, %r9d
+	subl $48, %eax
+	addl %eax, %r9d
+	call p0_getc
+	jmp 3b
+4:	movl %r9d, %eax
+	testl %r8d, %r8d
+	jz 5f
+	negl %eax
+5:	ret
+6:	xorl %eax, %eax
+	ret
+
+# p0_div: divides %eax by %ecx.
+p0_div:
+	testl %ecx, %ecx
+	jz p0_divzero
+	cmpl $-1, %ecx
+	jne 1f
+	cmpl $-2147483648, %eax
+	je p0_overflow
+	negl %eax
+	ret
+1:	cltd
+	idivl %ecx
+	ret
+
+# p0_mod: returns the remainder of %eax divided by %ecx.
+p0_mod:
+	testl %ecx, %ecx
+	jz p0_divzero
+	cmpl $-1, %ecx
+	jne 1f
+	xorl %eax, %eax
+	ret
+1:	cltd
+	idivl %ecx
+	movl %edx, %eax
+	ret
+
+p0_divzero:
+	leaq p0_divmsg(%rip), %rsi
+	movq $23, %rdx
+	jmp p0_trap
+
+p0_range:
+	leaq p0_rangemsg(%rip), %rsi
+	movq $19, %rdx
+	jmp p0_trap
+
+p0_overflow:
+	leaq p0_overmsg(%rip), %rsi
+	movq $17, %rdx
+
+# p0_trap: writes the %rdx bytes at %rsi to standard error and exits with 1.
+p0_trap:
+	pushq %rsi
+	pushq %rdx
+	call p0_flush
+	popq %rdx
+	popq %rsi
+	movq $1, %rax
+	movq $2, %rdi
+	syscall
+	movq $60, %rax
+	movq $1, %rdi
+	syscall
+
+# p0_exit: flushes the output and exits with 0.
+p0_exit:
+	call p0_flush
+	movq $60, %rax
+	xorl %edi, %edi
+	syscall
+
+	.section .rodata
+p0_newline:
+	.ascii "\n"
+p0_divmsg:
+	.ascii "integer divide by zero\n"
+p0_overmsg:
+	.ascii "integer overflow\n"
+p0_rangemsg:
+	.ascii "value out of range\n"
+
+	.bss
+	.align 8
+p0_outlen:
+	.zero 8
+p0_inpos:
+	.zero 8
+p0_inlen:
+	.zero 8
+p0_digits:
+	.zero 16
+p0_out:
+	.zero 4096
+p0_in:
+	.zero 4096
+`
@@ -0,0 +1,581 @@
+// x86-64 code generator: translates P0 programs into GNU assembler for Linux,
+// which as and ld turn into a native executable without a C library.
+//
+// Values are computed on the machine stack. Every procedure has a frame:
+//
+//	24+8*(n-1-i)(%rbp)  parameter i of n, a value or, for var parameters, an address
+//	16(%rbp)            static link: the frame of the enclosing procedure
+//	8(%rbp)             return address
+//	0(%rbp)             frame of the caller
+//	-8(%rbp) ...        local variables and copies of record and array parameters
+//
+// Variables of enclosing procedures are found by following static links.
+package x86gen
+
+import (
+	"fmt"
+	"group-11/pkg/diag"
+	k "group-11/pkg/keywords"
+	"group-11/pkg/source"
+	st "group-11/pkg/symtable"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// A procedure whose code is being generated.
+type procFrame struct {
+	label  string
+	size   int      // Bytes of local variables and parameter copies.
+	copies [][3]int // Record and array value parameters: slot, offset of the copy, size.
+}
+
+// Output of the x86-64 code generator and the state it keeps while generating.
+// Items are symbol table entries as for WASM: a "var" is in memory, a "ref"
+// holds the address of its value, and Lev -1 means that the value or the
+// address is on the stack.
+type Emitter struct {
+	Lines   []string
+	Curlev  int         // Current scope level.
+	At      source.Span // Construct being generated, for error messages.
+	labels  int
+	symbols map[string]bool     // Symbols in use.
+	procs   []map[string]string // Symbol of every procedure, per scope level.
+	frames  []*procFrame
+	loops   []string
+	errors  diag.Handler
+}
+
+// Creates an Emitter that reports errors to errh.
+func NewEmitter(errh diag.Handler) *Emitter {
+	return &Emitter{Lines: []string{}, symbols: map[string]bool{}, procs: []map[string]string{{}}, errors: errh}
+}
+
+// Reports an error at the construct being generated.
+func (e *Emitter) mark(msg string) {
+	e.errors(diag.NewError("E400", e.At, msg))
+}
+
+// Sets the construct being generated, for error messages.
+func (e *Emitter) SetAt(span source.Span) {
+	e.At = span
+}
+
+// Appends an instruction.
+func (e *Emitter) emit(format string, args ...interface{}) {
+	e.Lines = append(e.Lines, "\t"+fmt.Sprintf(format, args...))
+}
+
+// Appends a label.
+func (e *Emitter) label(name string) {
+	e.Lines = append(e.Lines, name+":")
+}
+
+// Returns a new local label.
+func (e *Emitter) newLabel() string {
+	e.labels += 1
+	return ".L" + strconv.Itoa(e.labels)
+}
+
+// Returns the assembler name of a P0 identifier. Every name gets a trailing
+// underscore, so that it cannot clash with the runtime, and characters the
+// assembler does not allow are spelled out.
+func Mangle(name string) string {
+	b := strings.Builder{}
+	for _, r := range name {
+		if r < utf8.RuneSelf && (r == '_' || '0' <= r && r <= '9' || 'a' <= r && r <= 'z' || 'A' <= r && r <= 'Z') {
+			b.WriteRune(r)
+		} else if r <= 0xFFFF {
+			fmt.Fprintf(&b, "_u%04x", r)
+		} else {
+			fmt.Fprintf(&b, "_U%08x", r)
+		}
+	}
+	b.WriteString("_")
+	return b.String()
+}
+
+// Returns an unused symbol for a P0 identifier. Procedures in different
+// scopes can have the same name.
+func (e *Emitter) symbol(name string) string {
+	sym := Mangle(name)
+	for i := 2; e.symbols[sym]; i++ {
+		sym = Mangle(name) + "." + strconv.Itoa(i)
+	}
+	e.symbols[sym] = true
+	return sym
+}
+
+// Returns an item for a value in %eax pushed on the stack.
+func (e *Emitter) pushed(tp st.PrimitiveType) *st.SymTableEntry {
+	e.emit("pushq %%rax")
+	y := st.Var(tp)
+	y.Lev = -1
+	return y
+}
+
+// Returns the operand for the memory of a variable at level Lev. The frame of
+// an enclosing procedure is found by following static links in %r11.
+func (e *Emitter) operand(x *st.SymTableEntry) string {
+	if x.Lev == 0 {
+		return Mangle(x.Name) + "+" + strconv.Itoa(x.Adr) + "(%rip)"
+	} else if x.Lev == e.Curlev {
+		return strconv.Itoa(x.Adr) + "(%rbp)"
+	}
+	e.frameOf(x.Lev)
+	return strconv.Itoa(x.Adr) + "(%r11)"
+}
+
+// Loads the frame of level lev into %r11 by following static links.
+func (e *Emitter) frameOf(lev int) {
+	e.emit("movq %%rbp, %%r11")
+	for l := e.Curlev; l > lev; l-- {
+		e.emit("movq 16(%%r11), %%r11")
+	}
+}
+
+// Loads the value of an item into %eax.
+func (e *Emitter) load(x *st.SymTableEntry) {
+	if x.EntryType == "const" {
+		e.emit("movl $%d, %%eax", x.Val)
+	} else if x.EntryType == "var" && x.Lev == -1 {
+		e.emit("popq %%rax")
+	} else if x.EntryType == "var" {
+		e.emit("movl %s, %%eax", e.operand(x))
+	} else if x.EntryType == "ref" && x.Lev == -1 {
+		e.emit("popq %%rax")
+		e.emit("movl (%%rax), %%eax")
+	} else if x.EntryType == "ref" {
+		e.emit("movq %s, %%rax", e.operand(x))
+		e.emit("movl (%%rax), %%eax")
+	} else {
+		e.mark("x86: cannot load")
+	}
+}
+
+// Stores %eax into the variable an item stands for. An address on the stack
+// is below the value that was stored, so it is popped last.
+func (e *Emitter) store(x *st.SymTableEntry) {
+	if x.EntryType == "var" && x.Lev >= 0 {
+		e.emit("movl %%eax, %s", e.operand(x))
+	} else if x.EntryType == "ref" && x.Lev == -1 {
+		e.emit("popq %%rcx")
+		e.emit("movl %%eax, (%%rcx)")
+	} else if x.EntryType == "ref" {
+		e.emit("movq %s, %%rcx", e.operand(x))
+		e.emit("movl %%eax, (%%rcx)")
+	} else {
+		e.mark("x86: cannot store")
+	}
+}
+
+// Pushes the address of a variable, unless it is already on the stack.
+func (e *Emitter) pushAddress(x *st.SymTableEntry) {
+	if x.EntryType == "var" && x.Lev >= 0 {
+		e.emit("leaq %s, %%rax", e.operand(x))
+		e.emit("pushq %%rax")
+	} else if x.EntryType == "ref" && x.Lev >= 0 {
+		e.emit("movq %s, %%rax", e.operand(x))
+		e.emit("pushq %%rax")
+	} else if x.EntryType != "ref" {
+		e.mark("x86: not a variable")
+	}
+}
+
+// Generates the start of programs.
+func (e *Emitter) GenProgStart() {
+	e.Lines = append(e.Lines, "# Generated by the P0 compiler.", "\t.text")
+}
+
+// Specifies the Size of bool typed entries.
+func (e *Emitter) GenBool(entry *st.SymTableEntry) *st.SymTableEntry {
+	entry.Size = 4
+	return entry
+}
+
+// Specifies the Size of int typed entries.
+func (e *Emitter) GenInt(entry *st.SymTableEntry) *st.SymTableEntry {
+	entry.Size = 4
+	return entry
+}
+
+// Generates records, calculating the offsets of the fields and the size.
+func (e *Emitter) GenRec(entry *st.SymTableEntry) *st.SymTableEntry {
+	s := 0
+	for _, f := range entry.Ctp.Fields {
+		f.Offset = s
+		s = s + f.Size
+	}
+	entry.Size = s
+	return entry
+}
+
+// Generates arrays, calculating the size.
+func (e *Emitter) GenArray(entry *st.SymTableEntry) *st.SymTableEntry {
+	entry.Size = entry.Ctp.Length * entry.Ctp.Size
+	return entry
+}
+
+// Generates the global variables in the bss section.
+func (e *Emitter) GenGlobalVars(scope []*st.SymTableEntry, start int) {
+	e.Lines = append(e.Lines, "\t.bss", "\t.align 8")
+	for _, x := range scope[start:] {
+		if x.EntryType == "var" {
+			e.symbols[Mangle(x.Name)] = true
+			e.label(Mangle(x.Name))
+			e.emit(".zero %d", x.Size)
+		}
+	}
+	e.Lines = append(e.Lines, "\t.text")
+}
+
+// Allocates the local variables of a procedure in its frame.
+func (e *Emitter) GenLocalVars(scope []*st.SymTableEntry, start int) {
+	f := e.frames[len(e.frames)-1]
+	for _, x := range scope[start:] {
+		if x.EntryType == "var" {
+			f.size += (x.Size + 7) &^ 7
+			x.Adr = -f.size
+		}
+	}
+}
+
+// Generates a variable.
+func (e *Emitter) GenVar(entry *st.SymTableEntry) *st.SymTableEntry {
+	y := st.Typed(entry.EntryType, entry)
+	y.Name = entry.Name
+	y.Lev = entry.Lev
+	y.Adr = entry.Adr
+	return y
+}
+
+// Constants are simply constants so they do not need any extra work.
+func (e *Emitter) GenConst(entry *st.SymTableEntry) *st.SymTableEntry {
+	return entry
+}
+
+// Generates code for operations with unary operators. For and and or, the
+// first operand decides whether the second one is evaluated; the label to
+// continue at is kept in Val.
+func (e *Emitter) GenUnaryOp(op int, x *st.SymTableEntry) *st.SymTableEntry {
+	e.load(x)
+	if op == k.MINUS {
+		e.emit("negl %%eax")
+		return e.pushed(st.Int)
+	} else if op == k.NOT {
+		e.emit("xorl $1, %%eax")
+		return e.pushed(st.Bool)
+	} else if op == k.AND || op == k.OR {
+		y := st.Var(st.Bool)
+		y.Val = e.labels + 1
+		e.emit("testl %%eax, %%eax")
+		if op == k.AND {
+			e.emit("jz %s", e.newLabel())
+		} else {
+			e.emit("jnz %s", e.newLabel())
+		}
+		return y
+	}
+	e.mark("x86: unary operator?")
+	return x
+}
+
+// Instructions for the arithmetic operators and the conditions of the
+// relations.
+var arithmetic = map[int]string{k.PLUS: "addl %ecx, %eax", k.MINUS: "subl %ecx, %eax", k.TIMES: "imull %ecx, %eax", k.DIV: "call p0_div", k.MOD: "call p0_mod"}
+var conditions = map[int]string{k.EQ: "e", k.NE: "ne", k.LT: "l", k.GT: "g", k.LE: "le", k.GE: "ge"}
+
+// Generates code for operations with binary operators. The second operand is
+// loaded first, as it is above the first one on the stack.
+func (e *Emitter) GenBinaryOp(op int, x *st.SymTableEntry, y *st.SymTableEntry) *st.SymTableEntry {
+	if op == k.AND || op == k.OR {
+		e.load(y)
+		end := e.newLabel()
+		e.emit("jmp %s", end)
+		e.label(".L" + strconv.Itoa(x.Val))
+		if op == k.AND {
+			e.emit("xorl %%eax, %%eax")
+		} else {
+			e.emit("movl $1, %%eax")
+		}
+		e.label(end)
+		return e.pushed(st.Bool)
+	}
+	e.load(y)
+	e.emit("movl %%eax, %%ecx")
+	e.load(x)
+	if code, ok := arithmetic[op]; ok {
+		e.Lines = append(e.Lines, "\t"+code)
+	} else {
+		e.mark("x86: binary operator?")
+	}
+	return e.pushed(st.Int)
+}
+
+// Generates the check that succ and pred stay in an enumeration. Compared
+// unsigned, negative values are out of range too.
+func (e *Emitter) GenRange(x *st.SymTableEntry, n int) *st.SymTableEntry {
+	e.load(x)
+	e.emit("cmpl $%d, %%eax", n)
+	e.emit("jae p0_range")
+	return e.pushed(st.Int)
+}
+
+// Generates relations between two entries, such as x > 5.
+func (e *Emitter) GenRelation(op int, x *st.SymTableEntry, y *st.SymTableEntry) *st.SymTableEntry {
+	e.load(y)
+	e.emit("movl %%eax, %%ecx")
+	e.load(x)
+	e.emit("cmpl %%ecx, %%eax")
+	e.emit("set%s %%al", conditions[op])
+	e.emit("movzbl %%al, %%eax")
+	return e.pushed(st.Bool)
+}
+
+// Returns an item typed like the field or element t for the same place as x.
+func retype(x *st.SymTableEntry, t *st.SymTableEntry) *st.SymTableEntry {
+	x.Tp = t.Tp
+	x.Ctp = t.Ctp
+	x.ArrOrRec = t.ArrOrRec
+	return x
+}
+
+// Generates selectors for records.
+func (e *Emitter) GenSelect(x *st.SymTableEntry, field *st.SymTableEntry) *st.SymTableEntry {
+	if x.EntryType == "var" {
+		x.Adr += field.Offset
+	} else {
+		e.pushAddress(x)
+		if field.Offset != 0 {
+			e.emit("addq $%d, (%%rsp)", field.Offset)
+		}
+		x.Lev = -1
+	}
+	return retype(x, field)
+}
+
+// Generates indexes for arrays.
+func (e *Emitter) GenIndex(x *st.SymTableEntry, y *st.SymTableEntry) *st.SymTableEntry {
+	elem := x.Ctp.Elem
+	if y.EntryType == "const" {
+		offset := (y.Val - x.Ctp.Lower) * x.Ctp.Size
+		if x.EntryType == "var" {
+			x.Adr += offset
+			return retype(x, elem)
+		}
+		e.pushAddress(x)
+		if offset != 0 {
+			e.emit("addq $%d, (%%rsp)", offset)
+		}
+	} else {
+		e.load(y)
+		e.emit("movslq %%eax, %%rax")
+		if x.Ctp.Lower != 0 {
+			e.emit("subq $%d, %%rax", x.Ctp.Lower)
+		}
+		e.emit("imulq $%d, %%rax", x.Ctp.Size)
+		if x.EntryType == "var" {
+			e.emit("leaq %s, %%rcx", e.operand(x))
+			e.emit("addq %%rcx, %%rax")
+			e.emit("pushq %%rax")
+		} else if x.Lev >= 0 {
+			e.emit("addq %s, %%rax", e.operand(x))
+			e.emit("pushq %%rax")
+		} else {
+			e.emit("addq %%rax, (%%rsp)")
+		}
+	}
+	x.EntryType = "ref"
+	x.Lev = -1
+	return retype(x, elem)
+}
+
+// Generates assignment to variables.
+func (e *Emitter) GenAssign(x *st.SymTableEntry, y *st.SymTableEntry) {
+	e.load(y)
+	e.store(x)
+}
+
+// Generates the entry to the program.
+func (e *Emitter) GenProgEntry(ident string) {
+	e.Lines = append(e.Lines, "", "\t.globl _start")
+	e.label("_start")
+	e.emit("movq %%rsp, %%rbp")
+}
+
+// Generates the exit of the program and returns the generated assembler.
+func (e *Emitter) GenProgExit(x *st.SymTableEntry) string {
+	e.emit("jmp p0_exit")
+	return strings.Join(e.Lines, "\n") + "\n" + Runtime
+}
+
+// Generates the start of a procedure. Its label and prologue follow once the
+// local variables and the nested procedures are generated.
+func (e *Emitter) GenProcStart(ident string, listOfParams []*st.SymTableEntry, result *st.SymTableEntry) {
+	f := &procFrame{label: e.symbol(ident)}
+	e.procs[e.Curlev][ident] = f.label
+	e.Curlev += 1
+	e.procs = append(e.procs, map[string]string{})
+	e.frames = append(e.frames, f)
+	n := len(listOfParams)
+	for i, fp := range listOfParams {
+		slot := 24 + 8*(n-1-i)
+		if fp.EntryType == "var" && (fp.ArrOrRec == "array" || fp.ArrOrRec == "record") {
+			// Passed by address and copied on entry.
+			f.size += (fp.Size + 7) &^ 7
+			fp.Adr = -f.size
+			f.copies = append(f.copies, [3]int{slot, fp.Adr, fp.Size})
+		} else {
+			fp.Adr = slot
+		}
+	}
+}
+
+// Generates the label and the prologue of a procedure, which clears the local
+// variables and copies record and array value parameters.
+func (e *Emitter) GenProcEntry() {
+	f := e.frames[len(e.frames)-1]
+	size := (f.size + 15) &^ 15
+	e.Lines = append(e.Lines, "")
+	e.label(f.label)
+	e.emit("pushq %%rbp")
+	e.emit("movq %%rsp, %%rbp")
+	if size > 0 {
+		e.emit("subq $%d, %%rsp", size)
+		e.emit("movq %%rsp, %%rdi")
+		e.emit("movl $%d, %%ecx", size)
+		e.emit("xorl %%eax, %%eax")
+		e.emit("rep stosb")
+	}
+	for _, c := range f.copies {
+		e.emit("movq %d(%%rbp), %%rsi", c[0])
+		e.emit("leaq %d(%%rbp), %%rdi", c[1])
+		e.emit("movl $%d, %%ecx", c[2])
+		e.emit("rep movsb")
+	}
+}
+
+// Generates the value a function returns, which is passed in %eax.
+func (e *Emitter) GenReturn(x *st.SymTableEntry) {
+	e.load(x)
+}
+
+// Generates the epilogue of a procedure.
+func (e *Emitter) GenProcExit(x *st.SymTableEntry) {
+	e.emit("movq %%rbp, %%rsp")
+	e.emit("popq %%rbp")
+	e.emit("ret")
+	e.frames = e.frames[:len(e.frames)-1]
+	e.procs = e.procs[:len(e.procs)-1]
+	e.Curlev -= 1
+}
+
+// Generates the actual parameters: the address of var, record and array
+// parameters, the value of any other.
+func (e *Emitter) GenActualPara(ap *st.SymTableEntry, fp *st.SymTableEntry) *st.SymTableEntry {
+	if fp.EntryType == "ref" || fp.ArrOrRec == "array" || fp.ArrOrRec == "record" {
+		e.pushAddress(ap)
+	} else {
+		e.load(ap)
+		e.emit("pushq %%rax")
+	}
+	return ap
+}
+
+// Generates procedure calls. The static link is the frame of the scope the
+// procedure is declared in. The result of a function is pushed.
+func (e *Emitter) GenCall(entry *st.SymTableEntry) *st.SymTableEntry {
+	if entry.Lev == 0 {
+		e.emit("pushq $0")
+	} else if entry.Lev == e.Curlev {
+		e.emit("pushq %%rbp")
+	} else {
+		e.frameOf(entry.Lev)
+		e.emit("pushq %%r11")
+	}
+	e.emit("call %s", e.procs[entry.Lev][entry.Name])
+	e.emit("addq $%d, %%rsp", 8*(len(entry.Par)+1))
+	if entry.Result != nil {
+		return e.pushed(entry.Result.Tp)
+	}
+	return entry
+}
+
+// Generates call to the runtime read().
+func (e *Emitter) GenRead(x *st.SymTableEntry) {
+	e.emit("call p0_read")
+	e.store(x)
+}
+
+// Generates call to the runtime write().
+func (e *Emitter) GenWrite(x *st.SymTableEntry) {
+	e.load(x)
+	e.emit("movl %%eax, %%edi")
+	e.emit("call p0_write")
+}
+
+// Generates call to the runtime writeln().
+func (e *Emitter) GenWriteln() {
+	e.emit("call p0_writeln")
+}
+
+// Dummy function for generating sequences.
+func (e *Emitter) GenSeq(x *st.SymTableEntry, y *st.SymTableEntry) {
+	//pass
+}
+
+// Generates then. The label of the else part is kept in Val.
+func (e *Emitter) GenThen(x *st.SymTableEntry) *st.SymTableEntry {
+	e.load(x)
+	e.emit("testl %%eax, %%eax")
+	y := st.Var(st.Bool)
+	y.Val = e.labels + 1
+	e.emit("jz %s", e.newLabel())
+	return y
+}
+
+// Generates if/then.
+func (e *Emitter) GenIfThen(x *st.SymTableEntry, y *st.SymTableEntry) *st.SymTableEntry {
+	e.label(".L" + strconv.Itoa(x.Val))
+	return x
+}
+
+// Generates else. The label of the end is kept in Val.
+func (e *Emitter) GenElse(x *st.SymTableEntry, y *st.SymTableEntry) *st.SymTableEntry {
+	z := st.Var(st.None)
+	z.Val = e.labels + 1
+	e.emit("jmp %s", e.newLabel())
+	e.label(".L" + strconv.Itoa(x.Val))
+	return z
+}
+
+// Generates if/else
+func (e *Emitter) GenIfElse(x *st.SymTableEntry, y *st.SymTableEntry, z *st.SymTableEntry) *st.SymTableEntry {
+	e.label(".L" + strconv.Itoa(y.Val))
+	return x
+}
+
+// Generates while.
+func (e *Emitter) GenWhile() {
+	loop := e.newLabel()
+	e.loops = append(e.loops, loop)
+	e.label(loop)
+}
+
+// Generates do. The label of the exit is kept in Val.
+func (e *Emitter) GenDo(x *st.SymTableEntry) *st.SymTableEntry {
+	e.load(x)
+	e.emit("testl %%eax, %%eax")
+	y := st.Var(st.Bool)
+	y.Val = e.labels + 1
+	e.emit("jz %s", e.newLabel())
+	return y
+}
+
+// Generates while/do.
+func (e *Emitter) GenWhileDo(x *st.SymTableEntry, y *st.SymTableEntry) {
+	loop := e.loops[len(e.loops)-1]
+	e.loops = e.loops[:len(e.loops)-1]
+	e.emit("jmp %s", loop)
+	e.label(".L" + strconv.Itoa(x.Val))
+}